@@ -1,27 +1,234 @@
 package authapi
 
 import (
+	"crypto"
 	"log"
 	"net/http"
+	"os"
 
+	"github.com/charakoba-com/auth-api/config"
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/keymgr"
+	"github.com/charakoba-com/auth-api/mail"
+	"github.com/charakoba-com/auth-api/service"
+	"github.com/charakoba-com/auth-api/utils"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 )
 
 // Server represents an API server
 type Server struct {
 	*mux.Router
+	usrSvc     *service.UserService
+	tokenSvc   *service.TokenService
+	roleSvc    *service.RoleService
+	mfaSvc     *service.MFAService
+	lockoutSvc *service.LockoutService
+	oauthSvc   *service.OAuthService
+	resetSvc   *service.PasswordResetService
+	emailSvc   *service.EmailVerificationService
+	keySvc     *service.KeyService // nil if keys are held by the remote signer
+	tokens     *utils.TokenIssuer
 }
 
-// New returns a new Server
-func New() *Server {
-	s := Server{mux.NewRouter()}
+// New returns a new Server configured by cfg, which should have been validated
+func New(cfg *config.Config, store db.Store) (*Server, error) {
+	if cfg.Key.Generate {
+		if err := generateMissingKey(cfg.Key); err != nil {
+			return nil, err
+		}
+	}
+	signer, publicKey, err := loadKeyPair(cfg.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, `initializing key manager`)
+	}
+	keys, err := keymgr.NewKeyManager(signer, publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, `initializing key manager`)
+	}
+	if err := utils.SetPasswordAlgorithm(cfg.Password.Hash); err != nil {
+		return nil, err
+	}
+	tokens := utils.NewTokenIssuer(keys)
+	tokens.Issuer = cfg.Token.Issuer
+	tokens.Audience = cfg.Token.Audience
+	tokens.AccessTokenLifetime = cfg.Token.AccessTokenLifetime
+	for _, l := range cfg.Token.AudienceLifetimes {
+		tokens.AudienceLifetimes[l.Audience] = l.Lifetime
+	}
+	tokens.ClockSkew = cfg.Token.ClockSkew
+	tokens.MFATokenLifetime = cfg.Token.MFATokenLifetime
+	tokens.EmailVerificationTokenLifetime = cfg.Email.VerificationTokenLifetime
+	// retired keys verify tokens they signed until the tokens expire
+	keys.SetRetention(tokens.MaxLifetime() + tokens.ClockSkew)
+	var keySvc *service.KeyService
+	if cfg.Key.SignerURL == "" {
+		keySvc = &service.KeyService{
+			Store:      store,
+			Keys:       keys,
+			Passphrase: []byte(cfg.Key.Passphrase),
+		}
+		// keys rotated before take the place of the key file
+		if err := keySvc.Load(); err != nil {
+			return nil, err
+		}
+		tokens.Refresh = keySvc.Refresh
+	}
+
+	policy := newPasswordPolicy(cfg.Password)
+	history := &service.PasswordHistory{Store: store, Size: cfg.Password.History}
+	sender := newMailSender(cfg.Mail)
+	s := Server{
+		Router: mux.NewRouter(),
+		usrSvc: &service.UserService{
+			Store:                store,
+			Policy:               policy,
+			History:              history,
+			RequireVerifiedEmail: cfg.Email.RequireVerified,
+		},
+		tokenSvc: &service.TokenService{
+			Store:                store,
+			RefreshTokenLifetime: cfg.Token.RefreshTokenLifetime,
+		},
+		roleSvc: &service.RoleService{Store: store},
+		mfaSvc: &service.MFAService{
+			Store:  store,
+			Issuer: cfg.MFA.TOTPIssuer,
+		},
+		lockoutSvc: &service.LockoutService{
+			Store: store,
+			Policy: service.LockoutPolicy{
+				MaxFailures:   cfg.Lockout.Threshold,
+				IPMaxFailures: cfg.Lockout.IPThreshold,
+				Duration:      cfg.Lockout.Duration,
+				MaxDuration:   cfg.Lockout.MaxDuration,
+			},
+		},
+		oauthSvc: &service.OAuthService{Store: store},
+		resetSvc: &service.PasswordResetService{
+			Store:         store,
+			Policy:        policy,
+			History:       history,
+			Sender:        sender,
+			From:          cfg.Mail.From,
+			ResetURL:      cfg.Password.ResetURL,
+			TokenLifetime: cfg.Password.ResetTokenLifetime,
+		},
+		emailSvc: &service.EmailVerificationService{
+			Store:     store,
+			Tokens:    tokens,
+			Sender:    sender,
+			From:      cfg.Mail.From,
+			VerifyURL: cfg.Token.Issuer + "/user/verify-email",
+		},
+		keySvc: keySvc,
+		tokens: tokens,
+	}
 	s.setupRoutes()
-	return &s
+	return &s, nil
+}
+
+// loadKeyPair returns the signer and its public key from the remote signer,
+// the PEM encoded private key given as a secret, or the key files
+func loadKeyPair(cfg config.KeyConfig) (crypto.Signer, crypto.PublicKey, error) {
+	switch {
+	case cfg.SignerURL != "":
+		client := &http.Client{Timeout: cfg.SignerTimeout}
+		signer, err := keymgr.NewRemoteSigner(cfg.SignerURL, cfg.SignerToken, client)
+		if err != nil {
+			return nil, nil, err
+		}
+		return signer, signer.Public(), nil
+	case cfg.PrivateKeyPEM != "":
+		signer, err := keymgr.ParsePrivateKeyWithPassphrase([]byte(cfg.PrivateKeyPEM), []byte(cfg.Passphrase))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, `loading PrivateKey`)
+		}
+		return signer, signer.Public(), nil
+	}
+	signer, err := keymgr.LoadPrivateKey(cfg.PrivateKey, []byte(cfg.Passphrase))
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey, err := keymgr.LoadPublicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, publicKey, nil
+}
+
+// generateMissingKey generates the key pair unless the private key exists
+func generateMissingKey(cfg config.KeyConfig) error {
+	if _, err := os.Stat(cfg.PrivateKey); !os.IsNotExist(err) {
+		return nil
+	}
+	kid, err := keymgr.GenerateKeyFiles(cfg.PrivateKey, cfg.PublicKey, cfg.Algorithm, []byte(cfg.Passphrase), false)
+	if err != nil {
+		return errors.Wrap(err, `generating key pair`)
+	}
+	log.Printf("Generated %s key pair %s in %s", cfg.Algorithm, kid, cfg.PrivateKey)
+	return nil
+}
+
+// newPasswordPolicy returns the policy passwords chosen by users must satisfy
+func newPasswordPolicy(cfg config.PasswordConfig) *utils.PasswordPolicy {
+	p := utils.PasswordPolicy{
+		MinLength:       cfg.MinLength,
+		MaxLength:       cfg.MaxLength,
+		RequiredClasses: cfg.RequiredClasses,
+		RejectUserInfo:  cfg.RejectUserInfo,
+	}
+	if cfg.BreachedCorpus != "" {
+		p.Breached = &utils.BreachedPasswordCorpus{Dir: cfg.BreachedCorpus}
+	}
+	return &p
+}
+
+// newMailSender returns the sender selected by mail.sender
+func newMailSender(cfg config.MailConfig) mail.Sender {
+	switch cfg.Sender {
+	case config.MailSenderFile:
+		return &mail.FileSender{Dir: cfg.Dir}
+	case config.MailSenderMemory:
+		return &mail.MemorySender{}
+	}
+	return &mail.SMTPSender{
+		Addr:     cfg.SMTPAddr,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+	}
+}
+
+// TokenIssuer returns the issuer signing and validating tokens of the server
+func (s *Server) TokenIssuer() *utils.TokenIssuer {
+	return s.tokens
+}
+
+// SetMailSender replaces the sender of password reset and verification mails
+func (s *Server) SetMailSender(sender mail.Sender) {
+	s.resetSvc.Sender = sender
+	s.emailSvc.Sender = sender
+}
+
+// SetPasswordPolicy replaces the policy passwords chosen by users must satisfy
+func (s *Server) SetPasswordPolicy(p *utils.PasswordPolicy) {
+	s.usrSvc.Policy = p
+	s.resetSvc.Policy = p
+}
+
+// SetRequireVerifiedEmail configures whether users whose email addresses are not
+// verified are rejected at authentication
+func (s *Server) SetRequireVerifiedEmail(require bool) {
+	s.usrSvc.RequireVerifiedEmail = require
+}
+
+// SetLockoutPolicy configures account lockout and client throttling
+func (s *Server) SetLockoutPolicy(p service.LockoutPolicy) {
+	s.lockoutSvc.Policy = p
 }
 
 // Run API Server
-func Run(listen string) error {
-	s := New()
+func Run(listen string, s *Server) error {
 	log.Printf("Server listening on %s", listen)
 
 	return http.ListenAndServe(listen, s.Router)
@@ -35,22 +242,83 @@ func (s *Server) setupRoutes() {
 
 	// /user/...
 	user := r.PathPrefix(`/user`).Subrouter()
-	user.HandleFunc(`/list`, ListupUserHandler).
+	user.Handle(`/list`, s.authorize(adminOnly, s.ListupUserHandler)).
 		Methods("GET")
-	user.HandleFunc(``, CreateUserHandler).
+	// registered before /{id} not to be taken as an user ID
+	user.HandleFunc(`/verify-email`, s.VerifyEmailHandler).
+		Methods("GET", "POST")
+	user.HandleFunc(``, s.CreateUserHandler).
 		Methods("POST")
-	user.HandleFunc(`/{id}`, LookupUserHandler).
+	user.Handle(`/{id}`, s.authorize(selfOrAdmin, s.LookupUserHandler)).
 		Methods("GET")
-	user.HandleFunc(``, UpdateUserHandler).
+	user.Handle(`/{id}`, s.authorize(selfOrAdmin, s.UpdateUserHandler)).
 		Methods("PUT")
-	user.HandleFunc(`/{id}`, DeleteUserHandler).
+	user.Handle(`/{id}`, s.authorize(selfOrAdmin, s.DeleteUserHandler)).
+		Methods("DELETE")
+	user.Handle(`/{id}/verify-email`, s.authorize(selfOrAdmin, s.SendVerificationHandler)).
+		Methods("POST")
+	user.Handle(`/{id}/lock`, s.authorize(adminOnly, s.UnlockUserHandler)).
 		Methods("DELETE")
+	user.Handle(`/{id}/mfa/totp`, s.authorize(selfOnly, s.EnrollTOTPHandler)).
+		Methods("POST")
+	user.Handle(`/{id}/mfa/totp/confirm`, s.authorize(selfOnly, s.ConfirmTOTPHandler)).
+		Methods("POST")
+	user.Handle(`/{id}/mfa/totp`, s.authorize(adminOnly, s.ResetTOTPHandler)).
+		Methods("DELETE")
+	user.Handle(`/{id}/role`, s.authorize(selfOrAdmin, s.LookupUserRolesHandler)).
+		Methods("GET")
+	user.Handle(`/{id}/role/{role}`, s.authorize(adminOnly, s.AssignRoleHandler)).
+		Methods("PUT")
+	user.Handle(`/{id}/role/{role}`, s.authorize(adminOnly, s.UnassignRoleHandler)).
+		Methods("DELETE")
+
+	// /role/...
+	role := r.PathPrefix(`/role`).Subrouter()
+	role.Handle(``, s.authorize(adminOnly, s.ListupRoleHandler)).
+		Methods("GET")
+	role.Handle(``, s.authorize(adminOnly, s.CreateRoleHandler)).
+		Methods("POST")
+	role.Handle(`/{name}`, s.authorize(adminOnly, s.LookupRoleHandler)).
+		Methods("GET")
+	role.Handle(`/{name}`, s.authorize(adminOnly, s.DeleteRoleHandler)).
+		Methods("DELETE")
+	role.Handle(`/{name}/permission/{permission}`, s.authorize(adminOnly, s.GrantPermissionHandler)).
+		Methods("PUT")
+	role.Handle(`/{name}/permission/{permission}`, s.authorize(adminOnly, s.RevokePermissionHandler)).
+		Methods("DELETE")
+
+	// /client/...
+	client := r.PathPrefix(`/client`).Subrouter()
+	client.Handle(``, s.authorize(adminOnly, s.ListupClientHandler)).
+		Methods("GET")
+	client.Handle(``, s.authorize(adminOnly, s.CreateClientHandler)).
+		Methods("POST")
+	client.Handle(`/{id}`, s.authorize(adminOnly, s.LookupClientHandler)).
+		Methods("GET")
+	client.Handle(`/{id}`, s.authorize(adminOnly, s.UpdateClientHandler)).
+		Methods("PUT")
+	client.Handle(`/{id}`, s.authorize(adminOnly, s.DeleteClientHandler)).
+		Methods("DELETE")
+
+	// /oauth/...
+	r.HandleFunc(`/oauth/authorize`, s.AuthorizeHandler)
+	r.HandleFunc(`/oauth/token`, s.OAuthTokenHandler)
+	r.HandleFunc(`/introspect`, s.IntrospectHandler)
+	r.Handle(`/userinfo`, s.authorizeClient(authenticated, s.UserInfoHandler))
+	r.HandleFunc(`/.well-known/openid-configuration`, s.OpenIDConfigurationHandler)
 
-	r.HandleFunc(`/auth`, AuthHandler)
-	r.HandleFunc(`/algorithm`, GetAlgorithmHandler)
-	r.HandleFunc(`/alg`, GetAlgorithmHandler) // alias to /algorithm
-	r.HandleFunc(`/verify`, VerifyHandler)
-	r.HandleFunc(`/key`, GetKeyHandler)
+	r.HandleFunc(`/auth`, s.AuthHandler)
+	r.HandleFunc(`/auth/mfa`, s.AuthMFAHandler)
+	r.HandleFunc(`/token/refresh`, s.RefreshTokenHandler)
+	r.HandleFunc(`/password/forgot`, s.ForgotPasswordHandler)
+	r.HandleFunc(`/password/reset`, s.ResetPasswordHandler)
+	r.HandleFunc(`/algorithm`, s.GetAlgorithmHandler)
+	r.HandleFunc(`/alg`, s.GetAlgorithmHandler) // alias to /algorithm
+	r.HandleFunc(`/verify`, s.VerifyHandler)
+	r.Handle(`/logout`, s.authorize(authenticated, s.LogoutHandler))
+	r.HandleFunc(`/key`, s.GetKeyHandler)
+	r.Handle(`/key/rotate`, s.authorize(adminOnly, s.RotateKeyHandler))
+	r.HandleFunc(`/.well-known/jwks.json`, s.JWKSetHandler)
 
 	r.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
 }
@@ -3,26 +3,116 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	authapi "github.com/charakoba-com/auth-api"
+	"github.com/charakoba-com/auth-api/config"
+	"github.com/charakoba-com/auth-api/db"
 	flags "github.com/jessevdk/go-flags"
 )
 
+// options override the config file and environment variables when given
 type options struct {
-	Listen string `short:"l" long:"listen" default:":8080" description:"Listen address"`
+	Config string `short:"c" long:"config" env:"AUTHAPI_CONFIG" description:"Config file (YAML, TOML or JSON)"`
+
+	Listen   string `short:"l" long:"listen" description:"Listen address (default :8080)"`
+	DBDriver string `long:"db-driver" choice:"mysql" choice:"sqlite3" choice:"memory" description:"Database driver (default mysql)"`
+	DBDSN    string `long:"db-dsn" description:"Database data source name (defaults to root@127.0.0.1:3306/apidb for mysql)"`
+	Hash     string `long:"password-hash" choice:"bcrypt" choice:"scrypt" choice:"argon2id" description:"Password hashing algorithm (default argon2id)"`
+
+	PrivateKey  string `long:"private-key" description:"PEM encoded RSA, ECDSA P-256 or Ed25519 private key file"`
+	PublicKey   string `long:"public-key" description:"PEM encoded public key file"`
+	GenerateKey bool   `long:"generate-key" description:"Generate the key pair at start if missing"`
+	Issuer      string `long:"issuer" description:"Public URL of the server, the iss claim of tokens (default http://localhost:8080)"`
+
+	AccessTokenLifetime  time.Duration `long:"access-token-lifetime" description:"Lifetime of access tokens (default 15m)"`
+	RefreshTokenLifetime time.Duration `long:"refresh-token-lifetime" description:"Lifetime of refresh tokens (default 720h)"`
+
+	AutoMigrate bool `long:"auto-migrate" description:"Apply pending database migrations at start"`
+
+	LockoutThreshold   int           `long:"lockout-threshold" description:"Failed authentications before an account is locked (default 5)"`
+	IPLockoutThreshold int           `long:"ip-lockout-threshold" description:"Failed authentications before a client address is throttled (default 50)"`
+	LockoutDuration    time.Duration `long:"lockout-duration" description:"First lockout duration, doubled at each further failure (default 1m)"`
+	MaxLockoutDuration time.Duration `long:"max-lockout-duration" description:"Upper limit of lockout duration (default 24h)"`
+
+	Migrate migrateCommand `command:"migrate" description:"Manage the database schema"`
+	Keygen  keygenCommand  `command:"keygen" description:"Generate a key pair signing tokens into the key files"`
 }
 
+// overrides returns config keys of the given options
+func (opts *options) overrides() map[string]interface{} {
+	m := map[string]interface{}{}
+	set := func(key string, value, zero interface{}) {
+		if value != zero {
+			m[key] = value
+		}
+	}
+	set("server.listen", opts.Listen, "")
+	set("database.driver", opts.DBDriver, "")
+	set("database.dsn", opts.DBDSN, "")
+	set("database.auto_migrate", opts.AutoMigrate, false)
+	set("password.hash", opts.Hash, "")
+	set("key.private_key", opts.PrivateKey, "")
+	set("key.public_key", opts.PublicKey, "")
+	set("key.generate", opts.GenerateKey, false)
+	set("token.issuer", opts.Issuer, "")
+	set("token.access_token_lifetime", opts.AccessTokenLifetime, time.Duration(0))
+	set("token.refresh_token_lifetime", opts.RefreshTokenLifetime, time.Duration(0))
+	set("lockout.threshold", opts.LockoutThreshold, 0)
+	set("lockout.ip_threshold", opts.IPLockoutThreshold, 0)
+	set("lockout.duration", opts.LockoutDuration, time.Duration(0))
+	set("lockout.max_duration", opts.MaxLockoutDuration, time.Duration(0))
+	return m
+}
+
+// opts are shared by the server and subcommands
+var opts options
+
 func main() {
 	os.Exit(_main())
 }
 
 func _main() int {
-	var opts options
-	if _, err := flags.Parse(&opts); err != nil {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	if _, err := parser.Parse(); err != nil {
+		log.Printf("%s", err)
+		return 1
+	}
+	if parser.Active != nil {
+		// a subcommand has been executed
+		return 0
+	}
+
+	cfg, err := config.Load(opts.Config, opts.overrides())
+	if err != nil {
+		log.Printf("%s", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Printf("%s", err)
+		return 1
+	}
+	store, err := db.Open(cfg.Database.Driver, cfg.Database.DSN)
+	if err != nil {
+		log.Printf("%s", err)
+		return 1
+	}
+	defer store.Close()
+	if m, ok := store.(db.Migrator); ok && cfg.Database.AutoMigrate {
+		applied, err := m.MigrateUp(0)
+		if err != nil {
+			log.Printf("%s", err)
+			return 1
+		}
+		log.Printf("%d migrations applied", len(applied))
+	}
+	s, err := authapi.New(cfg, store)
+	if err != nil {
 		log.Printf("%s", err)
 		return 1
 	}
-	if err := authapi.Run(opts.Listen); err != nil {
+	if err := authapi.Run(cfg.Server.Listen, s); err != nil {
 		log.Printf("%s", err)
 		return 1
 	}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charakoba-com/auth-api/config"
+	"github.com/charakoba-com/auth-api/keymgr"
+)
+
+type keygenCommand struct {
+	Algorithm string `long:"algorithm" choice:"RS256" choice:"ES256" choice:"EdDSA" description:"Signing algorithm of the key pair (defaults to key.algorithm)"`
+	Force     bool   `long:"force" description:"Overwrite existing key files"`
+}
+
+// Execute generates a key pair into the configured key files
+func (c *keygenCommand) Execute(args []string) error {
+	cfg, err := config.Load(opts.Config, opts.overrides())
+	if err != nil {
+		return err
+	}
+	algorithm := c.Algorithm
+	if algorithm == "" {
+		algorithm = cfg.Key.Algorithm
+	}
+	// the private key is encrypted with key.passphrase if given
+	kid, err := keymgr.GenerateKeyFiles(cfg.Key.PrivateKey, cfg.Key.PublicKey, algorithm, []byte(cfg.Key.Passphrase), c.Force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("generated %s key pair %s\n", algorithm, kid)
+	fmt.Printf("private key: %s\n", cfg.Key.PrivateKey)
+	fmt.Printf("public key: %s\n", cfg.Key.PublicKey)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charakoba-com/auth-api/config"
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/pkg/errors"
+)
+
+type migrateCommand struct {
+	Up     migrateUpCommand     `command:"up" description:"Apply pending migrations"`
+	Down   migrateDownCommand   `command:"down" description:"Revert applied migrations"`
+	Status migrateStatusCommand `command:"status" description:"Show migrations and whether they have been applied"`
+}
+
+type migrateUpCommand struct {
+	To int `long:"to" description:"Stop at this version instead of the latest one"`
+}
+
+type migrateDownCommand struct {
+	Steps int `long:"steps" default:"1" description:"Number of migrations to revert"`
+}
+
+type migrateStatusCommand struct{}
+
+// openMigrator opens the configured database for migrations.
+// Other settings such as key files are not required.
+func openMigrator() (db.Migrator, func() error, error) {
+	cfg, err := config.Load(opts.Config, opts.overrides())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		return nil, nil, err
+	}
+	store, err := db.Open(cfg.Database.Driver, cfg.Database.DSN)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, ok := store.(db.Migrator)
+	if !ok {
+		store.Close()
+		return nil, nil, errors.Errorf(`database driver %s has no schema to migrate`, cfg.Database.Driver)
+	}
+	return m, store.Close, nil
+}
+
+// Execute applies pending migrations
+func (c *migrateUpCommand) Execute(args []string) error {
+	m, closeFn, err := openMigrator()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	applied, err := m.MigrateUp(c.To)
+	for _, migration := range applied {
+		fmt.Printf("applied %d %s\n", migration.Version, migration.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("database is up to date")
+	}
+	return nil
+}
+
+// Execute reverts applied migrations
+func (c *migrateDownCommand) Execute(args []string) error {
+	if c.Steps <= 0 {
+		return errors.Errorf(`steps must be positive: %d`, c.Steps)
+	}
+	m, closeFn, err := openMigrator()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	reverted, err := m.MigrateDown(c.Steps)
+	for _, migration := range reverted {
+		fmt.Printf("reverted %d %s\n", migration.Version, migration.Description)
+	}
+	return err
+}
+
+// Execute shows migrations
+func (c *migrateStatusCommand) Execute(args []string) error {
+	m, closeFn, err := openMigrator()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	status, err := m.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED ON\tDESCRIPTION")
+	for _, st := range status {
+		appliedOn := "pending"
+		if st.Applied {
+			appliedOn = st.AppliedOn.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", st.Version, appliedOn, st.Description)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,425 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/keymgr"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is the prefix of environment variables overriding the configuration.
+// Nested keys are joined with `_`, e.g. `AUTHAPI_DATABASE_DSN` for `database.dsn`.
+const EnvPrefix = `AUTHAPI`
+
+// Config is the configuration of the API server
+type Config struct {
+	Server   ServerConfig   `mapstructure:"server"`
+	Database DatabaseConfig `mapstructure:"database"`
+	Key      KeyConfig      `mapstructure:"key"`
+	Token    TokenConfig    `mapstructure:"token"`
+	Password PasswordConfig `mapstructure:"password"`
+	Lockout  LockoutConfig  `mapstructure:"lockout"`
+	MFA      MFAConfig      `mapstructure:"mfa"`
+	Mail     MailConfig     `mapstructure:"mail"`
+	Email    EmailConfig    `mapstructure:"email"`
+}
+
+// ServerConfig configures the HTTP listener
+type ServerConfig struct {
+	Listen string `mapstructure:"listen"`
+}
+
+// DatabaseConfig selects the user store
+type DatabaseConfig struct {
+	Driver      string `mapstructure:"driver"`
+	DSN         string `mapstructure:"dsn"`          // mysql connects to root@127.0.0.1:3306/apidb if empty
+	AutoMigrate bool   `mapstructure:"auto_migrate"` // apply pending migrations at server start
+}
+
+// KeyConfig locates the key pair signing tokens: PEM encoded files, a PEM encoded
+// private key given as a secret, or a remote signer holding the private key
+type KeyConfig struct {
+	PrivateKey    string        `mapstructure:"private_key"`
+	PublicKey     string        `mapstructure:"public_key"`
+	Passphrase    string        `mapstructure:"passphrase"`      // decrypts an encrypted PKCS#8 private key
+	PrivateKeyPEM string        `mapstructure:"private_key_pem"` // used instead of the key files if given
+	SignerURL     string        `mapstructure:"signer_url"`      // used instead of the key files if given
+	SignerToken   string        `mapstructure:"signer_token"`
+	SignerTimeout time.Duration `mapstructure:"signer_timeout"` // of each request to the remote signer
+	Generate      bool          `mapstructure:"generate"`       // generate the key pair at start if missing
+	Algorithm     string        `mapstructure:"algorithm"`      // of generated keys
+}
+
+// TokenConfig configures issued tokens
+type TokenConfig struct {
+	Issuer               string             `mapstructure:"issuer"` // public URL of the server without trailing slash, the iss claim
+	Audience             string             `mapstructure:"audience"`
+	AccessTokenLifetime  time.Duration      `mapstructure:"access_token_lifetime"`
+	RefreshTokenLifetime time.Duration      `mapstructure:"refresh_token_lifetime"`
+	MFATokenLifetime     time.Duration      `mapstructure:"mfa_token_lifetime"`
+	ClockSkew            time.Duration      `mapstructure:"clock_skew"`
+	AudienceLifetimes    []AudienceLifetime `mapstructure:"audience_lifetimes"`
+}
+
+// AudienceLifetime overrides the access token lifetime for an audience or an OAuth client ID.
+// It is a list rather than a map as keys of maps are lowercased.
+type AudienceLifetime struct {
+	Audience string        `mapstructure:"audience"`
+	Lifetime time.Duration `mapstructure:"lifetime"`
+}
+
+// PasswordConfig configures password hashing, policy and reset
+type PasswordConfig struct {
+	Hash               string        `mapstructure:"hash"`
+	MinLength          int           `mapstructure:"min_length"`
+	MaxLength          int           `mapstructure:"max_length"`
+	RequiredClasses    []string      `mapstructure:"required_classes"` // lower, upper, digit or symbol
+	RejectUserInfo     bool          `mapstructure:"reject_user_info"` // reject passwords equal to the user ID or the username
+	BreachedCorpus     string        `mapstructure:"breached_corpus"`  // directory of SHA-1 prefix files, not checked if empty
+	History            int           `mapstructure:"history"`          // former passwords which cannot be reused, disabled if zero
+	ResetTokenLifetime time.Duration `mapstructure:"reset_token_lifetime"`
+	ResetURL           string        `mapstructure:"reset_url"` // page linked from reset mails, optional
+}
+
+// LockoutConfig configures account lockout and client throttling
+type LockoutConfig struct {
+	Threshold   int           `mapstructure:"threshold"`
+	IPThreshold int           `mapstructure:"ip_threshold"`
+	Duration    time.Duration `mapstructure:"duration"`
+	MaxDuration time.Duration `mapstructure:"max_duration"`
+}
+
+// MFAConfig configures two-factor authentication
+type MFAConfig struct {
+	TOTPIssuer string `mapstructure:"totp_issuer"` // shown in authenticator apps
+}
+
+// mail senders selected by mail.sender
+const (
+	MailSenderSMTP   = `smtp`
+	MailSenderFile   = `file`
+	MailSenderMemory = `memory`
+)
+
+// MailConfig configures delivery of password reset mails
+type MailConfig struct {
+	Sender       string `mapstructure:"sender"`
+	From         string `mapstructure:"from"`
+	SMTPAddr     string `mapstructure:"smtp_addr"`
+	SMTPUsername string `mapstructure:"smtp_username"` // no authentication if empty
+	SMTPPassword string `mapstructure:"smtp_password"`
+	Dir          string `mapstructure:"dir"` // written by the file sender
+}
+
+// EmailConfig configures verification of email addresses of users
+type EmailConfig struct {
+	RequireVerified           bool          `mapstructure:"require_verified"` // reject authentication of unverified users
+	VerificationTokenLifetime time.Duration `mapstructure:"verification_token_lifetime"`
+}
+
+// defaults are used for keys given by none of the file, environment and flags
+var defaults = map[string]interface{}{
+	"server.listen":                     ":8080",
+	"database.driver":                   db.DriverMySQL,
+	"database.dsn":                      "",
+	"database.auto_migrate":             false,
+	"key.private_key":                   "/etc/authapi/pki/rsa256.key",
+	"key.public_key":                    "/etc/authapi/pki/rsa256.key.pub",
+	"key.passphrase":                    "",
+	"key.private_key_pem":               "",
+	"key.signer_url":                    "",
+	"key.signer_token":                  "",
+	"key.signer_timeout":                keymgr.DefaultSignerTimeout,
+	"key.generate":                      false,
+	"key.algorithm":                     keymgr.AlgorithmRS256,
+	"token.issuer":                      "http://localhost:8080",
+	"token.audience":                    "authapi",
+	"token.access_token_lifetime":       15 * time.Minute,
+	"token.refresh_token_lifetime":      30 * 24 * time.Hour,
+	"token.mfa_token_lifetime":          5 * time.Minute,
+	"token.clock_skew":                  30 * time.Second,
+	"token.audience_lifetimes":          []interface{}{},
+	"password.hash":                     utils.PasswordArgon2id,
+	"password.min_length":               8,
+	"password.max_length":               128,
+	"password.required_classes":         []string{},
+	"password.reject_user_info":         true,
+	"password.history":                  5,
+	"password.breached_corpus":          "",
+	"password.reset_token_lifetime":     time.Hour,
+	"password.reset_url":                "",
+	"lockout.threshold":                 5,
+	"lockout.ip_threshold":              50,
+	"lockout.duration":                  time.Minute,
+	"lockout.max_duration":              24 * time.Hour,
+	"mfa.totp_issuer":                   "authapi",
+	"mail.sender":                       MailSenderSMTP,
+	"mail.from":                         "authapi@localhost",
+	"mail.smtp_addr":                    "localhost:25",
+	"mail.smtp_username":                "",
+	"mail.smtp_password":                "",
+	"mail.dir":                          "/var/spool/authapi/mail",
+	"email.require_verified":            false,
+	"email.verification_token_lifetime": 24 * time.Hour,
+}
+
+// Load reads the configuration. Values are taken from, in order of precedence,
+// overrides (typically command line flags), `AUTHAPI_*` environment variables,
+// the file and defaults. The format of the file (YAML, TOML or JSON) is told
+// by its extension, and an empty path means no file.
+func Load(path string, overrides map[string]interface{}) (*Config, error) {
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return nil, errors.Wrap(err, `reading config file`)
+		}
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, errors.Wrapf(err, `reading config file %s`, path)
+		}
+	}
+	for key, value := range overrides {
+		if _, ok := defaults[key]; !ok {
+			return nil, errors.Errorf(`unknown config key: %s`, key)
+		}
+		v.Set(key, value)
+	}
+
+	var c Config
+	if err := v.Unmarshal(&c); err != nil {
+		return nil, errors.Wrap(err, `decoding config`)
+	}
+	return &c, nil
+}
+
+// problems collects invalid values to report them at once
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	msg := bytes.Buffer{}
+	msg.WriteString(`invalid configuration:`)
+	for _, s := range p {
+		msg.WriteString("\n  ")
+		msg.WriteString(s)
+	}
+	return errors.New(msg.String())
+}
+
+// Validate reports every invalid value of the database configuration at once
+func (c *DatabaseConfig) Validate() error {
+	var p problems
+	c.validate(&p)
+	return p.err()
+}
+
+func (c *DatabaseConfig) validate(p *problems) {
+	switch c.Driver {
+	case db.DriverMySQL, db.DriverMemory:
+	case db.DriverSQLite:
+		if c.DSN == "" {
+			p.add(`database.dsn is required for sqlite3`)
+		}
+	default:
+		p.add(`database.driver must be one of mysql, sqlite3 or memory: %q`, c.Driver)
+	}
+}
+
+// validate reports invalid values locating the key pair
+func (c *KeyConfig) validate(p *problems) {
+	add := p.add
+	switch c.Algorithm {
+	case keymgr.AlgorithmRS256, keymgr.AlgorithmES256, keymgr.AlgorithmEdDSA:
+	default:
+		add(`key.algorithm must be one of RS256, ES256 or EdDSA: %q`, c.Algorithm)
+	}
+	switch {
+	case c.SignerURL != "":
+		if u, err := url.Parse(c.SignerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(`key.signer_url must be an absolute http or https URL: %q`, c.SignerURL)
+		}
+		if c.Generate {
+			add(`key.generate cannot be used with key.signer_url`)
+		}
+		if c.SignerTimeout <= 0 {
+			add(`key.signer_timeout must be positive: %s`, c.SignerTimeout)
+		}
+		return
+	case c.PrivateKeyPEM != "":
+		if c.Generate {
+			add(`key.generate cannot be used with key.private_key_pem`)
+		}
+		return
+	}
+
+	// missing keys are generated at start with key.generate
+	missing := 0
+	for _, key := range []struct {
+		name, path string
+	}{
+		{"key.private_key", c.PrivateKey},
+		{"key.public_key", c.PublicKey},
+	} {
+		if key.path == "" {
+			add(`%s is required`, key.name)
+			continue
+		}
+		f, err := os.Open(key.path)
+		if os.IsNotExist(err) && c.Generate {
+			missing++
+			continue
+		}
+		if err != nil {
+			add(`%s is not readable: %s`, key.name, err)
+			continue
+		}
+		f.Close()
+	}
+	if missing == 1 {
+		add(`key.private_key and key.public_key must both exist or both be missing to be generated`)
+	}
+}
+
+// validate reports invalid values configuring mail delivery
+func (c *MailConfig) validate(p *problems) {
+	if c.From == "" || strings.ContainsAny(c.From, "\r\n") {
+		p.add(`mail.from must be a single line address: %q`, c.From)
+	}
+	switch c.Sender {
+	case MailSenderSMTP:
+		if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+			p.add(`mail.smtp_addr must be host:port: %q`, c.SMTPAddr)
+		}
+	case MailSenderFile:
+		if c.Dir == "" {
+			p.add(`mail.dir is required for the file sender`)
+		}
+	case MailSenderMemory:
+	default:
+		p.add(`mail.sender must be one of smtp, file or memory: %q`, c.Sender)
+	}
+}
+
+// Validate reports every invalid value at once
+func (c *Config) Validate() error {
+	var p problems
+	add := p.add
+
+	if c.Server.Listen == "" {
+		add(`server.listen is required`)
+	}
+
+	c.Database.validate(&p)
+
+	c.Key.validate(&p)
+
+	if u, err := url.Parse(c.Token.Issuer); err != nil || !u.IsAbs() || u.Host == "" || u.RawQuery != "" || u.Fragment != "" || strings.HasSuffix(u.Path, "/") {
+		add(`token.issuer must be an absolute URL without query, fragment and trailing slash: %q`, c.Token.Issuer)
+	}
+	if c.Token.Audience == "" {
+		add(`token.audience is required`)
+	}
+	if c.Token.ClockSkew < 0 {
+		add(`token.clock_skew must not be negative: %s`, c.Token.ClockSkew)
+	}
+	for i, l := range c.Token.AudienceLifetimes {
+		if l.Audience == "" {
+			add(`token.audience_lifetimes[%d].audience is required`, i)
+		}
+		if l.Lifetime <= 0 {
+			add(`token.audience_lifetimes[%d].lifetime must be positive: %s`, i, l.Lifetime)
+		}
+	}
+	for _, lifetime := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"token.access_token_lifetime", c.Token.AccessTokenLifetime},
+		{"token.refresh_token_lifetime", c.Token.RefreshTokenLifetime},
+		{"token.mfa_token_lifetime", c.Token.MFATokenLifetime},
+		{"lockout.duration", c.Lockout.Duration},
+		{"lockout.max_duration", c.Lockout.MaxDuration},
+		{"password.reset_token_lifetime", c.Password.ResetTokenLifetime},
+		{"email.verification_token_lifetime", c.Email.VerificationTokenLifetime},
+	} {
+		if lifetime.d <= 0 {
+			add(`%s must be positive: %s`, lifetime.name, lifetime.d)
+		}
+	}
+	if c.Token.RefreshTokenLifetime < c.Token.AccessTokenLifetime {
+		add(`token.refresh_token_lifetime must not be shorter than token.access_token_lifetime`)
+	}
+
+	switch c.Password.Hash {
+	case utils.PasswordArgon2id, utils.PasswordScrypt, utils.PasswordBcrypt:
+	default:
+		add(`password.hash must be one of argon2id, scrypt or bcrypt: %q`, c.Password.Hash)
+	}
+	if c.Password.MinLength < 1 {
+		add(`password.min_length must be positive: %d`, c.Password.MinLength)
+	}
+	if c.Password.MaxLength < c.Password.MinLength {
+		add(`password.max_length must not be shorter than password.min_length: %d`, c.Password.MaxLength)
+	}
+	if c.Password.Hash == utils.PasswordBcrypt && c.Password.MaxLength > utils.BcryptMaxLength {
+		add(`password.max_length must not exceed %d with bcrypt: %d`, utils.BcryptMaxLength, c.Password.MaxLength)
+	}
+	for _, class := range c.Password.RequiredClasses {
+		if !utils.ValidCharacterClass(class) {
+			add(`password.required_classes must be lower, upper, digit or symbol: %q`, class)
+		}
+	}
+	if c.Password.History < 0 {
+		add(`password.history must not be negative: %d`, c.Password.History)
+	}
+	if c.Password.BreachedCorpus != "" {
+		if fi, err := os.Stat(c.Password.BreachedCorpus); err != nil || !fi.IsDir() {
+			add(`password.breached_corpus must be a directory: %q`, c.Password.BreachedCorpus)
+		}
+	}
+	if c.Password.ResetURL != "" {
+		if u, err := url.Parse(c.Password.ResetURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(`password.reset_url must be an absolute http or https URL: %q`, c.Password.ResetURL)
+		}
+	}
+
+	if c.Lockout.Threshold <= 0 {
+		add(`lockout.threshold must be positive: %d`, c.Lockout.Threshold)
+	}
+	if c.Lockout.IPThreshold <= 0 {
+		add(`lockout.ip_threshold must be positive: %d`, c.Lockout.IPThreshold)
+	}
+	if c.Lockout.MaxDuration < c.Lockout.Duration {
+		add(`lockout.max_duration must not be shorter than lockout.duration`)
+	}
+
+	if c.MFA.TOTPIssuer == "" {
+		add(`mfa.totp_issuer is required`)
+	}
+
+	c.Mail.validate(&p)
+
+	return p.err()
+}
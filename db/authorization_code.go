@@ -0,0 +1,185 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scan raw database row to authorization code
+func (c *AuthorizationCode) Scan(scanner interface {
+	Scan(...interface{}) error
+}) error {
+	return scanner.Scan(&c.Hash, &c.ClientID, &c.UserID, &c.RedirectURI, &c.Scope, &c.CodeChallenge, &c.Nonce, &c.FamilyID, &c.CreatedOn, &c.ExpiresOn, &c.Used)
+}
+
+// Create AuthorizationCode
+func (c *AuthorizationCode) Create(tx *sql.Tx) error {
+	log.Printf("db.AuthorizationCode.Create %s", c.ClientID)
+
+	if c.CreatedOn.IsZero() {
+		c.CreatedOn = time.Now()
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(authCodeTable)
+	stmt.WriteString(` (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, family_id, created_on, expires_on, used) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s, %s, %s, %s", stmt.String(), c.ClientID, c.UserID, c.RedirectURI, c.Scope, c.CreatedOn, c.ExpiresOn)
+
+	_, err := tx.Exec(stmt.String(), c.Hash, c.ClientID, c.UserID, c.RedirectURI, c.Scope, c.CodeChallenge, c.Nonce, c.FamilyID, c.CreatedOn, c.ExpiresOn, c.Used)
+	return err
+}
+
+// Load authorization code by hash
+func (c *AuthorizationCode) Load(tx *sql.Tx, hash string) error {
+	log.Printf("db.AuthorizationCode.Load")
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(authCodeSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(authCodeTable)
+	stmt.WriteString(` WHERE code_hash = ?`)
+
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	row := tx.QueryRow(stmt.String(), hash)
+
+	if err := c.Scan(row); err != nil {
+		return errors.Wrap(err, "scanning row")
+	}
+	return nil
+}
+
+// Use marks the code as used.
+// It fails with ErrAuthorizationCodeUsed unless the code is unused.
+func (c *AuthorizationCode) Use(tx *sql.Tx) error {
+	log.Printf("db.AuthorizationCode.Use %s", c.ClientID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(authCodeTable)
+	stmt.WriteString(` SET used = ? WHERE code_hash = ? AND used = ?`)
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	res, err := tx.Exec(stmt.String(), true, c.Hash, false)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, `counting affected rows`)
+	}
+	if n != 1 {
+		return ErrAuthorizationCodeUsed
+	}
+	c.Used = true
+	return nil
+}
+
+// purgeAuthorizationCodes deletes codes expired before now
+func purgeAuthorizationCodes(tx *sql.Tx, now time.Time) error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(authCodeTable)
+	stmt.WriteString(` WHERE expires_on < ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), now)
+
+	_, err := tx.Exec(stmt.String(), now)
+	return err
+}
+
+// CreateAuthorizationCode stores the code, and purges expired ones
+func (s *SQLStore) CreateAuthorizationCode(c *AuthorizationCode) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := purgeAuthorizationCodes(tx, time.Now()); err != nil {
+			return err
+		}
+		return c.Create(tx)
+	})
+}
+
+// LookupAuthorizationCode returns the code without consuming it
+func (s *SQLStore) LookupAuthorizationCode(hash string) (*AuthorizationCode, error) {
+	var c AuthorizationCode
+	err := s.withTx(func(tx *sql.Tx) error {
+		return c.Load(tx, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ConsumeAuthorizationCode marks the code as used and returns it
+func (s *SQLStore) ConsumeAuthorizationCode(hash string) (*AuthorizationCode, error) {
+	var c AuthorizationCode
+	err := s.withTx(func(tx *sql.Tx) error {
+		if err := c.Load(tx, hash); err != nil {
+			return err
+		}
+		if c.Used {
+			return ErrAuthorizationCodeUsed
+		}
+		return c.Use(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CreateAuthorizationCode stores the code, and purges expired ones
+func (s *MemoryStore) CreateAuthorizationCode(c *AuthorizationCode) error {
+	log.Printf("db.MemoryStore.CreateAuthorizationCode %s", c.ClientID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, code := range s.authCodes {
+		if code.ExpiresOn.Before(now) {
+			delete(s.authCodes, hash)
+		}
+	}
+	if c.CreatedOn.IsZero() {
+		c.CreatedOn = now
+	}
+	s.authCodes[c.Hash] = *c
+	return nil
+}
+
+// LookupAuthorizationCode returns the code without consuming it
+func (s *MemoryStore) LookupAuthorizationCode(hash string) (*AuthorizationCode, error) {
+	log.Printf("db.MemoryStore.LookupAuthorizationCode")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.authCodes[hash]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up authorization code`)
+	}
+	return &c, nil
+}
+
+// ConsumeAuthorizationCode marks the code as used and returns it
+func (s *MemoryStore) ConsumeAuthorizationCode(hash string) (*AuthorizationCode, error) {
+	log.Printf("db.MemoryStore.ConsumeAuthorizationCode")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.authCodes[hash]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up authorization code`)
+	}
+	if c.Used {
+		return nil, ErrAuthorizationCodeUsed
+	}
+	c.Used = true
+	s.authCodes[hash] = c
+	return &c, nil
+}
@@ -0,0 +1,355 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scan raw database row to client. Redirect URIs are loaded separately.
+func (c *Client) Scan(scanner interface {
+	Scan(...interface{}) error
+}) error {
+	return scanner.Scan(&c.ID, &c.Name, &c.SecretHash, &c.Scope, &c.GrantTypes, &c.CreatedOn)
+}
+
+// Create Client with its redirect URIs
+func (c *Client) Create(tx *sql.Tx) error {
+	log.Printf("db.Client.Create %s", c.ID)
+
+	if c.CreatedOn.IsZero() {
+		c.CreatedOn = time.Now()
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(clientTable)
+	stmt.WriteString(` (id, name, secret_hash, scope, grant_types, created_on) VALUES (?, ?, ?, ?, ?, ?)`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s, %s, %s", stmt.String(), c.ID, c.Name, c.Scope, c.GrantTypes, c.CreatedOn)
+
+	if _, err := tx.Exec(stmt.String(), c.ID, c.Name, c.SecretHash, c.Scope, c.GrantTypes, c.CreatedOn); err != nil {
+		return err
+	}
+	return c.insertRedirectURIs(tx)
+}
+
+// insertRedirectURIs stores redirect URIs of the client
+func (c *Client) insertRedirectURIs(tx *sql.Tx) error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(clientRedirectURITable)
+	stmt.WriteString(` (client_id, redirect_uri) VALUES (?, ?)`)
+	for _, uri := range c.RedirectURIs {
+		log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), c.ID, uri)
+		if _, err := tx.Exec(stmt.String(), c.ID, uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update Client and replace its redirect URIs. The secret is not updated.
+func (c *Client) Update(tx *sql.Tx) error {
+	log.Printf("db.Client.Update %s", c.ID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(clientTable)
+	stmt.WriteString(` SET name = ?, scope = ?, grant_types = ? WHERE id = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s, %s", stmt.String(), c.Name, c.Scope, c.GrantTypes, c.ID)
+
+	if _, err := tx.Exec(stmt.String(), c.Name, c.Scope, c.GrantTypes, c.ID); err != nil {
+		return err
+	}
+
+	stmt.Reset()
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(clientRedirectURITable)
+	stmt.WriteString(` WHERE client_id = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), c.ID)
+
+	if _, err := tx.Exec(stmt.String(), c.ID); err != nil {
+		return err
+	}
+	return c.insertRedirectURIs(tx)
+}
+
+// Delete Client with its redirect URIs and authorization codes,
+// and revoke refresh tokens issued to the client
+func (c *Client) Delete(tx *sql.Tx) error {
+	if c.ID == "" {
+		return errors.New(`client ID is not valid`)
+	}
+	log.Printf("db.Client.Delete %s", c.ID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(refreshTokenTable)
+	stmt.WriteString(` SET revoked = ? WHERE client_id = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), c.ID)
+
+	if _, err := tx.Exec(stmt.String(), true, c.ID); err != nil {
+		return err
+	}
+
+	for _, table := range []string{clientRedirectURITable, authCodeTable} {
+		stmt := bytes.Buffer{}
+		stmt.WriteString(`DELETE FROM `)
+		stmt.WriteString(table)
+		stmt.WriteString(` WHERE client_id = ?`)
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), c.ID)
+
+		if _, err := tx.Exec(stmt.String(), c.ID); err != nil {
+			return err
+		}
+	}
+
+	stmt.Reset()
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(clientTable)
+	stmt.WriteString(` WHERE id = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), c.ID)
+
+	_, err := tx.Exec(stmt.String(), c.ID)
+	return err
+}
+
+// Load client and its redirect URIs by client ID
+func (c *Client) Load(tx *sql.Tx, id string) error {
+	log.Printf("db.Client.Load %s", id)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(clientSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(clientTable)
+	stmt.WriteString(` WHERE id = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), id)
+
+	row := tx.QueryRow(stmt.String(), id)
+
+	if err := c.Scan(row); err != nil {
+		return errors.Wrap(err, "scanning row")
+	}
+	return c.loadRedirectURIs(tx)
+}
+
+// loadRedirectURIs loads redirect URIs registered to the client
+func (c *Client) loadRedirectURIs(tx *sql.Tx) error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT redirect_uri FROM `)
+	stmt.WriteString(clientRedirectURITable)
+	stmt.WriteString(` WHERE client_id = ? ORDER BY redirect_uri`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), c.ID)
+
+	rows, err := tx.Query(stmt.String(), c.ID)
+	if err != nil {
+		return errors.Wrap(err, `querying stmt`)
+	}
+	defer rows.Close()
+
+	uris := []string{}
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return errors.Wrap(err, `scanning row`)
+		}
+		uris = append(uris, uri)
+	}
+	c.RedirectURIs = uris
+	return rows.Err()
+}
+
+// Listup Clients
+func (l *ClientList) Listup(tx *sql.Tx) error {
+	log.Printf("db.Client.Listup")
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(clientSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(clientTable)
+	stmt.WriteString(` ORDER BY id`)
+
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	rows, err := tx.Query(stmt.String())
+	if err != nil {
+		return errors.Wrap(err, `querying stmt`)
+	}
+
+	res := ClientList{}
+	for rows.Next() {
+		c := Client{}
+		if err := c.Scan(rows); err != nil {
+			rows.Close()
+			return errors.Wrap(err, `scanning row`)
+		}
+		res = append(res, c)
+	}
+	// rows must be closed before querying redirect URIs in the same transaction
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, `scanning rows`)
+	}
+	for i := range res {
+		if err := res[i].loadRedirectURIs(tx); err != nil {
+			return err
+		}
+	}
+	*l = res
+	return nil
+}
+
+// CreateClient registers an OAuth client
+func (s *SQLStore) CreateClient(c *Client) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		return c.Create(tx)
+	})
+}
+
+// LookupClient loads an OAuth client
+func (s *SQLStore) LookupClient(id string) (*Client, error) {
+	var c Client
+	err := s.withTx(func(tx *sql.Tx) error {
+		return c.Load(tx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// UpdateClient updates an OAuth client
+func (s *SQLStore) UpdateClient(c *Client) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var stored Client
+		if err := stored.Load(tx, c.ID); err != nil {
+			return err
+		}
+		return c.Update(tx)
+	})
+}
+
+// DeleteClient deletes an OAuth client
+func (s *SQLStore) DeleteClient(id string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		c := Client{ID: id}
+		return c.Delete(tx)
+	})
+}
+
+// ListupClients returns all OAuth clients
+func (s *SQLStore) ListupClients() (ClientList, error) {
+	var l ClientList
+	err := s.withTx(func(tx *sql.Tx) error {
+		return l.Listup(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// copyClient copies the client not to share redirect URIs with the stored one
+func copyClient(c Client) Client {
+	c.RedirectURIs = append([]string{}, c.RedirectURIs...)
+	return c
+}
+
+// CreateClient registers an OAuth client
+func (s *MemoryStore) CreateClient(c *Client) error {
+	log.Printf("db.MemoryStore.CreateClient %s", c.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[c.ID]; ok {
+		return errors.Errorf(`client %s already exists`, c.ID)
+	}
+	if c.CreatedOn.IsZero() {
+		c.CreatedOn = time.Now()
+	}
+	created := copyClient(*c)
+	sort.Strings(created.RedirectURIs)
+	s.clients[c.ID] = created
+	return nil
+}
+
+// LookupClient loads an OAuth client
+func (s *MemoryStore) LookupClient(id string) (*Client, error) {
+	log.Printf("db.MemoryStore.LookupClient %s", id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up client`)
+	}
+	c = copyClient(c)
+	return &c, nil
+}
+
+// UpdateClient updates an OAuth client
+func (s *MemoryStore) UpdateClient(c *Client) error {
+	log.Printf("db.MemoryStore.UpdateClient %s", c.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.clients[c.ID]
+	if !ok {
+		return errors.Wrap(sql.ErrNoRows, `looking up client`)
+	}
+	stored.Name = c.Name
+	stored.Scope = c.Scope
+	stored.GrantTypes = c.GrantTypes
+	stored.RedirectURIs = append([]string{}, c.RedirectURIs...)
+	sort.Strings(stored.RedirectURIs)
+	s.clients[c.ID] = stored
+	return nil
+}
+
+// DeleteClient deletes an OAuth client
+func (s *MemoryStore) DeleteClient(id string) error {
+	if id == "" {
+		return errors.New(`client ID is not valid`)
+	}
+	log.Printf("db.MemoryStore.DeleteClient %s", id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, id)
+	for hash, code := range s.authCodes {
+		if code.ClientID == id {
+			delete(s.authCodes, hash)
+		}
+	}
+	for hash, t := range s.refreshTokens {
+		if t.ClientID == id {
+			t.Revoked = true
+			s.refreshTokens[hash] = t
+		}
+	}
+	return nil
+}
+
+// ListupClients returns all OAuth clients
+func (s *MemoryStore) ListupClients() (ClientList, error) {
+	log.Printf("db.MemoryStore.ListupClients")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l := make(ClientList, 0, len(s.clients))
+	for _, c := range s.clients {
+		l = append(l, copyClient(c))
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].ID < l[j].ID })
+	return l, nil
+}
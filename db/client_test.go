@@ -0,0 +1,179 @@
+package db_test
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/pkg/errors"
+)
+
+func TestOAuthStore(t *testing.T) {
+	for name, store := range testStores(t) {
+		client := db.Client{
+			ID:           "webapp",
+			Name:         "Web App",
+			SecretHash:   "hash",
+			RedirectURIs: []string{"https://example.com/callback", "https://example.com/cb"},
+			Scope:        "openid",
+			GrantTypes:   "authorization_code refresh_token",
+		}
+		if err := store.CreateClient(&client); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.CreateClient(&client); err == nil {
+			t.Errorf("%s: duplicated client is created", name)
+			return
+		}
+		c, err := store.LookupClient("webapp")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if c.Name != client.Name || c.SecretHash != client.SecretHash || c.GrantTypes != client.GrantTypes || !reflect.DeepEqual(c.RedirectURIs, client.RedirectURIs) {
+			t.Errorf("%s: unexpected client: %v", name, c)
+			return
+		}
+		if _, err := store.LookupClient("unknown"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+
+		now := time.Now()
+		if err := store.CreateAuthorizationCode(&db.AuthorizationCode{
+			Hash:          "expired",
+			ClientID:      "webapp",
+			UserID:        "lookupID",
+			RedirectURI:   "https://example.com/cb",
+			CodeChallenge: "challenge",
+			ExpiresOn:     now.Add(-time.Minute),
+		}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.CreateAuthorizationCode(&db.AuthorizationCode{
+			Hash:          "valid",
+			ClientID:      "webapp",
+			UserID:        "lookupID",
+			RedirectURI:   "https://example.com/cb",
+			Scope:         "openid",
+			CodeChallenge: "challenge",
+			Nonce:         "n-0S6_WzA2Mj",
+			FamilyID:      "family",
+			ExpiresOn:     now.Add(time.Minute),
+		}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		// expired codes are purged when a new one is created
+		if _, err := store.ConsumeAuthorizationCode("expired"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		code, err := store.LookupAuthorizationCode("valid")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if code.Used || code.FamilyID != "family" {
+			t.Errorf("%s: unexpected code: %v", name, code)
+			return
+		}
+		code, err = store.ConsumeAuthorizationCode("valid")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if code.UserID != "lookupID" || code.Scope != "openid" || code.CodeChallenge != "challenge" || code.Nonce != "n-0S6_WzA2Mj" || code.FamilyID != "family" {
+			t.Errorf("%s: unexpected code: %v", name, code)
+			return
+		}
+		if code, err := store.LookupAuthorizationCode("valid"); err != nil || !code.Used {
+			t.Errorf("%s: code is not marked as used: %v %v", name, code, err)
+			return
+		}
+		if _, err := store.ConsumeAuthorizationCode("valid"); errors.Cause(err) != db.ErrAuthorizationCodeUsed {
+			t.Errorf("%s: ErrAuthorizationCodeUsed is expected, but %v", name, err)
+			return
+		}
+
+		// the code of a deleted client can not be used
+		if err := store.CreateAuthorizationCode(&db.AuthorizationCode{
+			Hash:          "deleted",
+			ClientID:      "webapp",
+			UserID:        "lookupID",
+			RedirectURI:   "https://example.com/cb",
+			CodeChallenge: "challenge",
+			ExpiresOn:     now.Add(time.Minute),
+		}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+
+		update := db.Client{
+			ID:           "webapp",
+			Name:         "Batch",
+			RedirectURIs: []string{},
+			Scope:        "billing:read",
+			GrantTypes:   "client_credentials",
+		}
+		if err := store.UpdateClient(&update); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.UpdateClient(&db.Client{ID: "unknown"}); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		clients, err := store.ListupClients()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(clients) != 1 {
+			t.Errorf("%s: 1 client is expected, but %d", name, len(clients))
+			return
+		}
+		c = &clients[0]
+		// secret is kept
+		if c.Name != "Batch" || c.SecretHash != "hash" || c.Scope != "billing:read" || c.GrantTypes != "client_credentials" || len(c.RedirectURIs) != 0 {
+			t.Errorf("%s: unexpected client: %v", name, c)
+			return
+		}
+
+		if err := store.CreateRefreshToken(&db.RefreshToken{
+			Hash:      "clienttoken",
+			FamilyID:  "clientfamily",
+			UserID:    "lookupID",
+			ClientID:  "webapp",
+			ExpiresOn: now.Add(time.Hour),
+		}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.DeleteClient("webapp"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if _, err := store.LookupClient("webapp"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		if _, err := store.ConsumeAuthorizationCode("deleted"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		rt, err := store.LookupRefreshToken("clienttoken")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if !rt.Revoked {
+			t.Errorf("%s: refresh token of deleted client is not revoked", name)
+			return
+		}
+	}
+}
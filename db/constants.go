@@ -1,6 +1,51 @@
 package db
 
+import "github.com/pkg/errors"
+
 const (
-	userTable = `users`
-	userSelectColumns = `id, username, password, created_on, modified_on`
+	userTable         = `users`
+	userSelectColumns = `id, username, password, is_admin, email, email_verified, created_on, modified_on`
+
+	refreshTokenTable         = `refresh_tokens`
+	refreshTokenSelectColumns = `token_hash, family_id, user_id, client_id, scope, audience, created_on, expires_on, rotated_on, revoked`
+
+	revokedTokenTable        = `revoked_tokens`
+	userTokenRevocationTable = `user_token_revocations`
+
+	roleTable           = `roles`
+	roleSelectColumns   = `name, description, created_on`
+	rolePermissionTable = `role_permissions`
+	userRoleTable       = `user_roles`
+
+	totpTable         = `totp_authenticators`
+	totpSelectColumns = `user_id, secret, confirmed, last_used_step, created_on`
+	recoveryCodeTable = `recovery_codes`
+
+	loginAttemptTable         = `login_attempts`
+	loginAttemptSelectColumns = `attempt_key, failures, last_failed_on, locked_until`
+
+	clientTable            = `oauth_clients`
+	clientSelectColumns    = `id, name, secret_hash, scope, grant_types, created_on`
+	clientRedirectURITable = `oauth_client_redirect_uris`
+	authCodeTable          = `oauth_authorization_codes`
+	authCodeSelectColumns  = `code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, family_id, created_on, expires_on, used`
+
+	passwordResetTokenTable         = `password_reset_tokens`
+	passwordResetTokenSelectColumns = `token_hash, user_id, created_on, expires_on, used`
+
+	passwordHistoryTable = `password_history`
+
+	signingKeyTable         = `signing_keys`
+	signingKeySelectColumns = `kid, private_key, public_key, created_on, retired_on`
+
+	schemaMigrationTable = `schema_migrations`
+)
+
+// errors returned by stores
+var (
+	ErrRefreshTokenRotated    = errors.New(`refresh token has been rotated already`)
+	ErrTOTPStepUsed           = errors.New(`TOTP code has been used already`)
+	ErrAuthorizationCodeUsed  = errors.New(`authorization code has been used already`)
+	ErrPasswordResetTokenUsed = errors.New(`password reset token has been used already`)
+	ErrDuplicateEmail         = errors.New(`email address is used by another user`)
 )
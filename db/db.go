@@ -2,44 +2,107 @@ package db
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3" // sqlite3 driver
 	"github.com/pkg/errors"
 )
 
-const driverName = `mysql`
-
-var _db *sql.DB // global database connection
+// supported drivers
+const (
+	DriverMySQL  = `mysql`
+	DriverSQLite = `sqlite3`
+	DriverMemory = `memory`
+)
 
-// Init database connection
-func Init(c *mysql.Config) (err error) {
-	if c == nil {
-		c = &mysql.Config{
-			User:      "root",
-			Net:       "tcp",
-			Addr:      "127.0.0.1:3306",
-			DBName:    "apidb",
-			ParseTime: true,
+// Open returns a Store for given driver.
+// When dsn is empty, the mysql driver connects to root@127.0.0.1:3306/apidb.
+func Open(driver, dsn string) (Store, error) {
+	switch driver {
+	case DriverMySQL:
+		if dsn == "" {
+			c := &mysql.Config{
+				User:      "root",
+				Net:       "tcp",
+				Addr:      "127.0.0.1:3306",
+				DBName:    "apidb",
+				ParseTime: true,
+			}
+			dsn = c.FormatDSN()
+		}
+		return OpenSQL(driver, dsn)
+	case DriverSQLite:
+		if dsn == "" {
+			return nil, errors.New(`dsn is required for sqlite3`)
 		}
+		return OpenSQL(driver, dsn)
+	case DriverMemory:
+		return NewMemoryStore(), nil
+	}
+	return nil, errors.Errorf(`unknown database driver: %s`, driver)
+}
+
+// OpenSQL opens a database connection and returns SQLStore
+func OpenSQL(driver, dsn string) (*SQLStore, error) {
+	conn, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, `connecting database`)
+	}
+	if driver == DriverSQLite {
+		// sqlite does not allow concurrent writers, and each connection
+		// to `:memory:` would be a distinct database
+		conn.SetMaxOpenConns(1)
 	}
+	return &SQLStore{db: conn, driver: driver}, nil
+}
 
-	dsn := c.FormatDSN()
+// DB returns underlying database connection
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
 
-	_db, err = sql.Open(driverName, dsn)
+// Close database connection
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// withTx runs fn in a transaction, which is committed if fn succeeds
+func (s *SQLStore) withTx(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return errors.Wrap(err, `connecting database`)
+		return errors.Wrap(err, `begining transaction`)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, `committing transaction`)
 	}
 	return nil
 }
 
-// BeginTx returns a transaction
-func BeginTx() (*sql.Tx, error) {
-	if _db == nil {
-		return nil, errors.New(`database connection has not been initialized`)
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         map[string]User{},
+		refreshTokens: map[string]RefreshToken{},
+		revokedTokens: map[string]RevokedToken{},
+		userRevokedOn: map[string]time.Time{},
+		roles:         map[string]Role{},
+		userRoles:     map[string][]string{},
+		totps:         map[string]TOTP{},
+		recoveryCodes: map[string]map[string]bool{},
+		loginAttempts: map[string]LoginAttempt{},
+		clients:       map[string]Client{},
+		authCodes:     map[string]AuthorizationCode{},
+		resetTokens:   map[string]PasswordResetToken{},
+		passwords:     map[string][]string{},
 	}
-	tx, err := _db.Begin()
-	if err != nil {
-		return nil, errors.Wrap(err, `begining transaction`)
-	}
-	return tx, nil
+}
+
+// Close does nothing
+func (s *MemoryStore) Close() error {
+	return nil
 }
@@ -1,20 +1,71 @@
 package db_test
 
 import (
+	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/charakoba-com/auth-api/db"
+	"github.com/go-sql-driver/mysql"
 )
 
-func TestDBConnection(t *testing.T) {
-	err := db.Init(nil)
+func TestOpen(t *testing.T) {
+	for _, driver := range []string{db.DriverMySQL, db.DriverMemory} {
+		store, err := db.Open(driver, "")
+		if err != nil {
+			t.Errorf("%s: %s", driver, err)
+			return
+		}
+		store.Close()
+	}
+	store, err := db.Open(db.DriverSQLite, ":memory:")
 	if err != nil {
 		t.Errorf("%s", err)
 		return
 	}
-	_, err = db.BeginTx()
-	if err != nil {
-		t.Errorf("%s", err)
+	store.Close()
+	if _, err := db.Open(db.DriverSQLite, ""); err == nil {
+		t.Errorf("sqlite3 without dsn should fail")
+		return
+	}
+	if _, err := db.Open("unknown", ""); err == nil {
+		t.Errorf("unknown driver should fail")
 		return
 	}
 }
+
+// testStores returns stores filled with sql/test_data.sql
+func testStores(t *testing.T) map[string]db.Store {
+	exTime := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem := db.NewMemoryStore()
+	fixtures := []db.User{
+		{ID: "lookupID", Name: "lookupuser", Password: "testpasswd", CreatedOn: exTime, ModifiedOn: mysql.NullTime{Time: exTime, Valid: true}},
+		{ID: "updateID", Name: "updateuser", Password: "testpasswd", CreatedOn: exTime},
+		{ID: "deleteID", Name: "deleteuser", Password: "testpasswd", CreatedOn: exTime},
+	}
+	for i := range fixtures {
+		if err := mem.CreateUser(&fixtures[i]); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	lite, err := db.OpenSQL(db.DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := lite.MigrateUp(0); err != nil {
+		t.Fatalf("%s", err)
+	}
+	stmts, err := ioutil.ReadFile("../sql/test_data.sql")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := lite.DB().Exec(string(stmts)); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return map[string]db.Store{
+		db.DriverMemory: mem,
+		db.DriverSQLite: lite,
+	}
+}
@@ -1,3 +0,0 @@
-package db
-
-var DB = _db
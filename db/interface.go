@@ -1,6 +1,8 @@
 package db
 
 import (
+	"database/sql"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -8,13 +10,346 @@ import (
 
 // User represents API user including admin and regular user
 type User struct {
-	ID         string
-	Name       string
-	Password   string
-	IsAdmin    bool
-	CreatedOn  time.Time
-	ModifiedOn mysql.NullTime
+	ID            string
+	Name          string
+	Password      string
+	IsAdmin       bool
+	Email         string // empty if unknown, unique otherwise
+	EmailVerified bool
+	CreatedOn     time.Time
+	ModifiedOn    mysql.NullTime
 }
 
 // UserList type
 type UserList []User
+
+// sort fields of UserQuery
+const (
+	UserSortID        = `id`
+	UserSortName      = `username`
+	UserSortCreatedOn = `created_on`
+)
+
+// UserQuery filters, sorts and pages users
+type UserQuery struct {
+	NamePrefix    string    // case-insensitive username prefix, any if empty
+	IsAdmin       *bool     // any if nil
+	CreatedAfter  time.Time // inclusive, unbounded if zero
+	CreatedBefore time.Time // exclusive, unbounded if zero
+	SortBy        string    // UserSortID if empty, ties are broken by ID
+	Descending    bool
+	After         *User // last user of the previous page, the first page if nil
+	Limit         int   // unlimited if zero
+}
+
+// UserPage is a page of users matching UserQuery
+type UserPage struct {
+	Users UserList
+	Total int  // users matching the filters in all pages
+	More  bool // another page follows
+}
+
+// UserStore is an interface which persists users
+type UserStore interface {
+	CreateUser(*User) error
+	LookupUser(id string) (*User, error)
+	UpdateUser(*User) error
+	DeleteUser(id string) error
+	// ListupUsers returns all users if the query is nil
+	ListupUsers(q *UserQuery) (*UserPage, error)
+	// LookupUserByEmail fails with sql.ErrNoRows if no user has the address
+	LookupUserByEmail(email string) (*User, error)
+	// VerifyUserEmail fails with sql.ErrNoRows unless the user has the address
+	VerifyUserEmail(id, email string) error
+}
+
+// RefreshToken represents an opaque refresh token.
+// Only the hash of the token is stored.
+// Tokens rotated from the same login share FamilyID.
+// ClientID and Scope are set for tokens issued to OAuth clients,
+// and Audience for tokens issued by `/auth`.
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	UserID    string
+	ClientID  string
+	Scope     string
+	Audience  string
+	CreatedOn time.Time
+	ExpiresOn time.Time
+	RotatedOn mysql.NullTime
+	Revoked   bool
+}
+
+// RefreshTokenStore is an interface which persists refresh tokens
+type RefreshTokenStore interface {
+	CreateRefreshToken(*RefreshToken) error
+	LookupRefreshToken(hash string) (*RefreshToken, error)
+	// RotateRefreshToken marks the token as rotated and stores next one.
+	// ErrRefreshTokenRotated is returned when the token has been rotated already.
+	RotateRefreshToken(hash string, next *RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID string) error
+}
+
+// RevokedToken represents a revoked access token.
+// It is kept until the token expires.
+type RevokedToken struct {
+	JTI       string
+	ExpiresOn time.Time
+}
+
+// RevocationStore is an interface which persists revoked access tokens
+type RevocationStore interface {
+	RevokeToken(*RevokedToken) error
+	IsTokenRevoked(jti string) (bool, error)
+	PurgeRevokedTokens(now time.Time) error
+	// RevokeUserTokens revokes all tokens of the user issued before given time
+	RevokeUserTokens(userID string, before time.Time) error
+	// LookupUserTokensRevokedOn returns zero time if tokens of the user have never been revoked
+	LookupUserTokensRevokedOn(userID string) (time.Time, error)
+}
+
+// TokenStore is an interface which persists refresh tokens and revocations
+type TokenStore interface {
+	RefreshTokenStore
+	RevocationStore
+}
+
+// Role is a named set of permissions, e.g. `billing:read`, assigned to users
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+	CreatedOn   time.Time
+}
+
+// RoleList type
+type RoleList []Role
+
+// RoleStore is an interface which persists roles, their permissions and role assignments
+type RoleStore interface {
+	CreateRole(*Role) error
+	LookupRole(name string) (*Role, error)
+	ListupRoles() (RoleList, error)
+	DeleteRole(name string) error
+	GrantPermission(role, permission string) error
+	RevokePermission(role, permission string) error
+	AssignRole(userID, role string) error
+	UnassignRole(userID, role string) error
+	LookupUserRoles(userID string) (RoleList, error)
+}
+
+// TOTP is a TOTP authenticator enrolled by an user.
+// It is not required at authentication until confirmed.
+type TOTP struct {
+	UserID       string
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+	CreatedOn    time.Time
+}
+
+// MFAStore is an interface which persists second factors of users
+type MFAStore interface {
+	// CreateTOTP replaces the authenticator and recovery codes of the user.
+	// Only hashes of recovery codes are stored.
+	CreateTOTP(t *TOTP, recoveryCodeHashes []string) error
+	LookupTOTP(userID string) (*TOTP, error)
+	ConfirmTOTP(userID string) error
+	// UseTOTPStep records the time step of an accepted code.
+	// ErrTOTPStepUsed is returned unless the step is newer than the last one.
+	UseTOTPStep(userID string, step int64) error
+	// DeleteTOTP deletes the authenticator and recovery codes of the user
+	DeleteTOTP(userID string) error
+	// UseRecoveryCode consumes a recovery code.
+	// sql.ErrNoRows is returned when there is no such unused code.
+	UseRecoveryCode(userID, hash string) error
+}
+
+// LoginAttempt counts failed authentications of a key,
+// which is an user or a client IP address
+type LoginAttempt struct {
+	Key          string
+	Failures     int
+	LastFailedOn time.Time
+	LockedUntil  time.Time
+}
+
+// LockoutStore is an interface which persists failed authentications
+type LockoutStore interface {
+	// LookupLoginAttempt returns an attempt without failures if the key has never failed
+	LookupLoginAttempt(key string) (*LoginAttempt, error)
+	// AddLoginFailure counts up failures atomically and returns the result.
+	// Failures before since are forgotten.
+	AddLoginFailure(key string, now, since time.Time) (*LoginAttempt, error)
+	LockLogin(key string, until time.Time) error
+	ResetLoginAttempts(key string) error
+}
+
+// Client is an OAuth 2.0 client registered to the authorization server.
+// Confidential clients have a secret, of which only the hash is stored.
+type Client struct {
+	ID           string
+	Name         string
+	SecretHash   string // empty for public clients
+	RedirectURIs []string
+	Scope        string // space separated scopes the client may be granted, any if empty
+	GrantTypes   string // space separated grant types the client may use
+	CreatedOn    time.Time
+}
+
+// ClientList type
+type ClientList []Client
+
+// AuthorizationCode is an OAuth 2.0 authorization code bound to
+// a PKCE code challenge. Only the hash of the code is stored.
+// The refresh token issued for the code starts the token family of FamilyID.
+type AuthorizationCode struct {
+	Hash          string
+	ClientID      string
+	UserID        string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string // S256
+	Nonce         string // OpenID Connect nonce to be put into the ID token
+	FamilyID      string
+	CreatedOn     time.Time
+	ExpiresOn     time.Time
+	Used          bool
+}
+
+// OAuthStore is an interface which persists OAuth 2.0 clients and authorization codes
+type OAuthStore interface {
+	CreateClient(*Client) error
+	LookupClient(id string) (*Client, error)
+	// UpdateClient replaces attributes and redirect URIs of the client except its secret
+	UpdateClient(*Client) error
+	// DeleteClient deletes the client with its authorization codes, and revokes its refresh tokens
+	DeleteClient(id string) error
+	ListupClients() (ClientList, error)
+	// CreateAuthorizationCode stores the code, and purges expired ones
+	CreateAuthorizationCode(*AuthorizationCode) error
+	// LookupAuthorizationCode returns the code without consuming it
+	LookupAuthorizationCode(hash string) (*AuthorizationCode, error)
+	// ConsumeAuthorizationCode marks the code as used and returns it.
+	// ErrAuthorizationCodeUsed is returned when it has been used already.
+	ConsumeAuthorizationCode(hash string) (*AuthorizationCode, error)
+}
+
+// PasswordResetToken is a single-use token resetting the password of an user,
+// which is delivered by email. Only the hash of the token is stored.
+type PasswordResetToken struct {
+	Hash      string
+	UserID    string
+	CreatedOn time.Time
+	ExpiresOn time.Time
+	Used      bool
+}
+
+// PasswordResetStore is an interface which persists password reset tokens
+type PasswordResetStore interface {
+	// CreatePasswordResetToken stores the token, and purges expired ones
+	CreatePasswordResetToken(*PasswordResetToken) error
+	// LookupPasswordResetToken loads the token without consuming it
+	LookupPasswordResetToken(hash string) (*PasswordResetToken, error)
+	// ConsumePasswordResetToken marks the token as used and returns it.
+	// ErrPasswordResetTokenUsed is returned when it has been used already.
+	ConsumePasswordResetToken(hash string) (*PasswordResetToken, error)
+	// DeleteUserPasswordResetTokens deletes outstanding tokens of the user
+	DeleteUserPasswordResetTokens(userID string) error
+}
+
+// PasswordHistoryStore is an interface which retains recent password hashes of users
+type PasswordHistoryStore interface {
+	// AddPasswordHistory stores the hash, and deletes hashes older than the last keep ones
+	AddPasswordHistory(userID, hash string, keep int) error
+	// LookupPasswordHistory returns hashes of the user, the most recent first
+	LookupPasswordHistory(userID string) ([]string, error)
+}
+
+// SigningKey is a key of the signing key ring, which is stored at rotation so that
+// rotated keys survive restarts and are shared by replicas.
+// PrivateKey is a PEM block, encrypted if the key passphrase is configured.
+// It is empty for keys whose private key is not held by the store, such as the key file.
+type SigningKey struct {
+	ID         string
+	PrivateKey string
+	PublicKey  string
+	CreatedOn  time.Time
+	RetiredOn  mysql.NullTime
+}
+
+// SigningKeyStore is an interface which persists the signing key ring
+type SigningKeyStore interface {
+	// ListupSigningKeys returns all stored keys, the newest first
+	ListupSigningKeys() ([]SigningKey, error)
+	// RotateSigningKey retires active keys, storing current one if it is missing,
+	// and stores next one as the active key
+	RotateSigningKey(current, next *SigningKey) error
+}
+
+// Store is an interface which aggregates all stores the API server uses
+type Store interface {
+	UserStore
+	TokenStore
+	RoleStore
+	MFAStore
+	LockoutStore
+	OAuthStore
+	PasswordResetStore
+	PasswordHistoryStore
+	SigningKeyStore
+	Close() error
+}
+
+// Migration is a versioned change of the database schema
+type Migration struct {
+	Version     int
+	Description string
+	Up          map[string][]string // statements by driver
+	Down        map[string][]string
+}
+
+// MigrationStatus tells whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedOn time.Time
+}
+
+// Migrator is an interface which applies Migrations to the database.
+// Only SQLStore implements it, as MemoryStore has no schema.
+type Migrator interface {
+	// MigrateUp applies pending migrations up to the version, or all of them if version is 0
+	MigrateUp(version int) ([]Migration, error)
+	// MigrateDown reverts the last applied migrations
+	MigrateDown(steps int) ([]Migration, error)
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// SQLStore is a Store backed by database/sql (MySQL or SQLite)
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// MemoryStore is a Store which keeps everything in process memory.
+// It is intended for tests and local development.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	users         map[string]User
+	refreshTokens map[string]RefreshToken
+	revokedTokens map[string]RevokedToken
+	userRevokedOn map[string]time.Time
+	roles         map[string]Role
+	userRoles     map[string][]string
+	totps         map[string]TOTP
+	recoveryCodes map[string]map[string]bool // user ID -> code hash -> used
+	loginAttempts map[string]LoginAttempt
+	clients       map[string]Client
+	authCodes     map[string]AuthorizationCode
+	resetTokens   map[string]PasswordResetToken
+	passwords     map[string][]string // password history by user ID, the most recent first
+	signingKeys   []SigningKey        // the newest first
+}
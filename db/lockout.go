@@ -0,0 +1,213 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UserAttemptKey returns the key of LoginAttempt counting failures of the user
+func UserAttemptKey(userID string) string {
+	return "user:" + userID
+}
+
+// IPAttemptKey returns the key of LoginAttempt counting failures from the client IP address
+func IPAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Scan raw database row to login attempt
+func (a *LoginAttempt) Scan(scanner interface {
+	Scan(...interface{}) error
+}) error {
+	return scanner.Scan(&a.Key, &a.Failures, &a.LastFailedOn, &a.LockedUntil)
+}
+
+// Load login attempt by key
+func (a *LoginAttempt) Load(tx *sql.Tx, key string) error {
+	log.Printf("db.LoginAttempt.Load %s", key)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(loginAttemptSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(loginAttemptTable)
+	stmt.WriteString(` WHERE attempt_key = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), key)
+
+	row := tx.QueryRow(stmt.String(), key)
+
+	if err := a.Scan(row); err != nil {
+		return errors.Wrap(err, "scanning row")
+	}
+	return nil
+}
+
+// AddFailure counts up failures of the key.
+// The counter restarts when the last failure is before since.
+func (a *LoginAttempt) AddFailure(tx *sql.Tx, now, since time.Time) error {
+	log.Printf("db.LoginAttempt.AddFailure %s", a.Key)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(loginAttemptTable)
+	stmt.WriteString(` SET failures = CASE WHEN last_failed_on < ? THEN 1 ELSE failures + 1 END, last_failed_on = ? WHERE attempt_key = ?`)
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s", stmt.String(), since, now, a.Key)
+
+	res, err := tx.Exec(stmt.String(), since, now, a.Key)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, `counting affected rows`)
+	}
+	if n == 1 {
+		return nil
+	}
+
+	stmt.Reset()
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(loginAttemptTable)
+	stmt.WriteString(` (attempt_key, failures, last_failed_on, locked_until) VALUES (?, ?, ?, ?)`)
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), a.Key, now)
+
+	// locked_until in the past means not locked
+	_, err = tx.Exec(stmt.String(), a.Key, 1, now, now)
+	return err
+}
+
+// Lock the key until given time
+func (a *LoginAttempt) Lock(tx *sql.Tx, until time.Time) error {
+	log.Printf("db.LoginAttempt.Lock %s", a.Key)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(loginAttemptTable)
+	stmt.WriteString(` SET locked_until = ? WHERE attempt_key = ?`)
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), until, a.Key)
+
+	_, err := tx.Exec(stmt.String(), until, a.Key)
+	return err
+}
+
+// Delete login attempt
+func (a *LoginAttempt) Delete(tx *sql.Tx) error {
+	log.Printf("db.LoginAttempt.Delete %s", a.Key)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(loginAttemptTable)
+	stmt.WriteString(` WHERE attempt_key = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), a.Key)
+
+	_, err := tx.Exec(stmt.String(), a.Key)
+	return err
+}
+
+// LookupLoginAttempt loads failed authentications of the key
+func (s *SQLStore) LookupLoginAttempt(key string) (*LoginAttempt, error) {
+	var a LoginAttempt
+	err := s.withTx(func(tx *sql.Tx) error {
+		return a.Load(tx, key)
+	})
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return &LoginAttempt{Key: key}, nil
+		}
+		return nil, err
+	}
+	return &a, nil
+}
+
+// AddLoginFailure counts up failures of the key
+func (s *SQLStore) AddLoginFailure(key string, now, since time.Time) (*LoginAttempt, error) {
+	a := LoginAttempt{Key: key}
+	err := s.withTx(func(tx *sql.Tx) error {
+		if err := a.AddFailure(tx, now, since); err != nil {
+			return err
+		}
+		return a.Load(tx, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// LockLogin locks the key until given time
+func (s *SQLStore) LockLogin(key string, until time.Time) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		a := LoginAttempt{Key: key}
+		return a.Lock(tx, until)
+	})
+}
+
+// ResetLoginAttempts forgets failures of the key, and unlocks it
+func (s *SQLStore) ResetLoginAttempts(key string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		a := LoginAttempt{Key: key}
+		return a.Delete(tx)
+	})
+}
+
+// LookupLoginAttempt loads failed authentications of the key
+func (s *MemoryStore) LookupLoginAttempt(key string) (*LoginAttempt, error) {
+	log.Printf("db.MemoryStore.LookupLoginAttempt %s", key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.loginAttempts[key]
+	if !ok {
+		a.Key = key
+	}
+	return &a, nil
+}
+
+// AddLoginFailure counts up failures of the key
+func (s *MemoryStore) AddLoginFailure(key string, now, since time.Time) (*LoginAttempt, error) {
+	log.Printf("db.MemoryStore.AddLoginFailure %s", key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.loginAttempts[key]
+	if !ok {
+		a = LoginAttempt{Key: key, LockedUntil: now}
+	}
+	if a.LastFailedOn.Before(since) {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailedOn = now
+	s.loginAttempts[key] = a
+	return &a, nil
+}
+
+// LockLogin locks the key until given time
+func (s *MemoryStore) LockLogin(key string, until time.Time) error {
+	log.Printf("db.MemoryStore.LockLogin %s", key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.loginAttempts[key]
+	if !ok {
+		return nil
+	}
+	a.LockedUntil = until
+	s.loginAttempts[key] = a
+	return nil
+}
+
+// ResetLoginAttempts forgets failures of the key, and unlocks it
+func (s *MemoryStore) ResetLoginAttempts(key string) error {
+	log.Printf("db.MemoryStore.ResetLoginAttempts %s", key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginAttempts, key)
+	return nil
+}
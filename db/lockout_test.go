@@ -0,0 +1,97 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+)
+
+func TestLockoutStore(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	for name, store := range testStores(t) {
+		a, err := store.LookupLoginAttempt("user:lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if a.Failures != 0 || a.LockedUntil.After(now) {
+			t.Errorf("%s: no failures are expected: %v", name, a)
+			return
+		}
+		for i := 1; i <= 3; i++ {
+			a, err = store.AddLoginFailure("user:lookupID", now, now.Add(-time.Hour))
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+			if a.Failures != i {
+				t.Errorf("%s: %d failures are expected, but %d", name, i, a.Failures)
+				return
+			}
+		}
+		// old failures are forgotten
+		later := now.Add(2 * time.Hour)
+		a, err = store.AddLoginFailure("user:lookupID", later, later.Add(-time.Hour))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if a.Failures != 1 {
+			t.Errorf("%s: failures should restart, but %d", name, a.Failures)
+			return
+		}
+		if err := store.LockLogin("user:lookupID", later.Add(time.Minute)); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		a, err = store.LookupLoginAttempt("user:lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if !a.LockedUntil.Equal(later.Add(time.Minute)) {
+			t.Errorf("%s: %s != %s", name, a.LockedUntil, later.Add(time.Minute))
+			return
+		}
+		if err := store.ResetLoginAttempts("user:lookupID"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		a, err = store.LookupLoginAttempt("user:lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if a.Failures != 0 {
+			t.Errorf("%s: failures should be reset: %v", name, a)
+			return
+		}
+		store.Close()
+	}
+}
+
+func TestDeleteUserLoginAttempts(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	for name, store := range testStores(t) {
+		if _, err := store.AddLoginFailure(db.UserAttemptKey("deleteID"), now, now.Add(-time.Hour)); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.DeleteUser("deleteID"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		// a user recreated with the same ID does not inherit the failures
+		a, err := store.LookupLoginAttempt(db.UserAttemptKey("deleteID"))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if a.Failures != 0 {
+			t.Errorf("%s: failures should be deleted with the user: %v", name, a)
+			return
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// statements returns the statements for driver
+func (m *Migration) statements(stmts map[string][]string, driver string) ([]string, error) {
+	s, ok := stmts[driver]
+	if !ok {
+		return nil, errors.Errorf(`migration %d has no statements for %s`, m.Version, driver)
+	}
+	return s, nil
+}
+
+// Apply runs the up statements of the migration and records it
+func (m *Migration) Apply(tx *sql.Tx, driver string) error {
+	log.Printf("db.Migration.Apply %d %s", m.Version, m.Description)
+
+	stmts, err := m.statements(m.Up, driver)
+	if err != nil {
+		return err
+	}
+	for _, s := range stmts {
+		log.Printf("SQL QUERY: %s", s)
+		if _, err := tx.Exec(s); err != nil {
+			return errors.Wrapf(err, `applying migration %d`, m.Version)
+		}
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(schemaMigrationTable)
+	stmt.WriteString(` (version, description, applied_on) VALUES (?, ?, ?)`)
+	log.Printf("SQL QUERY: %s: with values %d, %s", stmt.String(), m.Version, m.Description)
+
+	_, err = tx.Exec(stmt.String(), m.Version, m.Description, time.Now())
+	return err
+}
+
+// Revert runs the down statements of the migration and forgets it
+func (m *Migration) Revert(tx *sql.Tx, driver string) error {
+	log.Printf("db.Migration.Revert %d %s", m.Version, m.Description)
+
+	stmts, err := m.statements(m.Down, driver)
+	if err != nil {
+		return err
+	}
+	for _, s := range stmts {
+		log.Printf("SQL QUERY: %s", s)
+		if _, err := tx.Exec(s); err != nil {
+			return errors.Wrapf(err, `reverting migration %d`, m.Version)
+		}
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(schemaMigrationTable)
+	stmt.WriteString(` WHERE version = ?`)
+	log.Printf("SQL QUERY: %s: with values %d", stmt.String(), m.Version)
+
+	_, err = tx.Exec(stmt.String(), m.Version)
+	return err
+}
+
+// createMigrationTable creates the table recording applied migrations
+func (s *SQLStore) createMigrationTable() error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`CREATE TABLE IF NOT EXISTS `)
+	stmt.WriteString(schemaMigrationTable)
+	stmt.WriteString(` (version INT NOT NULL, description VARCHAR(256) NOT NULL, applied_on DATETIME NOT NULL, PRIMARY KEY(version))`)
+	if s.driver == DriverMySQL {
+		stmt.WriteString(mysqlTableOptions)
+	}
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	_, err := s.db.Exec(stmt.String())
+	return errors.Wrap(err, `creating migration table`)
+}
+
+// appliedMigrations returns applied versions and when they were applied
+func (s *SQLStore) appliedMigrations() (map[int]time.Time, error) {
+	if err := s.createMigrationTable(); err != nil {
+		return nil, err
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT version, applied_on FROM `)
+	stmt.WriteString(schemaMigrationTable)
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	rows, err := s.db.Query(stmt.String())
+	if err != nil {
+		return nil, errors.Wrap(err, `loading applied migrations`)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedOn time.Time
+		if err := rows.Scan(&version, &appliedOn); err != nil {
+			return nil, errors.Wrap(err, `scanning row`)
+		}
+		applied[version] = appliedOn
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, `loading applied migrations`)
+	}
+	for version := range applied {
+		if version > Migrations[len(Migrations)-1].Version {
+			return nil, errors.Errorf(`database schema version %d is newer than this build`, version)
+		}
+	}
+	return applied, nil
+}
+
+// MigrateUp applies pending migrations up to the version, or all of them if version is 0.
+// Each migration is applied in its own transaction.
+func (s *SQLStore) MigrateUp(version int) ([]Migration, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range Migrations {
+		if version > 0 && m.Version > version {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		m := m
+		if err := s.withTx(func(tx *sql.Tx) error {
+			return m.Apply(tx, s.driver)
+		}); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last applied migrations, newest first
+func (s *SQLStore) MigrateDown(steps int) ([]Migration, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := Migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := s.withTx(func(tx *sql.Tx) error {
+			return m.Revert(tx, s.driver)
+		}); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrationStatus returns every migration and whether it has been applied
+func (s *SQLStore) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(Migrations))
+	for i, m := range Migrations {
+		appliedOn, ok := applied[m.Version]
+		status[i] = MigrationStatus{
+			Migration: m,
+			Applied:   ok,
+			AppliedOn: appliedOn,
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,99 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/charakoba-com/auth-api/db"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, m := range db.Migrations {
+		if i > 0 && m.Version <= db.Migrations[i-1].Version {
+			t.Errorf("migration %d is not newer than %d", m.Version, db.Migrations[i-1].Version)
+			return
+		}
+		for _, driver := range []string{db.DriverMySQL, db.DriverSQLite} {
+			if len(m.Up[driver]) == 0 || len(m.Down[driver]) == 0 {
+				t.Errorf("migration %d lacks statements for %s", m.Version, driver)
+				return
+			}
+		}
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	store, err := db.OpenSQL(db.DriverSQLite, ":memory:")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer store.Close()
+	latest := db.Migrations[len(db.Migrations)-1].Version
+
+	applied, err := store.MigrateUp(1)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Errorf("%v is applied, only 1 is expected", applied)
+		return
+	}
+	applied, err = store.MigrateUp(0)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(applied) != len(db.Migrations)-1 {
+		t.Errorf("%d migrations are applied, %d are expected", len(applied), len(db.Migrations)-1)
+		return
+	}
+	// already up to date
+	applied, err = store.MigrateUp(0)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(applied) != 0 {
+		t.Errorf("%v is applied again", applied)
+		return
+	}
+	if _, err := store.LookupLoginAttempt("user:lookupID"); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+
+	reverted, err := store.MigrateDown(1)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(reverted) != 1 || reverted[0].Version != latest {
+		t.Errorf("%v is reverted, only %d is expected", reverted, latest)
+		return
+	}
+	status, err := store.MigrationStatus()
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	for _, st := range status {
+		if st.Applied != (st.Version != latest) {
+			t.Errorf("migration %d: applied %t", st.Version, st.Applied)
+			return
+		}
+		if st.Applied && st.AppliedOn.IsZero() {
+			t.Errorf("migration %d: applied_on is not recorded", st.Version)
+			return
+		}
+	}
+
+	if _, err := store.MigrateDown(len(db.Migrations)); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if _, err := store.LookupUser("lookupID"); err == nil {
+		t.Errorf("users table is not dropped")
+		return
+	}
+}
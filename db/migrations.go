@@ -0,0 +1,454 @@
+package db
+
+// mysqlTableOptions is appended to CREATE TABLE statements for MySQL
+const mysqlTableOptions = ` ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// Migrations is the history of the database schema, ordered by version.
+// Append a new migration to change the schema; never edit applied ones.
+// The first migrations create tables only if they do not exist, so that
+// databases initialized by the former sql/authapi.sql can be adopted.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create users",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS users (
+        id VARCHAR(64) NOT NULL,
+        username VARCHAR(128) NOT NULL,
+        password VARCHAR(1024) NOT NULL,
+        is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+        created_on DATETIME NOT NULL,
+        modified_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+        PRIMARY KEY(id)
+)` + mysqlTableOptions},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS users (
+        id VARCHAR(64) NOT NULL,
+        username VARCHAR(128) NOT NULL,
+        password VARCHAR(1024) NOT NULL,
+        is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+        created_on DATETIME NOT NULL,
+        modified_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY(id)
+)`},
+		},
+		Down: allDrivers(`DROP TABLE IF EXISTS users`),
+	},
+	{
+		Version:     2,
+		Description: "create refresh_tokens",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS refresh_tokens (
+        token_hash CHAR(64) NOT NULL,
+        family_id VARCHAR(64) NOT NULL,
+        user_id VARCHAR(64) NOT NULL,
+        created_on DATETIME NOT NULL,
+        expires_on DATETIME NOT NULL,
+        rotated_on DATETIME NULL DEFAULT NULL,
+        revoked BOOLEAN NOT NULL DEFAULT FALSE,
+        PRIMARY KEY(token_hash),
+        INDEX(family_id)
+)` + mysqlTableOptions},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS refresh_tokens (
+        token_hash CHAR(64) NOT NULL,
+        family_id VARCHAR(64) NOT NULL,
+        user_id VARCHAR(64) NOT NULL,
+        created_on DATETIME NOT NULL,
+        expires_on DATETIME NOT NULL,
+        rotated_on DATETIME NULL DEFAULT NULL,
+        revoked BOOLEAN NOT NULL DEFAULT FALSE,
+        PRIMARY KEY(token_hash)
+)`,
+				`CREATE INDEX IF NOT EXISTS refresh_tokens_family_id ON refresh_tokens (family_id)`,
+			},
+		},
+		Down: allDrivers(`DROP TABLE IF EXISTS refresh_tokens`),
+	},
+	{
+		Version:     3,
+		Description: "create revoked_tokens and user_token_revocations",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS revoked_tokens (
+        jti VARCHAR(64) NOT NULL,
+        expires_on DATETIME NOT NULL,
+        PRIMARY KEY(jti)
+)` + mysqlTableOptions,
+				`CREATE TABLE IF NOT EXISTS user_token_revocations (
+        user_id VARCHAR(64) NOT NULL,
+        revoked_on DATETIME(3) NOT NULL,
+        PRIMARY KEY(user_id)
+)` + mysqlTableOptions,
+			},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS revoked_tokens (
+        jti VARCHAR(64) NOT NULL,
+        expires_on DATETIME NOT NULL,
+        PRIMARY KEY(jti)
+)`,
+				`CREATE TABLE IF NOT EXISTS user_token_revocations (
+        user_id VARCHAR(64) NOT NULL,
+        revoked_on DATETIME NOT NULL,
+        PRIMARY KEY(user_id)
+)`,
+			},
+		},
+		Down: allDrivers(
+			`DROP TABLE IF EXISTS user_token_revocations`,
+			`DROP TABLE IF EXISTS revoked_tokens`,
+		),
+	},
+	{
+		Version:     4,
+		Description: "create roles, role_permissions and user_roles",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS roles (
+        name VARCHAR(64) NOT NULL,
+        description VARCHAR(256) NOT NULL DEFAULT '',
+        created_on DATETIME NOT NULL,
+        PRIMARY KEY(name)
+)` + mysqlTableOptions,
+				`CREATE TABLE IF NOT EXISTS role_permissions (
+        role VARCHAR(64) NOT NULL,
+        permission VARCHAR(128) NOT NULL,
+        PRIMARY KEY(role, permission)
+)` + mysqlTableOptions,
+				`CREATE TABLE IF NOT EXISTS user_roles (
+        user_id VARCHAR(64) NOT NULL,
+        role VARCHAR(64) NOT NULL,
+        PRIMARY KEY(user_id, role),
+        INDEX(role)
+)` + mysqlTableOptions,
+			},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS roles (
+        name VARCHAR(64) NOT NULL,
+        description VARCHAR(256) NOT NULL DEFAULT '',
+        created_on DATETIME NOT NULL,
+        PRIMARY KEY(name)
+)`,
+				`CREATE TABLE IF NOT EXISTS role_permissions (
+        role VARCHAR(64) NOT NULL,
+        permission VARCHAR(128) NOT NULL,
+        PRIMARY KEY(role, permission)
+)`,
+				`CREATE TABLE IF NOT EXISTS user_roles (
+        user_id VARCHAR(64) NOT NULL,
+        role VARCHAR(64) NOT NULL,
+        PRIMARY KEY(user_id, role)
+)`,
+				`CREATE INDEX IF NOT EXISTS user_roles_role ON user_roles (role)`,
+			},
+		},
+		Down: allDrivers(
+			`DROP TABLE IF EXISTS user_roles`,
+			`DROP TABLE IF EXISTS role_permissions`,
+			`DROP TABLE IF EXISTS roles`,
+		),
+	},
+	{
+		Version:     5,
+		Description: "create totp_authenticators and recovery_codes",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS totp_authenticators (
+        user_id VARCHAR(64) NOT NULL,
+        secret VARCHAR(64) NOT NULL,
+        confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+        last_used_step BIGINT NOT NULL DEFAULT 0,
+        created_on DATETIME NOT NULL,
+        PRIMARY KEY(user_id)
+)` + mysqlTableOptions,
+				`CREATE TABLE IF NOT EXISTS recovery_codes (
+        user_id VARCHAR(64) NOT NULL,
+        code_hash CHAR(64) NOT NULL,
+        used_on DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY(user_id, code_hash)
+)` + mysqlTableOptions,
+			},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS totp_authenticators (
+        user_id VARCHAR(64) NOT NULL,
+        secret VARCHAR(64) NOT NULL,
+        confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+        last_used_step BIGINT NOT NULL DEFAULT 0,
+        created_on DATETIME NOT NULL,
+        PRIMARY KEY(user_id)
+)`,
+				`CREATE TABLE IF NOT EXISTS recovery_codes (
+        user_id VARCHAR(64) NOT NULL,
+        code_hash CHAR(64) NOT NULL,
+        used_on DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY(user_id, code_hash)
+)`,
+			},
+		},
+		Down: allDrivers(
+			`DROP TABLE IF EXISTS recovery_codes`,
+			`DROP TABLE IF EXISTS totp_authenticators`,
+		),
+	},
+	{
+		Version:     6,
+		Description: "create login_attempts",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS login_attempts (
+        attempt_key VARCHAR(128) NOT NULL,
+        failures INT NOT NULL DEFAULT 0,
+        last_failed_on DATETIME NOT NULL,
+        locked_until DATETIME NOT NULL,
+        PRIMARY KEY(attempt_key)
+)` + mysqlTableOptions},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS login_attempts (
+        attempt_key VARCHAR(128) NOT NULL,
+        failures INT NOT NULL DEFAULT 0,
+        last_failed_on DATETIME NOT NULL,
+        locked_until DATETIME NOT NULL,
+        PRIMARY KEY(attempt_key)
+)`},
+		},
+		Down: allDrivers(`DROP TABLE IF EXISTS login_attempts`),
+	},
+	{
+		Version:     7,
+		Description: "create oauth_clients, oauth_client_redirect_uris and oauth_authorization_codes",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE oauth_clients (
+        id VARCHAR(64) NOT NULL,
+        name VARCHAR(128) NOT NULL,
+        secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+        created_on DATETIME NOT NULL,
+        PRIMARY KEY(id)
+)` + mysqlTableOptions,
+				`CREATE TABLE oauth_client_redirect_uris (
+        client_id VARCHAR(64) NOT NULL,
+        redirect_uri VARCHAR(512) NOT NULL,
+        PRIMARY KEY(client_id, redirect_uri)
+)` + mysqlTableOptions,
+				`CREATE TABLE oauth_authorization_codes (
+        code_hash CHAR(64) NOT NULL,
+        client_id VARCHAR(64) NOT NULL,
+        user_id VARCHAR(64) NOT NULL,
+        redirect_uri VARCHAR(512) NOT NULL,
+        scope VARCHAR(512) NOT NULL DEFAULT '',
+        code_challenge VARCHAR(128) NOT NULL,
+        created_on DATETIME NOT NULL,
+        expires_on DATETIME NOT NULL,
+        used BOOLEAN NOT NULL DEFAULT FALSE,
+        PRIMARY KEY(code_hash),
+        INDEX(expires_on)
+)` + mysqlTableOptions,
+			},
+			DriverSQLite: {`CREATE TABLE oauth_clients (
+        id VARCHAR(64) NOT NULL,
+        name VARCHAR(128) NOT NULL,
+        secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+        created_on DATETIME NOT NULL,
+        PRIMARY KEY(id)
+)`,
+				`CREATE TABLE oauth_client_redirect_uris (
+        client_id VARCHAR(64) NOT NULL,
+        redirect_uri VARCHAR(512) NOT NULL,
+        PRIMARY KEY(client_id, redirect_uri)
+)`,
+				`CREATE TABLE oauth_authorization_codes (
+        code_hash CHAR(64) NOT NULL,
+        client_id VARCHAR(64) NOT NULL,
+        user_id VARCHAR(64) NOT NULL,
+        redirect_uri VARCHAR(512) NOT NULL,
+        scope VARCHAR(512) NOT NULL DEFAULT '',
+        code_challenge VARCHAR(128) NOT NULL,
+        created_on DATETIME NOT NULL,
+        expires_on DATETIME NOT NULL,
+        used BOOLEAN NOT NULL DEFAULT FALSE,
+        PRIMARY KEY(code_hash)
+)`,
+				`CREATE INDEX oauth_authorization_codes_expires_on ON oauth_authorization_codes (expires_on)`,
+			},
+		},
+		Down: allDrivers(
+			`DROP TABLE oauth_authorization_codes`,
+			`DROP TABLE oauth_client_redirect_uris`,
+			`DROP TABLE oauth_clients`,
+		),
+	},
+	{
+		Version:     8,
+		Description: "add client_id and scope to refresh_tokens",
+		Up: allDrivers(
+			`ALTER TABLE refresh_tokens ADD COLUMN client_id VARCHAR(64) NOT NULL DEFAULT ''`,
+			`ALTER TABLE refresh_tokens ADD COLUMN scope VARCHAR(512) NOT NULL DEFAULT ''`,
+		),
+		Down: allDrivers(
+			`ALTER TABLE refresh_tokens DROP COLUMN scope`,
+			`ALTER TABLE refresh_tokens DROP COLUMN client_id`,
+		),
+	},
+	{
+		Version:     9,
+		Description: "add nonce to oauth_authorization_codes",
+		Up: allDrivers(
+			`ALTER TABLE oauth_authorization_codes ADD COLUMN nonce VARCHAR(512) NOT NULL DEFAULT ''`,
+		),
+		Down: allDrivers(
+			`ALTER TABLE oauth_authorization_codes DROP COLUMN nonce`,
+		),
+	},
+	{
+		Version:     10,
+		Description: "add scope and grant_types to oauth_clients",
+		Up: allDrivers(
+			`ALTER TABLE oauth_clients ADD COLUMN scope VARCHAR(512) NOT NULL DEFAULT ''`,
+			`ALTER TABLE oauth_clients ADD COLUMN grant_types VARCHAR(255) NOT NULL DEFAULT 'authorization_code refresh_token'`,
+		),
+		Down: allDrivers(
+			`ALTER TABLE oauth_clients DROP COLUMN grant_types`,
+			`ALTER TABLE oauth_clients DROP COLUMN scope`,
+		),
+	},
+	{
+		Version:     11,
+		Description: "add audience to refresh_tokens",
+		Up: allDrivers(
+			`ALTER TABLE refresh_tokens ADD COLUMN audience VARCHAR(255) NOT NULL DEFAULT ''`,
+		),
+		Down: allDrivers(
+			`ALTER TABLE refresh_tokens DROP COLUMN audience`,
+		),
+	},
+	{
+		// users without email address have NULL, which is not subject to uniqueness.
+		// The column is added empty, so no duplicates exist when the index is created.
+		Version:     12,
+		Description: "add unique email to users",
+		Up: allDrivers(
+			`ALTER TABLE users ADD COLUMN email VARCHAR(255) NULL DEFAULT NULL`,
+			`CREATE UNIQUE INDEX users_email ON users (email)`,
+		),
+		Down: map[string][]string{
+			DriverMySQL: {
+				`DROP INDEX users_email ON users`,
+				`ALTER TABLE users DROP COLUMN email`,
+			},
+			DriverSQLite: {
+				`DROP INDEX users_email`,
+				`ALTER TABLE users DROP COLUMN email`,
+			},
+		},
+	},
+	{
+		Version:     13,
+		Description: "create password_reset_tokens",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+        token_hash CHAR(64) NOT NULL,
+        user_id VARCHAR(64) NOT NULL,
+        created_on DATETIME NOT NULL,
+        expires_on DATETIME NOT NULL,
+        used BOOLEAN NOT NULL DEFAULT FALSE,
+        PRIMARY KEY(token_hash),
+        INDEX(user_id)
+)` + mysqlTableOptions},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+        token_hash CHAR(64) NOT NULL,
+        user_id VARCHAR(64) NOT NULL,
+        created_on DATETIME NOT NULL,
+        expires_on DATETIME NOT NULL,
+        used BOOLEAN NOT NULL DEFAULT FALSE,
+        PRIMARY KEY(token_hash)
+)`, `CREATE INDEX IF NOT EXISTS password_reset_tokens_user_id ON password_reset_tokens (user_id)`},
+		},
+		Down: allDrivers(`DROP TABLE IF EXISTS password_reset_tokens`),
+	},
+	{
+		Version:     14,
+		Description: "add email_verified to users",
+		Up: allDrivers(
+			`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+		),
+		Down: allDrivers(
+			`ALTER TABLE users DROP COLUMN email_verified`,
+		),
+	},
+	{
+		Version:     15,
+		Description: "create password_history",
+		Up: map[string][]string{
+			// fractional seconds order passwords changed within a second
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS password_history (
+        user_id VARCHAR(64) NOT NULL,
+        password VARCHAR(1024) NOT NULL,
+        created_on DATETIME(6) NOT NULL,
+        PRIMARY KEY(user_id, password(255))
+)` + mysqlTableOptions},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS password_history (
+        user_id VARCHAR(64) NOT NULL,
+        password VARCHAR(1024) NOT NULL,
+        created_on DATETIME NOT NULL,
+        PRIMARY KEY(user_id, password)
+)`},
+		},
+		Down: allDrivers(`DROP TABLE IF EXISTS password_history`),
+	},
+	{
+		// SQLite compares created_on normalized by sqliteTime, which only an index
+		// on the same expression serves
+		Version:     16,
+		Description: "index users by sort fields",
+		Up: map[string][]string{
+			DriverMySQL: {
+				`CREATE INDEX users_username ON users (username, id)`,
+				`CREATE INDEX users_created_on ON users (created_on, id)`,
+			},
+			DriverSQLite: {
+				`CREATE INDEX users_username ON users (username, id)`,
+				`CREATE INDEX users_created_on ON users (strftime('%Y-%m-%d %H:%M:%f', created_on), id)`,
+			},
+		},
+		Down: map[string][]string{
+			DriverMySQL: {
+				`DROP INDEX users_username ON users`,
+				`DROP INDEX users_created_on ON users`,
+			},
+			DriverSQLite: {
+				`DROP INDEX users_username`,
+				`DROP INDEX users_created_on`,
+			},
+		},
+	},
+	{
+		Version:     17,
+		Description: "create signing_keys",
+		Up: map[string][]string{
+			DriverMySQL: {`CREATE TABLE IF NOT EXISTS signing_keys (
+        kid VARCHAR(64) NOT NULL,
+        private_key TEXT NOT NULL,
+        public_key TEXT NOT NULL,
+        created_on DATETIME(6) NOT NULL,
+        retired_on DATETIME(6) NULL DEFAULT NULL,
+        PRIMARY KEY(kid)
+)` + mysqlTableOptions},
+			DriverSQLite: {`CREATE TABLE IF NOT EXISTS signing_keys (
+        kid VARCHAR(64) NOT NULL,
+        private_key TEXT NOT NULL,
+        public_key TEXT NOT NULL,
+        created_on DATETIME NOT NULL,
+        retired_on DATETIME NULL DEFAULT NULL,
+        PRIMARY KEY(kid)
+)`},
+		},
+		Down: allDrivers(`DROP TABLE IF EXISTS signing_keys`),
+	},
+	{
+		Version:     18,
+		Description: "add family_id to oauth_authorization_codes",
+		Up: allDrivers(
+			`ALTER TABLE oauth_authorization_codes ADD COLUMN family_id VARCHAR(64) NOT NULL DEFAULT ''`,
+		),
+		Down: allDrivers(
+			`ALTER TABLE oauth_authorization_codes DROP COLUMN family_id`,
+		),
+	},
+}
+
+// allDrivers returns statements shared by every SQL driver
+func allDrivers(stmts ...string) map[string][]string {
+	return map[string][]string{
+		DriverMySQL:  stmts,
+		DriverSQLite: stmts,
+	}
+}
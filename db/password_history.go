@@ -0,0 +1,119 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// loadPasswordHistory returns password hashes of the user, the most recent first
+func loadPasswordHistory(tx *sql.Tx, userID string) ([]string, error) {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT password FROM `)
+	stmt.WriteString(passwordHistoryTable)
+	stmt.WriteString(` WHERE user_id = ? ORDER BY created_on DESC`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), userID)
+
+	rows, err := tx.Query(stmt.String(), userID)
+	if err != nil {
+		return nil, errors.Wrap(err, `querying stmt`)
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, errors.Wrap(err, `scanning row`)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// deletePasswordHistory deletes hashes of the user. Only the given hashes are
+// deleted if any.
+func deletePasswordHistory(tx *sql.Tx, userID string, hashes ...string) error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(passwordHistoryTable)
+	stmt.WriteString(` WHERE user_id = ?`)
+	if len(hashes) == 0 {
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), userID)
+		_, err := tx.Exec(stmt.String(), userID)
+		return err
+	}
+
+	stmt.WriteString(` AND password = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), userID)
+	for _, hash := range hashes {
+		if _, err := tx.Exec(stmt.String(), userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPasswordHistory stores the hash, and deletes hashes older than the last keep ones
+func (s *SQLStore) AddPasswordHistory(userID, hash string, keep int) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		stmt := bytes.Buffer{}
+		stmt.WriteString(`INSERT INTO `)
+		stmt.WriteString(passwordHistoryTable)
+		stmt.WriteString(` (user_id, password, created_on) VALUES (?, ?, ?)`)
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), userID)
+
+		if _, err := tx.Exec(stmt.String(), userID, hash, time.Now()); err != nil {
+			return err
+		}
+		hashes, err := loadPasswordHistory(tx, userID)
+		if err != nil {
+			return err
+		}
+		if len(hashes) <= keep {
+			return nil
+		}
+		return deletePasswordHistory(tx, userID, hashes[keep:]...)
+	})
+}
+
+// LookupPasswordHistory returns hashes of the user, the most recent first
+func (s *SQLStore) LookupPasswordHistory(userID string) ([]string, error) {
+	var hashes []string
+	err := s.withTx(func(tx *sql.Tx) (err error) {
+		hashes, err = loadPasswordHistory(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// AddPasswordHistory stores the hash, and deletes hashes older than the last keep ones
+func (s *MemoryStore) AddPasswordHistory(userID, hash string, keep int) error {
+	log.Printf("db.MemoryStore.AddPasswordHistory %s", userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes := append([]string{hash}, s.passwords[userID]...)
+	if len(hashes) > keep {
+		hashes = hashes[:keep]
+	}
+	s.passwords[userID] = hashes
+	return nil
+}
+
+// LookupPasswordHistory returns hashes of the user, the most recent first
+func (s *MemoryStore) LookupPasswordHistory(userID string) ([]string, error) {
+	log.Printf("db.MemoryStore.LookupPasswordHistory %s", userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hashes := make([]string, len(s.passwords[userID]))
+	copy(hashes, s.passwords[userID])
+	return hashes, nil
+}
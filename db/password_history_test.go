@@ -0,0 +1,41 @@
+package db_test
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPasswordHistoryStore(t *testing.T) {
+	for name, store := range testStores(t) {
+		for _, hash := range []string{"first", "second", "third", "fourth"} {
+			if err := store.AddPasswordHistory("lookupID", hash, 3); err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+		}
+		hashes, err := store.LookupPasswordHistory("lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if expected := []string{"fourth", "third", "second"}; !reflect.DeepEqual(hashes, expected) {
+			t.Errorf("%s: %v != %v", name, hashes, expected)
+			return
+		}
+
+		if err := store.DeleteUser("lookupID"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		hashes, err = store.LookupPasswordHistory("lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(hashes) != 0 {
+			t.Errorf("%s: history of deleted user is left: %v", name, hashes)
+			return
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scan raw database row to password reset token
+func (r *PasswordResetToken) Scan(scanner interface {
+	Scan(...interface{}) error
+}) error {
+	return scanner.Scan(&r.Hash, &r.UserID, &r.CreatedOn, &r.ExpiresOn, &r.Used)
+}
+
+// Create PasswordResetToken
+func (r *PasswordResetToken) Create(tx *sql.Tx) error {
+	log.Printf("db.PasswordResetToken.Create %s", r.UserID)
+
+	if r.CreatedOn.IsZero() {
+		r.CreatedOn = time.Now()
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(passwordResetTokenTable)
+	stmt.WriteString(` (token_hash, user_id, created_on, expires_on, used) VALUES (?, ?, ?, ?, ?)`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s", stmt.String(), r.UserID, r.CreatedOn, r.ExpiresOn)
+
+	_, err := tx.Exec(stmt.String(), r.Hash, r.UserID, r.CreatedOn, r.ExpiresOn, r.Used)
+	return err
+}
+
+// Load password reset token by hash
+func (r *PasswordResetToken) Load(tx *sql.Tx, hash string) error {
+	log.Printf("db.PasswordResetToken.Load")
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(passwordResetTokenSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(passwordResetTokenTable)
+	stmt.WriteString(` WHERE token_hash = ?`)
+
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	row := tx.QueryRow(stmt.String(), hash)
+
+	if err := r.Scan(row); err != nil {
+		return errors.Wrap(err, "scanning row")
+	}
+	return nil
+}
+
+// Use marks the token as used.
+// It fails with ErrPasswordResetTokenUsed unless the token is unused.
+func (r *PasswordResetToken) Use(tx *sql.Tx) error {
+	log.Printf("db.PasswordResetToken.Use %s", r.UserID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(passwordResetTokenTable)
+	stmt.WriteString(` SET used = ? WHERE token_hash = ? AND used = ?`)
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	res, err := tx.Exec(stmt.String(), true, r.Hash, false)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, `counting affected rows`)
+	}
+	if n != 1 {
+		return ErrPasswordResetTokenUsed
+	}
+	r.Used = true
+	return nil
+}
+
+// purgePasswordResetTokens deletes tokens expired before now
+func purgePasswordResetTokens(tx *sql.Tx, now time.Time) error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(passwordResetTokenTable)
+	stmt.WriteString(` WHERE expires_on < ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), now)
+
+	_, err := tx.Exec(stmt.String(), now)
+	return err
+}
+
+// deletePasswordResetTokens deletes all tokens of the user
+func deletePasswordResetTokens(tx *sql.Tx, userID string) error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(passwordResetTokenTable)
+	stmt.WriteString(` WHERE user_id = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), userID)
+
+	_, err := tx.Exec(stmt.String(), userID)
+	return err
+}
+
+// CreatePasswordResetToken stores the token, and purges expired ones
+func (s *SQLStore) CreatePasswordResetToken(r *PasswordResetToken) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := purgePasswordResetTokens(tx, time.Now()); err != nil {
+			return err
+		}
+		return r.Create(tx)
+	})
+}
+
+// LookupPasswordResetToken loads the token without consuming it
+func (s *SQLStore) LookupPasswordResetToken(hash string) (*PasswordResetToken, error) {
+	var r PasswordResetToken
+	err := s.withTx(func(tx *sql.Tx) error {
+		return r.Load(tx, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ConsumePasswordResetToken marks the token as used and returns it
+func (s *SQLStore) ConsumePasswordResetToken(hash string) (*PasswordResetToken, error) {
+	var r PasswordResetToken
+	err := s.withTx(func(tx *sql.Tx) error {
+		if err := r.Load(tx, hash); err != nil {
+			return err
+		}
+		if r.Used {
+			return ErrPasswordResetTokenUsed
+		}
+		return r.Use(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// DeleteUserPasswordResetTokens deletes outstanding tokens of the user
+func (s *SQLStore) DeleteUserPasswordResetTokens(userID string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		return deletePasswordResetTokens(tx, userID)
+	})
+}
+
+// CreatePasswordResetToken stores the token, and purges expired ones
+func (s *MemoryStore) CreatePasswordResetToken(r *PasswordResetToken) error {
+	log.Printf("db.MemoryStore.CreatePasswordResetToken %s", r.UserID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range s.resetTokens {
+		if token.ExpiresOn.Before(now) {
+			delete(s.resetTokens, hash)
+		}
+	}
+	if r.CreatedOn.IsZero() {
+		r.CreatedOn = now
+	}
+	s.resetTokens[r.Hash] = *r
+	return nil
+}
+
+// LookupPasswordResetToken loads the token without consuming it
+func (s *MemoryStore) LookupPasswordResetToken(hash string) (*PasswordResetToken, error) {
+	log.Printf("db.MemoryStore.LookupPasswordResetToken")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.resetTokens[hash]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up password reset token`)
+	}
+	return &r, nil
+}
+
+// ConsumePasswordResetToken marks the token as used and returns it
+func (s *MemoryStore) ConsumePasswordResetToken(hash string) (*PasswordResetToken, error) {
+	log.Printf("db.MemoryStore.ConsumePasswordResetToken")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.resetTokens[hash]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up password reset token`)
+	}
+	if r.Used {
+		return nil, ErrPasswordResetTokenUsed
+	}
+	r.Used = true
+	s.resetTokens[hash] = r
+	return &r, nil
+}
+
+// DeleteUserPasswordResetTokens deletes outstanding tokens of the user
+func (s *MemoryStore) DeleteUserPasswordResetTokens(userID string) error {
+	log.Printf("db.MemoryStore.DeleteUserPasswordResetTokens %s", userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteUserPasswordResetTokens(userID)
+	return nil
+}
+
+// deleteUserPasswordResetTokens requires s.mu to be locked
+func (s *MemoryStore) deleteUserPasswordResetTokens(userID string) {
+	for hash, token := range s.resetTokens {
+		if token.UserID == userID {
+			delete(s.resetTokens, hash)
+		}
+	}
+}
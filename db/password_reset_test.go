@@ -0,0 +1,57 @@
+package db_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/pkg/errors"
+)
+
+func TestPasswordResetStore(t *testing.T) {
+	for name, store := range testStores(t) {
+		now := time.Now()
+		for _, token := range []db.PasswordResetToken{
+			{Hash: "expired", UserID: "lookupID", ExpiresOn: now.Add(-time.Minute)},
+			{Hash: "valid", UserID: "lookupID", ExpiresOn: now.Add(time.Hour)},
+			{Hash: "other", UserID: "updateID", ExpiresOn: now.Add(time.Hour)},
+			{Hash: "deleted", UserID: "updateID", ExpiresOn: now.Add(time.Hour)},
+		} {
+			if err := store.CreatePasswordResetToken(&token); err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+		}
+		// expired tokens are purged when a new one is created
+		if _, err := store.ConsumePasswordResetToken("expired"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		token, err := store.ConsumePasswordResetToken("valid")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if token.UserID != "lookupID" || !token.Used || token.ExpiresOn.Before(now) {
+			t.Errorf("%s: unexpected token: %v", name, token)
+			return
+		}
+		if _, err := store.ConsumePasswordResetToken("valid"); errors.Cause(err) != db.ErrPasswordResetTokenUsed {
+			t.Errorf("%s: ErrPasswordResetTokenUsed is expected, but %v", name, err)
+			return
+		}
+
+		if err := store.DeleteUserPasswordResetTokens("updateID"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		for _, hash := range []string{"other", "deleted"} {
+			if _, err := store.ConsumePasswordResetToken(hash); errors.Cause(err) != sql.ErrNoRows {
+				t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+				return
+			}
+		}
+		store.Close()
+	}
+}
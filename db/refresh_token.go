@@ -0,0 +1,228 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// Scan raw database row to refresh token
+func (t *RefreshToken) Scan(scanner interface {
+	Scan(...interface{}) error
+}) error {
+	return scanner.Scan(&t.Hash, &t.FamilyID, &t.UserID, &t.ClientID, &t.Scope, &t.Audience, &t.CreatedOn, &t.ExpiresOn, &t.RotatedOn, &t.Revoked)
+}
+
+// Create RefreshToken
+func (t *RefreshToken) Create(tx *sql.Tx) error {
+	log.Printf("db.RefreshToken.Create %s", t.FamilyID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(refreshTokenTable)
+	stmt.WriteString(` (token_hash, family_id, user_id, client_id, scope, audience, created_on, expires_on, revoked) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s, %s, %s, %s, %s", stmt.String(), t.FamilyID, t.UserID, t.ClientID, t.Scope, t.Audience, t.CreatedOn, t.ExpiresOn)
+
+	_, err := tx.Exec(stmt.String(), t.Hash, t.FamilyID, t.UserID, t.ClientID, t.Scope, t.Audience, t.CreatedOn, t.ExpiresOn, t.Revoked)
+	return err
+}
+
+// Load refresh token by token hash
+func (t *RefreshToken) Load(tx *sql.Tx, hash string) error {
+	log.Printf("db.RefreshToken.Load")
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(refreshTokenSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(refreshTokenTable)
+	stmt.WriteString(` WHERE token_hash = ?`)
+
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	row := tx.QueryRow(stmt.String(), hash)
+
+	if err := t.Scan(row); err != nil {
+		return errors.Wrap(err, "scanning row")
+	}
+	return nil
+}
+
+// Rotate marks refresh token as rotated.
+// It fails with ErrRefreshTokenRotated if the token has been rotated or revoked.
+func (t *RefreshToken) Rotate(tx *sql.Tx, now time.Time) error {
+	log.Printf("db.RefreshToken.Rotate %s", t.FamilyID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(refreshTokenTable)
+	stmt.WriteString(` SET rotated_on = ? WHERE token_hash = ? AND rotated_on IS NULL AND revoked = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), now)
+
+	res, err := tx.Exec(stmt.String(), now, t.Hash, false)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, `counting affected rows`)
+	}
+	if n != 1 {
+		return ErrRefreshTokenRotated
+	}
+	return nil
+}
+
+// RevokeFamily revokes all refresh tokens in the family
+func (t *RefreshToken) RevokeFamily(tx *sql.Tx) error {
+	log.Printf("db.RefreshToken.RevokeFamily %s", t.FamilyID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(refreshTokenTable)
+	stmt.WriteString(` SET revoked = ? WHERE family_id = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), t.FamilyID)
+
+	_, err := tx.Exec(stmt.String(), true, t.FamilyID)
+	return err
+}
+
+// RevokeUser revokes all refresh tokens of the user
+func (t *RefreshToken) RevokeUser(tx *sql.Tx) error {
+	log.Printf("db.RefreshToken.RevokeUser %s", t.UserID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(refreshTokenTable)
+	stmt.WriteString(` SET revoked = ? WHERE user_id = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), t.UserID)
+
+	_, err := tx.Exec(stmt.String(), true, t.UserID)
+	return err
+}
+
+// CreateRefreshToken inserts a refresh token
+func (s *SQLStore) CreateRefreshToken(t *RefreshToken) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		return t.Create(tx)
+	})
+}
+
+// LookupRefreshToken loads a refresh token by token hash
+func (s *SQLStore) LookupRefreshToken(hash string) (*RefreshToken, error) {
+	var t RefreshToken
+	err := s.withTx(func(tx *sql.Tx) error {
+		return t.Load(tx, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RotateRefreshToken marks the token as rotated and inserts next one
+func (s *SQLStore) RotateRefreshToken(hash string, next *RefreshToken) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		t := RefreshToken{Hash: hash}
+		if err := t.Rotate(tx, next.CreatedOn); err != nil {
+			return err
+		}
+		return next.Create(tx)
+	})
+}
+
+// RevokeRefreshTokenFamily revokes all refresh tokens in the family
+func (s *SQLStore) RevokeRefreshTokenFamily(familyID string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		t := RefreshToken{FamilyID: familyID}
+		return t.RevokeFamily(tx)
+	})
+}
+
+// RevokeUserRefreshTokens revokes all refresh tokens of the user
+func (s *SQLStore) RevokeUserRefreshTokens(userID string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		t := RefreshToken{UserID: userID}
+		return t.RevokeUser(tx)
+	})
+}
+
+// CreateRefreshToken inserts a refresh token
+func (s *MemoryStore) CreateRefreshToken(t *RefreshToken) error {
+	log.Printf("db.MemoryStore.CreateRefreshToken %s", t.FamilyID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.refreshTokens[t.Hash]; ok {
+		return errors.New(`refresh token already exists`)
+	}
+	s.refreshTokens[t.Hash] = *t
+	return nil
+}
+
+// LookupRefreshToken loads a refresh token by token hash
+func (s *MemoryStore) LookupRefreshToken(hash string) (*RefreshToken, error) {
+	log.Printf("db.MemoryStore.LookupRefreshToken")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.refreshTokens[hash]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up refresh token`)
+	}
+	return &t, nil
+}
+
+// RotateRefreshToken marks the token as rotated and inserts next one
+func (s *MemoryStore) RotateRefreshToken(hash string, next *RefreshToken) error {
+	log.Printf("db.MemoryStore.RotateRefreshToken %s", next.FamilyID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.refreshTokens[hash]
+	if !ok || t.RotatedOn.Valid || t.Revoked {
+		return ErrRefreshTokenRotated
+	}
+	t.RotatedOn = mysql.NullTime{Time: next.CreatedOn, Valid: true}
+	s.refreshTokens[hash] = t
+	s.refreshTokens[next.Hash] = *next
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes all refresh tokens in the family
+func (s *MemoryStore) RevokeRefreshTokenFamily(familyID string) error {
+	log.Printf("db.MemoryStore.RevokeRefreshTokenFamily %s", familyID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, t := range s.refreshTokens {
+		if t.FamilyID == familyID {
+			t.Revoked = true
+			s.refreshTokens[hash] = t
+		}
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes all refresh tokens of the user
+func (s *MemoryStore) RevokeUserRefreshTokens(userID string) error {
+	log.Printf("db.MemoryStore.RevokeUserRefreshTokens %s", userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, t := range s.refreshTokens {
+		if t.UserID == userID {
+			t.Revoked = true
+			s.refreshTokens[hash] = t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/pkg/errors"
+)
+
+func TestRefreshTokenStore(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	for name, store := range testStores(t) {
+		first := db.RefreshToken{Hash: "first", FamilyID: "family", UserID: "lookupID", CreatedOn: now, ExpiresOn: now.Add(time.Hour)}
+		if err := store.CreateRefreshToken(&first); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		second := db.RefreshToken{Hash: "second", FamilyID: "family", UserID: "lookupID", CreatedOn: now, ExpiresOn: now.Add(time.Hour)}
+		if err := store.RotateRefreshToken("first", &second); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		third := db.RefreshToken{Hash: "third", FamilyID: "family", UserID: "lookupID", CreatedOn: now, ExpiresOn: now.Add(time.Hour)}
+		if err := store.RotateRefreshToken("first", &third); errors.Cause(err) != db.ErrRefreshTokenRotated {
+			t.Errorf("%s: ErrRefreshTokenRotated is expected, but %v", name, err)
+			return
+		}
+		rt, err := store.LookupRefreshToken("first")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if !rt.RotatedOn.Valid || rt.UserID != "lookupID" || !rt.ExpiresOn.Equal(now.Add(time.Hour)) {
+			t.Errorf("%s: unexpected refresh token: %v", name, rt)
+			return
+		}
+		if err := store.RevokeRefreshTokenFamily("family"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		rt, err = store.LookupRefreshToken("second")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if !rt.Revoked || rt.RotatedOn.Valid {
+			t.Errorf("%s: second token should be revoked but not rotated: %v", name, rt)
+			return
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Create RevokedToken
+func (t *RevokedToken) Create(tx *sql.Tx) error {
+	log.Printf("db.RevokedToken.Create %s", t.JTI)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`REPLACE INTO `)
+	stmt.WriteString(revokedTokenTable)
+	stmt.WriteString(` (jti, expires_on) VALUES (?, ?)`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), t.JTI, t.ExpiresOn)
+
+	_, err := tx.Exec(stmt.String(), t.JTI, t.ExpiresOn)
+	return err
+}
+
+// RevokeToken adds an access token into the revocation list
+func (s *SQLStore) RevokeToken(t *RevokedToken) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		return t.Create(tx)
+	})
+}
+
+// IsTokenRevoked reports whether the access token is in the revocation list
+func (s *SQLStore) IsTokenRevoked(jti string) (bool, error) {
+	log.Printf("db.SQLStore.IsTokenRevoked %s", jti)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT COUNT(*) FROM `)
+	stmt.WriteString(revokedTokenTable)
+	stmt.WriteString(` WHERE jti = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), jti)
+
+	var n int
+	if err := s.db.QueryRow(stmt.String(), jti).Scan(&n); err != nil {
+		return false, errors.Wrap(err, `scanning row`)
+	}
+	return n > 0, nil
+}
+
+// PurgeRevokedTokens removes revoked tokens which have expired
+func (s *SQLStore) PurgeRevokedTokens(now time.Time) error {
+	log.Printf("db.SQLStore.PurgeRevokedTokens")
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(revokedTokenTable)
+	stmt.WriteString(` WHERE expires_on < ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), now)
+
+	_, err := s.db.Exec(stmt.String(), now)
+	return err
+}
+
+// RevokeUserTokens revokes all tokens of the user issued before given time
+func (s *SQLStore) RevokeUserTokens(userID string, before time.Time) error {
+	log.Printf("db.SQLStore.RevokeUserTokens %s", userID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`REPLACE INTO `)
+	stmt.WriteString(userTokenRevocationTable)
+	stmt.WriteString(` (user_id, revoked_on) VALUES (?, ?)`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), userID, before)
+
+	_, err := s.db.Exec(stmt.String(), userID, before)
+	return err
+}
+
+// LookupUserTokensRevokedOn returns when tokens of the user were revoked
+func (s *SQLStore) LookupUserTokensRevokedOn(userID string) (time.Time, error) {
+	log.Printf("db.SQLStore.LookupUserTokensRevokedOn %s", userID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT revoked_on FROM `)
+	stmt.WriteString(userTokenRevocationTable)
+	stmt.WriteString(` WHERE user_id = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), userID)
+
+	var revokedOn time.Time
+	if err := s.db.QueryRow(stmt.String(), userID).Scan(&revokedOn); err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.Wrap(err, `scanning row`)
+	}
+	return revokedOn, nil
+}
+
+// RevokeToken adds an access token into the revocation list
+func (s *MemoryStore) RevokeToken(t *RevokedToken) error {
+	log.Printf("db.MemoryStore.RevokeToken %s", t.JTI)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokedTokens[t.JTI] = *t
+	return nil
+}
+
+// IsTokenRevoked reports whether the access token is in the revocation list
+func (s *MemoryStore) IsTokenRevoked(jti string) (bool, error) {
+	log.Printf("db.MemoryStore.IsTokenRevoked %s", jti)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revokedTokens[jti]
+	return ok, nil
+}
+
+// PurgeRevokedTokens removes revoked tokens which have expired
+func (s *MemoryStore) PurgeRevokedTokens(now time.Time) error {
+	log.Printf("db.MemoryStore.PurgeRevokedTokens")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for jti, t := range s.revokedTokens {
+		if t.ExpiresOn.Before(now) {
+			delete(s.revokedTokens, jti)
+		}
+	}
+	return nil
+}
+
+// RevokeUserTokens revokes all tokens of the user issued before given time
+func (s *MemoryStore) RevokeUserTokens(userID string, before time.Time) error {
+	log.Printf("db.MemoryStore.RevokeUserTokens %s", userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.userRevokedOn[userID] = before
+	return nil
+}
+
+// LookupUserTokensRevokedOn returns when tokens of the user were revoked
+func (s *MemoryStore) LookupUserTokensRevokedOn(userID string) (time.Time, error) {
+	log.Printf("db.MemoryStore.LookupUserTokensRevokedOn %s", userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userRevokedOn[userID], nil
+}
@@ -0,0 +1,61 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+)
+
+func TestRevocationStore(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	for name, store := range testStores(t) {
+		if err := store.RevokeToken(&db.RevokedToken{JTI: "expired", ExpiresOn: now.Add(-time.Hour)}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.RevokeToken(&db.RevokedToken{JTI: "active", ExpiresOn: now.Add(time.Hour)}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.PurgeRevokedTokens(now); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		for jti, expected := range map[string]bool{"expired": false, "active": true, "unknown": false} {
+			revoked, err := store.IsTokenRevoked(jti)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+			if revoked != expected {
+				t.Errorf("%s: %s revoked = %t, %t is expected", name, jti, revoked, expected)
+				return
+			}
+		}
+
+		revokedOn, err := store.LookupUserTokensRevokedOn("lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if !revokedOn.IsZero() {
+			t.Errorf("%s: zero time is expected, but %s", name, revokedOn)
+			return
+		}
+		if err := store.RevokeUserTokens("lookupID", now); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		revokedOn, err = store.LookupUserTokensRevokedOn("lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if !revokedOn.Equal(now) {
+			t.Errorf("%s: %s != %s", name, revokedOn, now)
+			return
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,517 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scan raw database row to role. Permissions are loaded separately.
+func (r *Role) Scan(scanner interface {
+	Scan(...interface{}) error
+}) error {
+	return scanner.Scan(&r.Name, &r.Description, &r.CreatedOn)
+}
+
+// Create Role with its permissions
+func (r *Role) Create(tx *sql.Tx) error {
+	log.Printf("db.Role.Create %s", r.Name)
+
+	if r.CreatedOn.IsZero() {
+		r.CreatedOn = time.Now()
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(roleTable)
+	stmt.WriteString(` (name, description, created_on) VALUES (?, ?, ?)`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s", stmt.String(), r.Name, r.Description, r.CreatedOn)
+
+	if _, err := tx.Exec(stmt.String(), r.Name, r.Description, r.CreatedOn); err != nil {
+		return err
+	}
+	for _, permission := range r.Permissions {
+		if err := r.GrantPermission(tx, permission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load role and its permissions by role name
+func (r *Role) Load(tx *sql.Tx, name string) error {
+	log.Printf("db.Role.Load %s", name)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(roleSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(roleTable)
+	stmt.WriteString(` WHERE name = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), name)
+
+	row := tx.QueryRow(stmt.String(), name)
+
+	if err := r.Scan(row); err != nil {
+		return errors.Wrap(err, "scanning row")
+	}
+	return r.loadPermissions(tx)
+}
+
+// loadPermissions loads permissions granted to the role
+func (r *Role) loadPermissions(tx *sql.Tx) error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT permission FROM `)
+	stmt.WriteString(rolePermissionTable)
+	stmt.WriteString(` WHERE role = ? ORDER BY permission`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), r.Name)
+
+	rows, err := tx.Query(stmt.String(), r.Name)
+	if err != nil {
+		return errors.Wrap(err, `querying stmt`)
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return errors.Wrap(err, `scanning row`)
+		}
+		permissions = append(permissions, permission)
+	}
+	r.Permissions = permissions
+	return rows.Err()
+}
+
+// Delete role, its permissions and assignments
+func (r *Role) Delete(tx *sql.Tx) error {
+	if r.Name == "" {
+		return errors.New(`role name is not valid`)
+	}
+	log.Printf("db.Role.Delete %s", r.Name)
+
+	for _, table := range []string{rolePermissionTable, userRoleTable} {
+		stmt := bytes.Buffer{}
+		stmt.WriteString(`DELETE FROM `)
+		stmt.WriteString(table)
+		stmt.WriteString(` WHERE role = ?`)
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), r.Name)
+
+		if _, err := tx.Exec(stmt.String(), r.Name); err != nil {
+			return err
+		}
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(roleTable)
+	stmt.WriteString(` WHERE name = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), r.Name)
+
+	_, err := tx.Exec(stmt.String(), r.Name)
+	return err
+}
+
+// GrantPermission grants a permission to the role
+func (r *Role) GrantPermission(tx *sql.Tx, permission string) error {
+	log.Printf("db.Role.GrantPermission %s %s", r.Name, permission)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`REPLACE INTO `)
+	stmt.WriteString(rolePermissionTable)
+	stmt.WriteString(` (role, permission) VALUES (?, ?)`)
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), r.Name, permission)
+
+	_, err := tx.Exec(stmt.String(), r.Name, permission)
+	return err
+}
+
+// RevokePermission revokes a permission from the role
+func (r *Role) RevokePermission(tx *sql.Tx, permission string) error {
+	log.Printf("db.Role.RevokePermission %s %s", r.Name, permission)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(rolePermissionTable)
+	stmt.WriteString(` WHERE role = ? AND permission = ?`)
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), r.Name, permission)
+
+	_, err := tx.Exec(stmt.String(), r.Name, permission)
+	return err
+}
+
+// Assign the role to an user
+func (r *Role) Assign(tx *sql.Tx, userID string) error {
+	log.Printf("db.Role.Assign %s %s", r.Name, userID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`REPLACE INTO `)
+	stmt.WriteString(userRoleTable)
+	stmt.WriteString(` (user_id, role) VALUES (?, ?)`)
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), userID, r.Name)
+
+	_, err := tx.Exec(stmt.String(), userID, r.Name)
+	return err
+}
+
+// Unassign the role from an user
+func (r *Role) Unassign(tx *sql.Tx, userID string) error {
+	log.Printf("db.Role.Unassign %s %s", r.Name, userID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(userRoleTable)
+	stmt.WriteString(` WHERE user_id = ? AND role = ?`)
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), userID, r.Name)
+
+	_, err := tx.Exec(stmt.String(), userID, r.Name)
+	return err
+}
+
+// unassignRoles removes role assignments matching `column = value`
+func unassignRoles(tx *sql.Tx, column, value string) error {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`DELETE FROM `)
+	stmt.WriteString(userRoleTable)
+	stmt.WriteString(` WHERE `)
+	stmt.WriteString(column)
+	stmt.WriteString(` = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), value)
+
+	_, err := tx.Exec(stmt.String(), value)
+	return err
+}
+
+// Listup Roles
+func (l *RoleList) Listup(tx *sql.Tx) error {
+	log.Printf("db.Role.Listup")
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(roleSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(roleTable)
+	stmt.WriteString(` ORDER BY name`)
+
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	rows, err := tx.Query(stmt.String())
+	if err != nil {
+		return errors.Wrap(err, `querying stmt`)
+	}
+	return l.FromRows(tx, rows)
+}
+
+// LoadUserRoles loads roles assigned to the user
+func (l *RoleList) LoadUserRoles(tx *sql.Tx, userID string) error {
+	log.Printf("db.Role.LoadUserRoles %s", userID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(roleSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(roleTable)
+	stmt.WriteString(` WHERE name IN (SELECT role FROM `)
+	stmt.WriteString(userRoleTable)
+	stmt.WriteString(` WHERE user_id = ?) ORDER BY name`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), userID)
+
+	rows, err := tx.Query(stmt.String(), userID)
+	if err != nil {
+		return errors.Wrap(err, `querying stmt`)
+	}
+	return l.FromRows(tx, rows)
+}
+
+// FromRows scanning rows into role list, and loads permissions of each role
+func (l *RoleList) FromRows(tx *sql.Tx, rows *sql.Rows) error {
+	log.Printf("db.Role.FromRows")
+
+	res := RoleList{}
+	for rows.Next() {
+		role := Role{}
+		if err := role.Scan(rows); err != nil {
+			rows.Close()
+			return errors.Wrap(err, `scanning row`)
+		}
+		res = append(res, role)
+	}
+	// rows must be closed before querying permissions in the same transaction
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, `scanning rows`)
+	}
+	for i := range res {
+		if err := res[i].loadPermissions(tx); err != nil {
+			return err
+		}
+	}
+	*l = res
+	return nil
+}
+
+// CreateRole inserts a role with its permissions
+func (s *SQLStore) CreateRole(r *Role) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		return r.Create(tx)
+	})
+}
+
+// LookupRole loads a role by name
+func (s *SQLStore) LookupRole(name string) (*Role, error) {
+	var r Role
+	err := s.withTx(func(tx *sql.Tx) error {
+		return r.Load(tx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListupRoles returns all roles
+func (s *SQLStore) ListupRoles() (RoleList, error) {
+	var l RoleList
+	err := s.withTx(func(tx *sql.Tx) error {
+		return l.Listup(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// DeleteRole deletes a role by name
+func (s *SQLStore) DeleteRole(name string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		r := Role{Name: name}
+		return r.Delete(tx)
+	})
+}
+
+// GrantPermission grants a permission to the role
+func (s *SQLStore) GrantPermission(role, permission string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var r Role
+		if err := r.Load(tx, role); err != nil {
+			return errors.Wrap(err, `loading role`)
+		}
+		return r.GrantPermission(tx, permission)
+	})
+}
+
+// RevokePermission revokes a permission from the role
+func (s *SQLStore) RevokePermission(role, permission string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		r := Role{Name: role}
+		return r.RevokePermission(tx, permission)
+	})
+}
+
+// AssignRole assigns the role to the user.
+// sql.ErrNoRows is returned when either of them does not exist.
+func (s *SQLStore) AssignRole(userID, role string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var u User
+		if err := u.Load(tx, userID); err != nil {
+			return errors.Wrap(err, `loading user`)
+		}
+		var r Role
+		if err := r.Load(tx, role); err != nil {
+			return errors.Wrap(err, `loading role`)
+		}
+		return r.Assign(tx, userID)
+	})
+}
+
+// UnassignRole unassigns the role from the user
+func (s *SQLStore) UnassignRole(userID, role string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		r := Role{Name: role}
+		return r.Unassign(tx, userID)
+	})
+}
+
+// LookupUserRoles returns roles assigned to the user
+func (s *SQLStore) LookupUserRoles(userID string) (RoleList, error) {
+	var l RoleList
+	err := s.withTx(func(tx *sql.Tx) error {
+		return l.LoadUserRoles(tx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// copyRole returns a role which does not share permissions with r
+func copyRole(r Role) Role {
+	r.Permissions = append([]string{}, r.Permissions...)
+	return r
+}
+
+// CreateRole inserts a role with its permissions
+func (s *MemoryStore) CreateRole(r *Role) error {
+	log.Printf("db.MemoryStore.CreateRole %s", r.Name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.roles[r.Name]; ok {
+		return errors.Errorf(`role %s already exists`, r.Name)
+	}
+	created := copyRole(*r)
+	if created.CreatedOn.IsZero() {
+		created.CreatedOn = time.Now()
+	}
+	sort.Strings(created.Permissions)
+	s.roles[r.Name] = created
+	return nil
+}
+
+// LookupRole loads a role by name
+func (s *MemoryStore) LookupRole(name string) (*Role, error) {
+	log.Printf("db.MemoryStore.LookupRole %s", name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.roles[name]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up role`)
+	}
+	r = copyRole(r)
+	return &r, nil
+}
+
+// ListupRoles returns all roles
+func (s *MemoryStore) ListupRoles() (RoleList, error) {
+	log.Printf("db.MemoryStore.ListupRoles")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l := make(RoleList, 0, len(s.roles))
+	for _, r := range s.roles {
+		l = append(l, copyRole(r))
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l, nil
+}
+
+// DeleteRole deletes a role by name
+func (s *MemoryStore) DeleteRole(name string) error {
+	if name == "" {
+		return errors.New(`role name is not valid`)
+	}
+	log.Printf("db.MemoryStore.DeleteRole %s", name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.roles, name)
+	for userID, roles := range s.userRoles {
+		s.userRoles[userID] = removeString(roles, name)
+	}
+	return nil
+}
+
+// GrantPermission grants a permission to the role
+func (s *MemoryStore) GrantPermission(role, permission string) error {
+	log.Printf("db.MemoryStore.GrantPermission %s %s", role, permission)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.roles[role]
+	if !ok {
+		return errors.Wrap(sql.ErrNoRows, `looking up role`)
+	}
+	r.Permissions = addString(r.Permissions, permission)
+	s.roles[role] = r
+	return nil
+}
+
+// RevokePermission revokes a permission from the role
+func (s *MemoryStore) RevokePermission(role, permission string) error {
+	log.Printf("db.MemoryStore.RevokePermission %s %s", role, permission)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.roles[role]
+	if !ok {
+		return nil
+	}
+	r.Permissions = removeString(r.Permissions, permission)
+	s.roles[role] = r
+	return nil
+}
+
+// AssignRole assigns the role to the user.
+// sql.ErrNoRows is returned when either of them does not exist.
+func (s *MemoryStore) AssignRole(userID, role string) error {
+	log.Printf("db.MemoryStore.AssignRole %s %s", userID, role)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return errors.Wrap(sql.ErrNoRows, `looking up user`)
+	}
+	if _, ok := s.roles[role]; !ok {
+		return errors.Wrap(sql.ErrNoRows, `looking up role`)
+	}
+	s.userRoles[userID] = addString(s.userRoles[userID], role)
+	return nil
+}
+
+// UnassignRole unassigns the role from the user
+func (s *MemoryStore) UnassignRole(userID, role string) error {
+	log.Printf("db.MemoryStore.UnassignRole %s %s", userID, role)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.userRoles[userID] = removeString(s.userRoles[userID], role)
+	return nil
+}
+
+// LookupUserRoles returns roles assigned to the user
+func (s *MemoryStore) LookupUserRoles(userID string) (RoleList, error) {
+	log.Printf("db.MemoryStore.LookupUserRoles %s", userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l := RoleList{}
+	for _, name := range s.userRoles[userID] {
+		if r, ok := s.roles[name]; ok {
+			l = append(l, copyRole(r))
+		}
+	}
+	return l, nil
+}
+
+// addString inserts v into sorted slice l unless it is included already
+func addString(l []string, v string) []string {
+	i := sort.SearchStrings(l, v)
+	if i < len(l) && l[i] == v {
+		return l
+	}
+	l = append(l, "")
+	copy(l[i+1:], l[i:])
+	l[i] = v
+	return l
+}
+
+// removeString removes v from slice l
+func removeString(l []string, v string) []string {
+	res := make([]string, 0, len(l))
+	for _, s := range l {
+		if s != v {
+			res = append(res, s)
+		}
+	}
+	return res
+}
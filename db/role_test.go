@@ -0,0 +1,94 @@
+package db_test
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/pkg/errors"
+)
+
+func TestRoleStore(t *testing.T) {
+	for name, store := range testStores(t) {
+		if err := store.CreateRole(&db.Role{Name: "billing", Description: "billing staff", Permissions: []string{"billing:write", "billing:read"}}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.CreateRole(&db.Role{Name: "audit"}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.GrantPermission("audit", "billing:read"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.GrantPermission("unknown", "billing:read"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		if err := store.RevokePermission("billing", "billing:write"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		r, err := store.LookupRole("billing")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if r.Description != "billing staff" || !reflect.DeepEqual(r.Permissions, []string{"billing:read"}) {
+			t.Errorf("%s: unexpected role: %v", name, r)
+			return
+		}
+
+		if err := store.AssignRole("lookupID", "billing"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.AssignRole("lookupID", "audit"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.AssignRole("unknownID", "audit"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		l, err := store.LookupUserRoles("lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(l) != 2 || l[0].Name != "audit" || l[1].Name != "billing" || len(l[1].Permissions) != 1 {
+			t.Errorf("%s: unexpected roles: %v", name, l)
+			return
+		}
+
+		if err := store.DeleteRole("audit"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.UnassignRole("lookupID", "billing"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		l, err = store.LookupUserRoles("lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(l) != 0 {
+			t.Errorf("%s: no roles are expected, but %v", name, l)
+			return
+		}
+		l, err = store.ListupRoles()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(l) != 1 || l[0].Name != "billing" {
+			t.Errorf("%s: unexpected roles: %v", name, l)
+			return
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"sort"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// Scan raw database row to signing key
+func (k *SigningKey) Scan(scanner interface {
+	Scan(...interface{}) error
+}) error {
+	return scanner.Scan(&k.ID, &k.PrivateKey, &k.PublicKey, &k.CreatedOn, &k.RetiredOn)
+}
+
+// Create SigningKey
+func (k *SigningKey) Create(tx *sql.Tx) error {
+	log.Printf("db.SigningKey.Create %s", k.ID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(signingKeyTable)
+	stmt.WriteString(` (kid, private_key, public_key, created_on, retired_on) VALUES (?, ?, ?, ?, ?)`)
+
+	// the private key is never logged
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), k.ID, k.CreatedOn)
+
+	_, err := tx.Exec(stmt.String(), k.ID, k.PrivateKey, k.PublicKey, k.CreatedOn, k.RetiredOn)
+	return err
+}
+
+// loadSigningKeys returns stored keys, the newest first
+func loadSigningKeys(tx *sql.Tx) ([]SigningKey, error) {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(signingKeySelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(signingKeyTable)
+	log.Printf("SQL QUERY: %s", stmt.String())
+
+	rows, err := tx.Query(stmt.String())
+	if err != nil {
+		return nil, errors.Wrap(err, `querying stmt`)
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var k SigningKey
+		if err := k.Scan(rows); err != nil {
+			return nil, errors.Wrap(err, `scanning row`)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// sorted here, as SQLite orders times stored in different formats as text
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedOn.After(keys[j].CreatedOn)
+	})
+	return keys, nil
+}
+
+// ListupSigningKeys returns all stored keys, the newest first
+func (s *SQLStore) ListupSigningKeys() ([]SigningKey, error) {
+	var keys []SigningKey
+	err := s.withTx(func(tx *sql.Tx) (err error) {
+		keys, err = loadSigningKeys(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateSigningKey retires active keys, storing current one if it is missing,
+// and stores next one as the active key
+func (s *SQLStore) RotateSigningKey(current, next *SigningKey) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		stmt := bytes.Buffer{}
+		stmt.WriteString(`UPDATE `)
+		stmt.WriteString(signingKeyTable)
+		stmt.WriteString(` SET retired_on = ? WHERE retired_on IS NULL`)
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), next.CreatedOn)
+
+		if _, err := tx.Exec(stmt.String(), next.CreatedOn); err != nil {
+			return err
+		}
+
+		stmt.Reset()
+		stmt.WriteString(`SELECT COUNT(*) FROM `)
+		stmt.WriteString(signingKeyTable)
+		stmt.WriteString(` WHERE kid = ?`)
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), current.ID)
+
+		var n int
+		if err := tx.QueryRow(stmt.String(), current.ID).Scan(&n); err != nil {
+			return errors.Wrap(err, `counting keys`)
+		}
+		if n == 0 {
+			retired := *current
+			retired.RetiredOn = mysql.NullTime{Time: next.CreatedOn, Valid: true}
+			if err := retired.Create(tx); err != nil {
+				return err
+			}
+		}
+		return next.Create(tx)
+	})
+}
+
+// ListupSigningKeys returns all stored keys, the newest first
+func (s *MemoryStore) ListupSigningKeys() ([]SigningKey, error) {
+	log.Printf("db.MemoryStore.ListupSigningKeys")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]SigningKey, len(s.signingKeys))
+	copy(keys, s.signingKeys)
+	return keys, nil
+}
+
+// RotateSigningKey retires active keys, storing current one if it is missing,
+// and stores next one as the active key
+func (s *MemoryStore) RotateSigningKey(current, next *SigningKey) error {
+	log.Printf("db.MemoryStore.RotateSigningKey %s", next.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := false
+	for _, k := range s.signingKeys {
+		if k.ID == next.ID {
+			return errors.Errorf(`signing key %s already exists`, next.ID)
+		}
+		if k.ID == current.ID {
+			stored = true
+		}
+	}
+	retiredOn := mysql.NullTime{Time: next.CreatedOn, Valid: true}
+	for i, k := range s.signingKeys {
+		if !k.RetiredOn.Valid {
+			s.signingKeys[i].RetiredOn = retiredOn
+		}
+	}
+	if !stored {
+		retired := *current
+		retired.RetiredOn = retiredOn
+		s.signingKeys = append(s.signingKeys, retired)
+	}
+	s.signingKeys = append(s.signingKeys, *next)
+	sort.SliceStable(s.signingKeys, func(i, j int) bool {
+		return s.signingKeys[i].CreatedOn.After(s.signingKeys[j].CreatedOn)
+	})
+	return nil
+}
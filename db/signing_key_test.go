@@ -0,0 +1,63 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+)
+
+func TestSigningKeyStore(t *testing.T) {
+	exTime := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, store := range testStores(t) {
+		keys, err := store.ListupSigningKeys()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(keys) != 0 {
+			t.Errorf("%s: no keys are expected, but %v", name, keys)
+			return
+		}
+
+		// the key file is stored without its private key at the first rotation
+		file := db.SigningKey{ID: "file", PublicKey: "filepub", CreatedOn: exTime}
+		first := db.SigningKey{ID: "first", PrivateKey: "firstpriv", PublicKey: "firstpub", CreatedOn: exTime.Add(time.Hour)}
+		if err := store.RotateSigningKey(&file, &first); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		second := db.SigningKey{ID: "second", PrivateKey: "secondpriv", PublicKey: "secondpub", CreatedOn: exTime.Add(2 * time.Hour)}
+		if err := store.RotateSigningKey(&first, &second); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.RotateSigningKey(&second, &first); err == nil {
+			t.Errorf("%s: stored key is stored again", name)
+			return
+		}
+
+		keys, err = store.ListupSigningKeys()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(keys) != 3 || keys[0].ID != "second" || keys[1].ID != "first" || keys[2].ID != "file" {
+			t.Errorf("%s: unexpected keys: %v", name, keys)
+			return
+		}
+		if keys[0].RetiredOn.Valid || keys[0].PrivateKey != "secondpriv" || keys[0].PublicKey != "secondpub" {
+			t.Errorf("%s: unexpected active key: %v", name, keys[0])
+			return
+		}
+		if !keys[1].RetiredOn.Valid || !keys[1].RetiredOn.Time.Equal(second.CreatedOn) {
+			t.Errorf("%s: first key is not retired at rotation: %v", name, keys[1])
+			return
+		}
+		if !keys[2].RetiredOn.Valid || !keys[2].RetiredOn.Time.Equal(first.CreatedOn) || keys[2].PrivateKey != "" {
+			t.Errorf("%s: unexpected key file: %v", name, keys[2])
+			return
+		}
+		store.Close()
+	}
+}
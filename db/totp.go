@@ -0,0 +1,295 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scan raw database row to TOTP
+func (t *TOTP) Scan(scanner interface {
+	Scan(...interface{}) error
+}) error {
+	return scanner.Scan(&t.UserID, &t.Secret, &t.Confirmed, &t.LastUsedStep, &t.CreatedOn)
+}
+
+// Create TOTP with recovery codes. The caller deletes existing ones beforehand.
+func (t *TOTP) Create(tx *sql.Tx, recoveryCodeHashes []string) error {
+	log.Printf("db.TOTP.Create %s", t.UserID)
+
+	if t.CreatedOn.IsZero() {
+		t.CreatedOn = time.Now()
+	}
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(totpTable)
+	stmt.WriteString(` (user_id, secret, confirmed, last_used_step, created_on) VALUES (?, ?, ?, ?, ?)`)
+
+	log.Printf("SQL QUERY: %s: with values %s, %t, %d, %s", stmt.String(), t.UserID, t.Confirmed, t.LastUsedStep, t.CreatedOn)
+
+	if _, err := tx.Exec(stmt.String(), t.UserID, t.Secret, t.Confirmed, t.LastUsedStep, t.CreatedOn); err != nil {
+		return err
+	}
+
+	stmt.Reset()
+	stmt.WriteString(`INSERT INTO `)
+	stmt.WriteString(recoveryCodeTable)
+	stmt.WriteString(` (user_id, code_hash) VALUES (?, ?)`)
+	for _, hash := range recoveryCodeHashes {
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), t.UserID)
+		if _, err := tx.Exec(stmt.String(), t.UserID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load TOTP by user ID
+func (t *TOTP) Load(tx *sql.Tx, userID string) error {
+	log.Printf("db.TOTP.Load %s", userID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT `)
+	stmt.WriteString(totpSelectColumns)
+	stmt.WriteString(` FROM `)
+	stmt.WriteString(totpTable)
+	stmt.WriteString(` WHERE user_id = ?`)
+
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), userID)
+
+	row := tx.QueryRow(stmt.String(), userID)
+
+	if err := t.Scan(row); err != nil {
+		return errors.Wrap(err, "scanning row")
+	}
+	return nil
+}
+
+// Confirm TOTP
+func (t *TOTP) Confirm(tx *sql.Tx) error {
+	log.Printf("db.TOTP.Confirm %s", t.UserID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(totpTable)
+	stmt.WriteString(` SET confirmed = ? WHERE user_id = ?`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), t.UserID)
+
+	_, err := tx.Exec(stmt.String(), true, t.UserID)
+	return err
+}
+
+// UseStep records the time step of an accepted code.
+// It fails with ErrTOTPStepUsed unless the step is newer than the last one.
+func (t *TOTP) UseStep(tx *sql.Tx, step int64) error {
+	log.Printf("db.TOTP.UseStep %s", t.UserID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(totpTable)
+	stmt.WriteString(` SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`)
+	log.Printf("SQL QUERY: %s: with values %d, %s", stmt.String(), step, t.UserID)
+
+	res, err := tx.Exec(stmt.String(), step, t.UserID, step)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, `counting affected rows`)
+	}
+	if n != 1 {
+		return ErrTOTPStepUsed
+	}
+	return nil
+}
+
+// Delete TOTP and recovery codes of the user
+func (t *TOTP) Delete(tx *sql.Tx) error {
+	if t.UserID == "" {
+		return errors.New(`user ID is not valid`)
+	}
+	log.Printf("db.TOTP.Delete %s", t.UserID)
+
+	for _, table := range []string{totpTable, recoveryCodeTable} {
+		stmt := bytes.Buffer{}
+		stmt.WriteString(`DELETE FROM `)
+		stmt.WriteString(table)
+		stmt.WriteString(` WHERE user_id = ?`)
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), t.UserID)
+
+		if _, err := tx.Exec(stmt.String(), t.UserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used
+func (t *TOTP) UseRecoveryCode(tx *sql.Tx, hash string) error {
+	log.Printf("db.TOTP.UseRecoveryCode %s", t.UserID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(recoveryCodeTable)
+	stmt.WriteString(` SET used_on = ? WHERE user_id = ? AND code_hash = ? AND used_on IS NULL`)
+	log.Printf("SQL QUERY: %s: with values %s", stmt.String(), t.UserID)
+
+	res, err := tx.Exec(stmt.String(), time.Now(), t.UserID, hash)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, `counting affected rows`)
+	}
+	if n != 1 {
+		return errors.Wrap(sql.ErrNoRows, `looking up recovery code`)
+	}
+	return nil
+}
+
+// CreateTOTP replaces the authenticator and recovery codes of the user
+func (s *SQLStore) CreateTOTP(t *TOTP, recoveryCodeHashes []string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := t.Delete(tx); err != nil {
+			return err
+		}
+		return t.Create(tx, recoveryCodeHashes)
+	})
+}
+
+// LookupTOTP loads the authenticator of the user
+func (s *SQLStore) LookupTOTP(userID string) (*TOTP, error) {
+	var t TOTP
+	err := s.withTx(func(tx *sql.Tx) error {
+		return t.Load(tx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ConfirmTOTP marks the authenticator of the user as confirmed
+func (s *SQLStore) ConfirmTOTP(userID string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		t := TOTP{UserID: userID}
+		return t.Confirm(tx)
+	})
+}
+
+// UseTOTPStep records the time step of an accepted code
+func (s *SQLStore) UseTOTPStep(userID string, step int64) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		t := TOTP{UserID: userID}
+		return t.UseStep(tx, step)
+	})
+}
+
+// DeleteTOTP deletes the authenticator and recovery codes of the user
+func (s *SQLStore) DeleteTOTP(userID string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		t := TOTP{UserID: userID}
+		return t.Delete(tx)
+	})
+}
+
+// UseRecoveryCode consumes a recovery code of the user
+func (s *SQLStore) UseRecoveryCode(userID, hash string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		t := TOTP{UserID: userID}
+		return t.UseRecoveryCode(tx, hash)
+	})
+}
+
+// CreateTOTP replaces the authenticator and recovery codes of the user
+func (s *MemoryStore) CreateTOTP(t *TOTP, recoveryCodeHashes []string) error {
+	log.Printf("db.MemoryStore.CreateTOTP %s", t.UserID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := *t
+	if created.CreatedOn.IsZero() {
+		created.CreatedOn = time.Now()
+	}
+	s.totps[t.UserID] = created
+	codes := map[string]bool{}
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = false
+	}
+	s.recoveryCodes[t.UserID] = codes
+	return nil
+}
+
+// LookupTOTP loads the authenticator of the user
+func (s *MemoryStore) LookupTOTP(userID string) (*TOTP, error) {
+	log.Printf("db.MemoryStore.LookupTOTP %s", userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.totps[userID]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up TOTP`)
+	}
+	return &t, nil
+}
+
+// ConfirmTOTP marks the authenticator of the user as confirmed
+func (s *MemoryStore) ConfirmTOTP(userID string) error {
+	log.Printf("db.MemoryStore.ConfirmTOTP %s", userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totps[userID]
+	if !ok {
+		return nil
+	}
+	t.Confirmed = true
+	s.totps[userID] = t
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code
+func (s *MemoryStore) UseTOTPStep(userID string, step int64) error {
+	log.Printf("db.MemoryStore.UseTOTPStep %s", userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totps[userID]
+	if !ok || t.LastUsedStep >= step {
+		return ErrTOTPStepUsed
+	}
+	t.LastUsedStep = step
+	s.totps[userID] = t
+	return nil
+}
+
+// DeleteTOTP deletes the authenticator and recovery codes of the user
+func (s *MemoryStore) DeleteTOTP(userID string) error {
+	log.Printf("db.MemoryStore.DeleteTOTP %s", userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totps, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+// UseRecoveryCode consumes a recovery code of the user
+func (s *MemoryStore) UseRecoveryCode(userID, hash string) error {
+	log.Printf("db.MemoryStore.UseRecoveryCode %s", userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.recoveryCodes[userID][hash]
+	if !ok || used {
+		return errors.Wrap(sql.ErrNoRows, `looking up recovery code`)
+	}
+	s.recoveryCodes[userID][hash] = true
+	return nil
+}
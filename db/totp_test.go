@@ -0,0 +1,65 @@
+package db_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/pkg/errors"
+)
+
+func TestMFAStore(t *testing.T) {
+	for name, store := range testStores(t) {
+		if err := store.CreateTOTP(&db.TOTP{UserID: "lookupID", Secret: "first"}, []string{"a", "b"}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		// enrolling again replaces the pending one
+		if err := store.CreateTOTP(&db.TOTP{UserID: "lookupID", Secret: "second"}, []string{"c"}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.ConfirmTOTP("lookupID"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		totp, err := store.LookupTOTP("lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if totp.Secret != "second" || !totp.Confirmed {
+			t.Errorf("%s: unexpected TOTP: %v", name, totp)
+			return
+		}
+		if err := store.UseTOTPStep("lookupID", 100); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.UseTOTPStep("lookupID", 100); errors.Cause(err) != db.ErrTOTPStepUsed {
+			t.Errorf("%s: ErrTOTPStepUsed is expected, but %v", name, err)
+			return
+		}
+		if err := store.UseRecoveryCode("lookupID", "a"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		if err := store.UseRecoveryCode("lookupID", "c"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.UseRecoveryCode("lookupID", "c"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		if err := store.DeleteTOTP("lookupID"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if _, err := store.LookupTOTP("lookupID"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		store.Close()
+	}
+}
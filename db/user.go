@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"database/sql"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/charakoba-com/auth-api/utils"
+	"github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 )
 
@@ -14,7 +16,41 @@ import (
 func (u *User) Scan(scanner interface {
 	Scan(...interface{}) error
 }) error {
-	return scanner.Scan(&u.ID, &u.Name, &u.Password, &u.CreatedOn, &u.ModifiedOn)
+	var email sql.NullString
+	if err := scanner.Scan(&u.ID, &u.Name, &u.Password, &u.IsAdmin, &email, &u.EmailVerified, &u.CreatedOn, &u.ModifiedOn); err != nil {
+		return err
+	}
+	u.Email = email.String
+	return nil
+}
+
+// emailValue stores an empty email address as NULL, which is not subject to uniqueness
+func emailValue(email string) interface{} {
+	if email == "" {
+		return nil
+	}
+	return email
+}
+
+// checkEmailAvailable fails with ErrDuplicateEmail if another user has the email address
+func checkEmailAvailable(tx *sql.Tx, id, email string) error {
+	if email == "" {
+		return nil
+	}
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT COUNT(*) FROM `)
+	stmt.WriteString(userTable)
+	stmt.WriteString(` WHERE email = ? AND id <> ?`)
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), email, id)
+
+	var n int
+	if err := tx.QueryRow(stmt.String(), email, id).Scan(&n); err != nil {
+		return errors.Wrap(err, `counting users with email address`)
+	}
+	if n > 0 {
+		return ErrDuplicateEmail
+	}
+	return nil
 }
 
 // Create User
@@ -26,14 +62,11 @@ func (u *User) Create(tx *sql.Tx) error {
 	stmt := bytes.Buffer{}
 	stmt.WriteString(`INSERT INTO `)
 	stmt.WriteString(userTable)
-	stmt.WriteString(` (id, username, password, created_on) VALUES (?, ?, ?, ?)`)
-
-	log.Printf("SQL QUERY: %s: with values %s, %s, %s, %s", stmt.String(), u.ID, u.Name, u.Password, now)
+	stmt.WriteString(` (id, username, password, is_admin, email, email_verified, created_on) VALUES (?, ?, ?, ?, ?, ?, ?)`)
 
-	// hash user's password
-	hashed := utils.HashPassword(u.Password, u.ID + u.Name)
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s, %t, %s, %t, %s", stmt.String(), u.ID, u.Name, u.Password, u.IsAdmin, u.Email, u.EmailVerified, now)
 
-	_, err := tx.Exec(stmt.String(), u.ID, u.Name, hashed, now)
+	_, err := tx.Exec(stmt.String(), u.ID, u.Name, u.Password, u.IsAdmin, emailValue(u.Email), u.EmailVerified, now)
 	return err
 }
 
@@ -68,17 +101,41 @@ func (u *User) Update(tx *sql.Tx) error {
 	stmt := bytes.Buffer{}
 	stmt.WriteString(`UPDATE `)
 	stmt.WriteString(userTable)
-	stmt.WriteString(` SET username = ?, password = ? WHERE id = ?`)
-	log.Printf("SQL QUERY: %s: with values %s, %s, %s", stmt.String(), u.Name, u.Password, u.ID)
+	stmt.WriteString(` SET username = ?, password = ?, email = ?, email_verified = ?, modified_on = ? WHERE id = ?`)
+	log.Printf("SQL QUERY: %s: with values %s, %s, %s, %t, %s", stmt.String(), u.Name, u.Password, u.Email, u.EmailVerified, u.ID)
 
-	// hash user's password
-	hashed := utils.HashPassword(u.Password, u.ID + u.Name)
-
-	_, err := tx.Exec(stmt.String(), u.Name, hashed, u.ID)
+	_, err := tx.Exec(stmt.String(), u.Name, u.Password, emailValue(u.Email), u.EmailVerified, time.Now(), u.ID)
 
 	return err
 }
 
+// VerifyEmail marks the email address of the user as verified.
+// It fails with sql.ErrNoRows unless the user still has the address.
+func (u *User) VerifyEmail(tx *sql.Tx, email string) error {
+	log.Printf("db.User.VerifyEmail %s", u.ID)
+
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`UPDATE `)
+	stmt.WriteString(userTable)
+	stmt.WriteString(` SET email_verified = ? WHERE id = ? AND email = ?`)
+	log.Printf("SQL QUERY: %s: with values %s, %s", stmt.String(), u.ID, email)
+
+	res, err := tx.Exec(stmt.String(), true, u.ID, email)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, `counting affected rows`)
+	}
+	if n != 1 {
+		return errors.Wrap(sql.ErrNoRows, `looking up user with email address`)
+	}
+	u.Email = email
+	u.EmailVerified = true
+	return nil
+}
+
 // Delete user from DB by user ID
 func (u *User) Delete(tx *sql.Tx) error {
 	if u.ID == "" {
@@ -97,8 +154,9 @@ func (u *User) Delete(tx *sql.Tx) error {
 	return err
 }
 
-// Listup Users
-func (l *UserList) Listup(tx *sql.Tx) error {
+// Listup Users matching the query, with one more user than the limit to
+// tell whether another page follows
+func (l *UserList) Listup(tx *sql.Tx, driver string, q *UserQuery) error {
 	log.Printf("db.User.Listup")
 
 	stmt := bytes.Buffer{}
@@ -106,19 +164,131 @@ func (l *UserList) Listup(tx *sql.Tx) error {
 	stmt.WriteString(userSelectColumns)
 	stmt.WriteString(` FROM `)
 	stmt.WriteString(userTable)
+	where, values := userQueryWhere(driver, q, true)
+	stmt.WriteString(where)
 
-	log.Printf("SQL QUERY: %s", stmt.String())
+	column, order := `id`, ` ASC`
+	if q.SortBy == UserSortName || q.SortBy == UserSortCreatedOn {
+		column = comparableColumn(driver, q.SortBy)
+	}
+	if q.Descending {
+		order = ` DESC`
+	}
+	stmt.WriteString(` ORDER BY `)
+	if column != `id` {
+		stmt.WriteString(column)
+		stmt.WriteString(order)
+		stmt.WriteString(`, `)
+	}
+	stmt.WriteString(`id`)
+	stmt.WriteString(order)
+	if q.Limit > 0 {
+		stmt.WriteString(` LIMIT ?`)
+		values = append(values, q.Limit+1)
+	}
+
+	log.Printf("SQL QUERY: %s: with values %v", stmt.String(), values)
 
-	rows, err := tx.Query(stmt.String())
+	rows, err := tx.Query(stmt.String(), values...)
 	if err != nil {
 		return errors.Wrap(err, `querying stmt`)
 	}
+	defer rows.Close()
 	if err := l.FromRows(rows); err != nil {
 		return errors.Wrap(err, "scanning rows")
 	}
 	return nil
 }
 
+// countUsers counts users matching the filters of the query
+func countUsers(tx *sql.Tx, driver string, q *UserQuery) (int, error) {
+	stmt := bytes.Buffer{}
+	stmt.WriteString(`SELECT COUNT(*) FROM `)
+	stmt.WriteString(userTable)
+	where, values := userQueryWhere(driver, q, false)
+	stmt.WriteString(where)
+	log.Printf("SQL QUERY: %s: with values %v", stmt.String(), values)
+
+	var total int
+	if err := tx.QueryRow(stmt.String(), values...).Scan(&total); err != nil {
+		return 0, errors.Wrap(err, `scanning row`)
+	}
+	return total, nil
+}
+
+// userQueryWhere returns the WHERE clause of the filters, and the cursor if withCursor
+func userQueryWhere(driver string, q *UserQuery, withCursor bool) (string, []interface{}) {
+	var conds []string
+	var values []interface{}
+	if q.NamePrefix != "" {
+		// LIKE is case-insensitive with the MySQL collation and in SQLite
+		conds = append(conds, `username LIKE ? ESCAPE '!'`)
+		values = append(values, likeEscaper.Replace(q.NamePrefix)+`%`)
+	}
+	if q.IsAdmin != nil {
+		conds = append(conds, `is_admin = ?`)
+		values = append(values, *q.IsAdmin)
+	}
+	createdOn := comparableColumn(driver, UserSortCreatedOn)
+	if !q.CreatedAfter.IsZero() {
+		conds = append(conds, createdOn+` >= `+comparableValue(driver, UserSortCreatedOn))
+		values = append(values, q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		conds = append(conds, createdOn+` < `+comparableValue(driver, UserSortCreatedOn))
+		values = append(values, q.CreatedBefore)
+	}
+	if withCursor && q.After != nil {
+		op := ` > `
+		if q.Descending {
+			op = ` < `
+		}
+		switch q.SortBy {
+		case UserSortName, UserSortCreatedOn:
+			column, value := comparableColumn(driver, q.SortBy), comparableValue(driver, q.SortBy)
+			var after interface{} = q.After.Name
+			if q.SortBy == UserSortCreatedOn {
+				after = q.After.CreatedOn
+			}
+			conds = append(conds, `(`+column+op+value+` OR (`+column+` = `+value+` AND id`+op+`?))`)
+			values = append(values, after, after, q.After.ID)
+		default:
+			conds = append(conds, `id`+op+`?`)
+			values = append(values, q.After.ID)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return ` WHERE ` + strings.Join(conds, ` AND `), values
+}
+
+// likeEscaper escapes wildcards of LIKE patterns with '!'
+var likeEscaper = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+
+// comparableColumn returns the expression of the column compared in queries
+func comparableColumn(driver, column string) string {
+	if driver == DriverSQLite && column == UserSortCreatedOn {
+		return sqliteTime(column)
+	}
+	return column
+}
+
+// comparableValue returns the placeholder compared with comparableColumn
+func comparableValue(driver, column string) string {
+	if driver == DriverSQLite && column == UserSortCreatedOn {
+		return sqliteTime(`?`)
+	}
+	return `?`
+}
+
+// sqliteTime normalizes times, which go-sqlite3 and SQL literals store as
+// differently formatted text, so that they are compared in UTC.
+// The users_created_on index of SQLite is on this expression of created_on.
+func sqliteTime(expr string) string {
+	return `strftime('%Y-%m-%d %H:%M:%f', ` + expr + `)`
+}
+
 // FromRows scanning rows into user list
 func (l *UserList) FromRows(rows *sql.Rows) error {
 	log.Printf("db.User.FromRows")
@@ -135,3 +305,301 @@ func (l *UserList) FromRows(rows *sql.Rows) error {
 	*l = res
 	return nil
 }
+
+// CreateUser inserts an user
+func (s *SQLStore) CreateUser(u *User) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := checkEmailAvailable(tx, u.ID, u.Email); err != nil {
+			return err
+		}
+		return u.Create(tx)
+	})
+}
+
+// LookupUser loads an user by user ID
+func (s *SQLStore) LookupUser(id string) (*User, error) {
+	var u User
+	err := s.withTx(func(tx *sql.Tx) error {
+		return u.Load(tx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UpdateUser updates an user
+func (s *SQLStore) UpdateUser(u *User) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := checkEmailAvailable(tx, u.ID, u.Email); err != nil {
+			return err
+		}
+		return u.Update(tx)
+	})
+}
+
+// LookupUserByEmail loads an user by email address
+func (s *SQLStore) LookupUserByEmail(email string) (*User, error) {
+	var u User
+	err := s.withTx(func(tx *sql.Tx) error {
+		stmt := bytes.Buffer{}
+		stmt.WriteString(`SELECT `)
+		stmt.WriteString(userSelectColumns)
+		stmt.WriteString(` FROM `)
+		stmt.WriteString(userTable)
+		stmt.WriteString(` WHERE email = ?`)
+		log.Printf("SQL QUERY: %s: with values %s", stmt.String(), email)
+
+		if err := u.Scan(tx.QueryRow(stmt.String(), email)); err != nil {
+			return errors.Wrap(err, "scanning row")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// VerifyUserEmail marks the email address of the user as verified
+func (s *SQLStore) VerifyUserEmail(id, email string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		u := User{ID: id}
+		return u.VerifyEmail(tx, email)
+	})
+}
+
+// DeleteUser deletes an user, the role assignments and second factors by user ID
+func (s *SQLStore) DeleteUser(id string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		u := User{ID: id}
+		if err := u.Delete(tx); err != nil {
+			return err
+		}
+		if err := unassignRoles(tx, `user_id`, id); err != nil {
+			return err
+		}
+		if err := deletePasswordResetTokens(tx, id); err != nil {
+			return err
+		}
+		if err := deletePasswordHistory(tx, id); err != nil {
+			return err
+		}
+		a := LoginAttempt{Key: UserAttemptKey(id)}
+		if err := a.Delete(tx); err != nil {
+			return err
+		}
+		t := TOTP{UserID: id}
+		return t.Delete(tx)
+	})
+}
+
+// ListupUsers returns users matching the query, all users if nil
+func (s *SQLStore) ListupUsers(q *UserQuery) (*UserPage, error) {
+	if q == nil {
+		q = &UserQuery{}
+	}
+	var page UserPage
+	err := s.withTx(func(tx *sql.Tx) (err error) {
+		if err := page.Users.Listup(tx, s.driver, q); err != nil {
+			return err
+		}
+		page.Total, err = countUsers(tx, s.driver, q)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+		page.More = true
+	}
+	return &page, nil
+}
+
+// CreateUser inserts an user
+func (s *MemoryStore) CreateUser(u *User) error {
+	log.Printf("db.MemoryStore.CreateUser %s", u.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[u.ID]; ok {
+		return errors.Errorf(`user %s already exists`, u.ID)
+	}
+	if err := s.checkEmailAvailable(u.ID, u.Email); err != nil {
+		return err
+	}
+	created := *u
+	if created.CreatedOn.IsZero() {
+		created.CreatedOn = time.Now()
+	}
+	s.users[u.ID] = created
+	return nil
+}
+
+// LookupUser loads an user by user ID
+func (s *MemoryStore) LookupUser(id string) (*User, error) {
+	log.Printf("db.MemoryStore.LookupUser %s", id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, errors.Wrap(sql.ErrNoRows, `looking up user`)
+	}
+	return &u, nil
+}
+
+// UpdateUser updates an user
+func (s *MemoryStore) UpdateUser(u *User) error {
+	if u.ID == "" {
+		return errors.New(`user ID is not valid`)
+	}
+	log.Printf("db.MemoryStore.UpdateUser %s", u.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[u.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkEmailAvailable(u.ID, u.Email); err != nil {
+		return err
+	}
+	stored.Name = u.Name
+	stored.Password = u.Password
+	stored.Email = u.Email
+	stored.EmailVerified = u.EmailVerified
+	stored.ModifiedOn = mysql.NullTime{Time: time.Now(), Valid: true}
+	s.users[u.ID] = stored
+	return nil
+}
+
+// DeleteUser deletes an user by user ID
+func (s *MemoryStore) DeleteUser(id string) error {
+	if id == "" {
+		return errors.New(`user ID is not valid`)
+	}
+	log.Printf("db.MemoryStore.DeleteUser %s", id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, id)
+	delete(s.userRoles, id)
+	delete(s.totps, id)
+	delete(s.recoveryCodes, id)
+	s.deleteUserPasswordResetTokens(id)
+	delete(s.passwords, id)
+	delete(s.loginAttempts, UserAttemptKey(id))
+	return nil
+}
+
+// ListupUsers returns users matching the query, all users if nil
+func (s *MemoryStore) ListupUsers(q *UserQuery) (*UserPage, error) {
+	log.Printf("db.MemoryStore.ListupUsers")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if q == nil {
+		q = &UserQuery{}
+	}
+	l := make(UserList, 0, len(s.users))
+	for _, u := range s.users {
+		if q.matches(&u) {
+			l = append(l, u)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return q.before(&l[i], &l[j])
+	})
+	page := UserPage{Total: len(l)}
+	if q.After != nil {
+		i := sort.Search(len(l), func(i int) bool {
+			return q.before(q.After, &l[i])
+		})
+		l = l[i:]
+	}
+	if q.Limit > 0 && len(l) > q.Limit {
+		l = l[:q.Limit]
+		page.More = true
+	}
+	page.Users = l
+	return &page, nil
+}
+
+// matches reports whether the user satisfies the filters
+func (q *UserQuery) matches(u *User) bool {
+	if !strings.HasPrefix(strings.ToLower(u.Name), strings.ToLower(q.NamePrefix)) {
+		return false
+	}
+	if q.IsAdmin != nil && u.IsAdmin != *q.IsAdmin {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && u.CreatedOn.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !u.CreatedOn.Before(q.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// before reports whether a is listed before b
+func (q *UserQuery) before(a, b *User) bool {
+	var less, greater bool
+	switch q.SortBy {
+	case UserSortName:
+		less, greater = a.Name < b.Name, a.Name > b.Name
+	case UserSortCreatedOn:
+		less, greater = a.CreatedOn.Before(b.CreatedOn), a.CreatedOn.After(b.CreatedOn)
+	}
+	if !less && !greater {
+		less, greater = a.ID < b.ID, a.ID > b.ID
+	}
+	if q.Descending {
+		return greater
+	}
+	return less
+}
+
+// LookupUserByEmail loads an user by email address
+func (s *MemoryStore) LookupUserByEmail(email string) (*User, error) {
+	log.Printf("db.MemoryStore.LookupUserByEmail %s", email)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if email != "" && u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, errors.Wrap(sql.ErrNoRows, `looking up user by email address`)
+}
+
+// VerifyUserEmail marks the email address of the user as verified
+func (s *MemoryStore) VerifyUserEmail(id, email string) error {
+	log.Printf("db.MemoryStore.VerifyUserEmail %s", id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok || email == "" || u.Email != email {
+		return errors.Wrap(sql.ErrNoRows, `looking up user with email address`)
+	}
+	u.EmailVerified = true
+	s.users[id] = u
+	return nil
+}
+
+// checkEmailAvailable requires s.mu to be locked
+func (s *MemoryStore) checkEmailAvailable(id, email string) error {
+	if email == "" {
+		return nil
+	}
+	for _, u := range s.users {
+		if u.ID != id && u.Email == email {
+			return ErrDuplicateEmail
+		}
+	}
+	return nil
+}
@@ -1,44 +1,269 @@
 package db_test
 
 import (
+	"database/sql"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/charakoba-com/auth-api/db"
+	"github.com/pkg/errors"
 )
 
 func TestLoad(t *testing.T) {
-	u := db.User{}
-	db.Init(nil)
-	tx, _ := db.BeginTx()
-	if err := u.Load(tx, "lookupID"); err != nil {
-		t.Errorf("%s", err)
-		return
+	for name, store := range testStores(t) {
+		u, err := store.LookupUser("lookupID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if u.ID != "lookupID" {
+			t.Errorf("%s: %s != lookupID", name, u.ID)
+			return
+		}
+		if u.Name != "lookupuser" {
+			t.Errorf("%s: %s != lookupuser", name, u.Name)
+			return
+		}
+		if u.Password != "testpasswd" {
+			t.Errorf("%s: %s != testpasswd", name, u.Password)
+			return
+		}
+		exTime := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+		if !u.CreatedOn.Equal(exTime) {
+			t.Errorf("%s: %s != %s", name, u.CreatedOn, exTime)
+			return
+		}
+		if !u.ModifiedOn.Valid {
+			t.Errorf("%s: %t", name, u.ModifiedOn.Valid)
+			return
+		}
+		if !u.ModifiedOn.Time.Equal(exTime) {
+			t.Errorf("%s: %s != %s", name, u.ModifiedOn.Time, exTime)
+			return
+		}
+		store.Close()
 	}
-	if u.ID != "lookupID" {
-		t.Errorf("%s != lookupID", u.ID)
-		return
+}
+
+func TestUserStore(t *testing.T) {
+	for name, store := range testStores(t) {
+		if err := store.CreateUser(&db.User{ID: "storeID", Name: "storeuser", Password: "hashed", IsAdmin: true}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if err := store.CreateUser(&db.User{ID: "storeID", Name: "storeuser", Password: "hashed"}); err == nil {
+			t.Errorf("%s: creating duplicated user should fail", name)
+			return
+		}
+		if err := store.UpdateUser(&db.User{ID: "storeID", Name: "renamed", Password: "rehashed", Email: "renamed@example.com"}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		u, err := store.LookupUser("storeID")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if u.Name != "renamed" || u.Password != "rehashed" || u.Email != "renamed@example.com" || !u.IsAdmin {
+			t.Errorf("%s: user is not updated: %v", name, u)
+			return
+		}
+		page, err := store.ListupUsers(nil)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(page.Users) != 4 || page.Total != 4 || page.More {
+			t.Errorf("%s: 4 users are expected, but %d", name, len(page.Users))
+			return
+		}
+		if err := store.DeleteUser("storeID"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if _, err := store.LookupUser("storeID"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		store.Close()
 	}
-	if u.Name != "lookupuser" {
-		t.Errorf("%s != lookupuser", u.Name)
-		return
+}
+
+func TestUserEmail(t *testing.T) {
+	for name, store := range testStores(t) {
+		if err := store.CreateUser(&db.User{ID: "emailID", Name: "emailuser", Password: "hashed", Email: "user@example.com"}); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		// users without email address do not conflict with each other
+		for _, id := range []string{"noEmailID1", "noEmailID2"} {
+			if err := store.CreateUser(&db.User{ID: id, Name: id, Password: "hashed"}); err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+		}
+		if err := store.CreateUser(&db.User{ID: "otherID", Name: "otheruser", Password: "hashed", Email: "user@example.com"}); errors.Cause(err) != db.ErrDuplicateEmail {
+			t.Errorf("%s: ErrDuplicateEmail is expected, but %v", name, err)
+			return
+		}
+		if err := store.UpdateUser(&db.User{ID: "noEmailID1", Name: "noEmailID1", Password: "hashed", Email: "user@example.com"}); errors.Cause(err) != db.ErrDuplicateEmail {
+			t.Errorf("%s: ErrDuplicateEmail is expected, but %v", name, err)
+			return
+		}
+
+		if err := store.VerifyUserEmail("emailID", "other@example.com"); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		if err := store.VerifyUserEmail("emailID", "user@example.com"); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		u, err := store.LookupUserByEmail("user@example.com")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if u.ID != "emailID" || !u.EmailVerified {
+			t.Errorf("%s: unexpected user: %v", name, u)
+			return
+		}
+		if _, err := store.LookupUserByEmail(""); errors.Cause(err) != sql.ErrNoRows {
+			t.Errorf("%s: sql.ErrNoRows is expected, but %v", name, err)
+			return
+		}
+		u, err = store.LookupUser("noEmailID1")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if u.Email != "" || u.EmailVerified {
+			t.Errorf("%s: unexpected user: %v", name, u)
+			return
+		}
+		store.Close()
 	}
-	if u.Password != "testpasswd" {
-		t.Errorf("%s != testpasswd", u.Password)
-		return
+}
+
+func TestListupUsersQuery(t *testing.T) {
+	for name, store := range testStores(t) {
+		for _, u := range []db.User{
+			{ID: "pageID1", Name: "pageuser", Password: "testpasswd"},
+			{ID: "pageID2", Name: "pageadmin", Password: "testpasswd", IsAdmin: true},
+		} {
+			if err := store.CreateUser(&u); err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+		}
+
+		// pages follow the order of the whole list, including users created at the same time
+		all, err := store.ListupUsers(&db.UserQuery{SortBy: db.UserSortCreatedOn, Descending: true})
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			return
+		}
+		if len(all.Users) != 5 || all.Users[4].ID != "deleteID" {
+			t.Errorf("%s: unexpected users: %v", name, all.Users)
+			return
+		}
+		q := db.UserQuery{SortBy: db.UserSortCreatedOn, Descending: true, Limit: 2}
+		var paged db.UserList
+		for {
+			page, err := store.ListupUsers(&q)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+			if page.Total != 5 || len(page.Users) > 2 {
+				t.Errorf("%s: unexpected page: %v", name, page)
+				return
+			}
+			paged = append(paged, page.Users...)
+			if !page.More {
+				break
+			}
+			q.After = &page.Users[len(page.Users)-1]
+		}
+		if len(paged) != len(all.Users) {
+			t.Errorf("%s: %d users are expected, but %d", name, len(all.Users), len(paged))
+			return
+		}
+		for i := range paged {
+			if paged[i].ID != all.Users[i].ID {
+				t.Errorf("%s: %s != %s at %d", name, paged[i].ID, all.Users[i].ID, i)
+				return
+			}
+		}
+
+		isAdmin := true
+		for _, c := range []struct {
+			q        db.UserQuery
+			expected []string
+		}{
+			{db.UserQuery{NamePrefix: "PAGE", SortBy: db.UserSortName}, []string{"pageID2", "pageID1"}},
+			{db.UserQuery{NamePrefix: "page%"}, nil},
+			{db.UserQuery{IsAdmin: &isAdmin}, []string{"pageID2"}},
+			{db.UserQuery{CreatedBefore: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}, []string{"deleteID", "lookupID", "updateID"}},
+			{db.UserQuery{CreatedAfter: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), Descending: true, Limit: 3}, []string{"updateID", "pageID2", "pageID1"}},
+		} {
+			page, err := store.ListupUsers(&c.q)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+			var ids []string
+			for _, u := range page.Users {
+				ids = append(ids, u.ID)
+			}
+			if !reflect.DeepEqual(ids, c.expected) {
+				t.Errorf("%s: %v is expected for %v, but %v", name, c.expected, c.q, ids)
+				return
+			}
+		}
+		store.Close()
 	}
-	loc, _ := time.LoadLocation("")
-	exTime := time.Date(2017, 1, 1, 0, 0, 0, 0, loc)
-	if u.CreatedOn != exTime {
-		t.Errorf("%s != %s", u.CreatedOn, exTime)
+}
+
+func TestListupUsersIndexSQLite(t *testing.T) {
+	store, err := db.OpenSQL(db.DriverSQLite, ":memory:")
+	if err != nil {
+		t.Errorf("%s", err)
 		return
 	}
-	if !u.ModifiedOn.Valid {
-		t.Errorf("%t", u.ModifiedOn.Valid)
+	defer store.Close()
+	if _, err := store.MigrateUp(0); err != nil {
+		t.Errorf("%s", err)
 		return
 	}
-	if u.ModifiedOn.Time != exTime {
-		t.Errorf("%s != %s", u.ModifiedOn.Time, exTime)
-		return
+	// created_on is compared and ordered normalized as the store queries it
+	createdOn := `strftime('%Y-%m-%d %H:%M:%f', created_on)`
+	for q, args := range map[string][]interface{}{
+		`SELECT * FROM users WHERE ` + createdOn + ` >= strftime('%Y-%m-%d %H:%M:%f', ?)`: {time.Now()},
+		`SELECT * FROM users ORDER BY ` + createdOn + ` DESC, id DESC LIMIT 10`:           nil,
+	} {
+		rows, err := store.DB().Query(`EXPLAIN QUERY PLAN `+q, args...)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		var plan []string
+		for rows.Next() {
+			var id, parent, notused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+				t.Errorf("%s", err)
+				return
+			}
+			plan = append(plan, detail)
+		}
+		rows.Close()
+		detail := strings.Join(plan, "\n")
+		if !strings.Contains(detail, "USING INDEX users_created_on") || strings.Contains(detail, "TEMP B-TREE") {
+			t.Errorf("users_created_on is not used: %s: %v", q, plan)
+			return
+		}
 	}
 }
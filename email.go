@@ -0,0 +1,72 @@
+package authapi
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/charakoba-com/auth-api/service"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// sendVerification mails a verification link to the user if the email address is not verified.
+// Failures are logged only, as the user can request the link again.
+func (s *Server) sendVerification(userID string) {
+	err := s.emailSvc.SendVerification(userID)
+	switch errors.Cause(err) {
+	case nil, service.ErrEmailVerified, service.ErrNoEmail:
+		return
+	}
+	log.Printf("sending verification mail to %s: %s", userID, err)
+}
+
+// SendVerificationHandler is a HTTP handler, which mails a link verifying the email address of an user
+func (s *Server) SendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("SendVerificationHandler")
+
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := s.emailSvc.SendVerification(id); err != nil {
+		switch errors.Cause(err) {
+		case sql.ErrNoRows:
+			httpError(w, http.StatusNotFound, `user not found`, nil)
+		case service.ErrNoEmail, service.ErrEmailVerified:
+			httpError(w, http.StatusBadRequest, err.Error(), nil)
+		default:
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		}
+		return
+	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
+
+// VerifyEmailHandler is a HTTP handler, which verifies the email address of an user
+// with the token of the link mailed by SendVerificationHandler
+func (s *Server) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("VerifyEmailHandler")
+
+	method := r.Method
+	if method != `GET` && method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET or POST is expected`, nil)
+		return
+	}
+	token := r.FormValue("token")
+	if token == "" {
+		httpError(w, http.StatusBadRequest, `token is required`, nil)
+		return
+	}
+	if _, err := s.emailSvc.Verify(token); err != nil {
+		if errors.Cause(err) == service.ErrInvalidVerificationToken {
+			httpError(w, http.StatusBadRequest, `email verification token is invalid`, nil)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
@@ -0,0 +1,144 @@
+package authapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+var verifyLinkPattern = regexp.MustCompile(`\S+/user/verify-email\?token=\S+`)
+
+// lastVerifyLink returns the verification link of the last mail
+func lastVerifyLink(t *testing.T, to string) string {
+	messages := mailSender.Messages()
+	if len(messages) == 0 {
+		t.Fatalf("no mail is sent")
+	}
+	m := messages[len(messages)-1]
+	link := verifyLinkPattern.FindString(m.Body)
+	if m.To != to || link == "" {
+		t.Fatalf("unexpected mail: %v", m)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return ts.URL + u.Path + "?" + u.RawQuery
+}
+
+func TestEmailVerification(t *testing.T) {
+	if status, message := postJSON(t, "/user", `{"id": "verifyID", "username": "verifyuser", "password": "testpasswd", "email": "Verify@Example.com"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d: %s", status, message)
+		return
+	}
+	defer usrSvc.Delete("verifyID")
+	link := lastVerifyLink(t, "verify@example.com")
+
+	// email addresses are unique regardless of case
+	if status, _ := postJSON(t, "/user", `{"id": "duplicateID", "username": "duplicateuser", "password": "testpasswd", "email": "VERIFY@example.com"}`); status != 409 {
+		t.Errorf("status 409 Conflict is expected, but %d", status)
+		return
+	}
+	if status, _ := postJSON(t, "/user", `{"id": "invalidID", "username": "invaliduser", "password": "testpasswd", "email": "Verify <verify@example.com>"}`); status != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %d", status)
+		return
+	}
+
+	s.SetRequireVerifiedEmail(true)
+	defer s.SetRequireVerifiedEmail(false)
+	if status, _ := postJSON(t, "/auth", `{"id": "verifyID", "password": "testpasswd"}`); status != 403 {
+		t.Errorf("status 403 Forbidden is expected, but %d", status)
+		return
+	}
+	res, err := http.Get(link)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	if status, message := postJSON(t, "/auth", `{"id": "verifyID", "password": "testpasswd"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d: %s", status, message)
+		return
+	}
+	res, err = http.DefaultClient.Do(authorizedRequest(t, "POST", "/user/verifyID/verify-email", nil, "verifyID", false))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 400 {
+		t.Errorf("status 400 Bad Request is expected for verified address, but %s", res.Status)
+		return
+	}
+
+	// email and email_verified claims are released with email scope
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "verifyID", Username: "verifyuser", ClientID: "webapp", Scope: "openid email"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	req, _ := http.NewRequest("GET", ts.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var userInfo model.UserInfoResponse
+	if err := json.NewDecoder(res.Body).Decode(&userInfo); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if userInfo.Email != "verify@example.com" || userInfo.EmailVerified == nil || !*userInfo.EmailVerified {
+		t.Errorf("unexpected userinfo: %v", userInfo)
+		return
+	}
+
+	// changed addresses need to be verified again, and old links are invalid
+	req = authorizedRequest(t, "PUT", "/user/verifyID", strings.NewReader(`{"username": "verifyuser", "new_password": "testpasswd", "email": "changed@example.com"}`), "verifyID", false)
+	req.Header.Set("Content-Type", "application/json")
+	if res, err = http.DefaultClient.Do(req); err != nil || res.StatusCode != 200 {
+		t.Errorf("updating user failed: %v %v", res, err)
+		return
+	}
+	changedLink := lastVerifyLink(t, "changed@example.com")
+	if status, _ := postJSON(t, "/auth", `{"id": "verifyID", "password": "testpasswd"}`); status != 403 {
+		t.Errorf("status 403 Forbidden is expected, but %d", status)
+		return
+	}
+	for _, l := range []struct {
+		link   string
+		status int
+	}{
+		{link, 400},
+		{changedLink, 200},
+		{ts.URL + "/user/verify-email?token=invalid", 400},
+	} {
+		res, err := http.Get(l.link)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if res.StatusCode != l.status {
+			t.Errorf("status %d is expected, but %s", l.status, res.Status)
+			return
+		}
+	}
+	user, err := usrSvc.Lookup("verifyID")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if user.Email != "changed@example.com" || !user.EmailVerified {
+		t.Errorf("unexpected user: %v", user)
+		return
+	}
+}
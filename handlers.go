@@ -1,16 +1,16 @@
 package authapi
 
 import (
+	"context"
+	"crypto"
 	"crypto/x509"
 	"database/sql"
 	"encoding/json"
 	"encoding/pem"
 	"log"
 	"net/http"
-	"strings"
+	"time"
 
-	"github.com/SermoDigital/jose/crypto"
-	"github.com/SermoDigital/jose/jws"
 	"github.com/charakoba-com/auth-api/db"
 	"github.com/charakoba-com/auth-api/keymgr"
 	"github.com/charakoba-com/auth-api/model"
@@ -20,10 +20,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-func init() {
-	keymgr.Init("/etc/authapi/pki/rsa256.key", "/etc/authapi/pki/rsa256.key.pub")
-}
-
 // HealthCheckHandler is a HTTP handler, which path is `/`
 func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("HealthCheckHandler")
@@ -37,8 +33,32 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// userError responds errors caused by invalid user attributes, and reports whether it did
+func userError(w http.ResponseWriter, err error) bool {
+	if policyErr, ok := errors.Cause(err).(*utils.PasswordPolicyError); ok {
+		res := model.PasswordPolicyErrorResponse{Message: `password does not satisfy the policy`}
+		for _, v := range policyErr.Violations {
+			res.Violations = append(res.Violations, model.PasswordViolation{Rule: v.Rule, Message: v.Message})
+		}
+		httpJSONWithStatus(w, http.StatusBadRequest, res)
+		return true
+	}
+	switch errors.Cause(err) {
+	case service.ErrInvalidEmail:
+		httpError(w, http.StatusBadRequest, `email address is invalid`, nil)
+		return true
+	case service.ErrPasswordRequired:
+		httpError(w, http.StatusBadRequest, `new_password is required to change the username`, nil)
+		return true
+	case db.ErrDuplicateEmail:
+		httpError(w, http.StatusConflict, `email address is used by another user`, nil)
+		return true
+	}
+	return false
+}
+
 // CreateUserHandler is a HTTP handler, which creates an new user
-func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("CreateUserHandler")
 
 	// Verify Request
@@ -63,29 +83,25 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		ID:       createUserRequest.ID,
 		Name:     createUserRequest.Username,
 		Password: createUserRequest.Password,
+		Email:    createUserRequest.Email,
 	}
 
 	// main logic
-	tx, err := db.BeginTx()
-	if err != nil {
-		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+	if err := s.usrSvc.Create(&newUser); err != nil {
+		if !userError(w, err) {
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		}
 		return
 	}
-	usrSvc := service.UserService{}
-	if err := usrSvc.Create(tx, &newUser); err != nil {
-		httpError(w, http.StatusInternalServerError, `internal server error`, err)
-		return
-	}
-	if err := tx.Commit(); err != nil {
-		httpError(w, http.StatusInternalServerError, `internal server error`, err)
-		return
+	if newUser.Email != "" {
+		s.sendVerification(newUser.ID)
 	}
 
 	httpJSON(w, map[string]string{"message": "success"})
 }
 
 // LookupUserHandler is a HTTP handler, which search an user by ID
-func LookupUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LookupUserHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("LookupUserHandler")
 
 	// Verify Request
@@ -95,13 +111,7 @@ func LookupUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	id := mux.Vars(r)["id"]
-	tx, err := db.BeginTx()
-	if err != nil {
-		httpError(w, http.StatusInternalServerError, `database error`, err)
-		return
-	}
-	var usrSvc service.UserService
-	user, err := usrSvc.Lookup(tx, id)
+	user, err := s.usrSvc.Lookup(id)
 	if err != nil {
 		if errors.Cause(err) == sql.ErrNoRows {
 			httpError(w, http.StatusNotFound, `user not found`, err)
@@ -115,7 +125,7 @@ func LookupUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // UpdateUserHandler is a HTTP handler, which updates an user
-func UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("UpdateUserHandler")
 
 	// Verify Request
@@ -137,114 +147,251 @@ func UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	updater := db.User{
-		ID:       updateUserRequest.ID,
+		ID:       mux.Vars(r)["id"],
 		Name:     updateUserRequest.Username,
 		Password: updateUserRequest.NewPassword,
+		Email:    updateUserRequest.Email,
 	}
 
 	// main logic
-	tx, err := db.BeginTx()
+	passwordChanged, err := s.usrSvc.Update(&updater)
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, `user not found`, err)
+			return
+		}
+		if !userError(w, err) {
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		}
 		return
 	}
-	usrSvc := service.UserService{}
-	u, err := usrSvc.Lookup(tx, updateUserRequest.ID)
-	if err != nil {
-		if errors.Cause(err) == sql.ErrNoRows {
-			httpError(w, http.StatusUnauthorized, `authorization failed`, nil)
+	if passwordChanged {
+		if err := s.tokenSvc.RevokeUser(updater.ID); err != nil {
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
 			return
 		}
+	}
+	if updater.Email != "" {
+		s.sendVerification(updater.ID)
+	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
+
+// DeleteUserHandler is a HTTP handler, which deletes an user
+func (s *Server) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteUserHandler")
+	method := r.Method
+	if method != `DELETE` {
+		httpError(w, http.StatusMethodNotAllowed, `method DELETE is expected`, nil)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := s.usrSvc.Delete(id); err != nil {
+		httpError(w, http.StatusInternalServerError, `deleting user`, err)
+		return
+	}
+	if err := s.tokenSvc.RevokeUser(id); err != nil {
+		httpError(w, http.StatusInternalServerError, `revoking tokens`, err)
+		return
+	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
+
+// ListupUserHandler is a HTTP handler, which returns a page of user list
+func (s *Server) ListupUserHandler(w http.ResponseWriter, r *http.Request) {
+	// NotImplemented
+	log.Printf("ListupUserHandler")
+	method := r.Method
+	if method != `GET` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
+		return
+	}
+	q, err := model.ParseUserQuery(r.URL.Query())
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	users, total, next, err := s.usrSvc.Listup(q)
+	if err != nil {
 		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	if u.Password != utils.HashPassword(updateUserRequest.OldPassword, u.ID+u.Name) {
-		httpError(w, http.StatusUnauthorized, `authorization failed`, nil)
+	for i := range users {
+		users[i].Password = ""
+	}
+	httpJSON(w, model.ListupUserResponse{Users: users, Total: total, NextCursor: next})
+}
+
+// LookupUserRolesHandler is a HTTP handler, which returns roles and permissions of an user
+func (s *Server) LookupUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("LookupUserRolesHandler")
+	method := r.Method
+	if method != `GET` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
 		return
 	}
-	if err := usrSvc.Update(tx, &updater); err != nil {
+	roles, permissions, err := s.roleSvc.UserRoles(mux.Vars(r)["id"])
+	if err != nil {
 		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	if err := tx.Commit(); err != nil {
+	httpJSON(w, model.UserRolesResponse{Roles: roles, Permissions: permissions})
+}
+
+// AssignRoleHandler is a HTTP handler, which assigns a role to an user
+func (s *Server) AssignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AssignRoleHandler")
+	method := r.Method
+	if method != `PUT` {
+		httpError(w, http.StatusMethodNotAllowed, `method PUT is expected`, nil)
+		return
+	}
+	vars := mux.Vars(r)
+	if err := s.roleSvc.Assign(vars["id"], vars["role"]); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, `user or role not found`, err)
+			return
+		}
 		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
 	httpJSON(w, map[string]string{"message": "success"})
 }
 
-// DeleteUserHandler is a HTTP handler, which deletes an user
-func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("DeleteUserHandler")
+// UnassignRoleHandler is a HTTP handler, which unassigns a role from an user
+func (s *Server) UnassignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UnassignRoleHandler")
 	method := r.Method
 	if method != `DELETE` {
 		httpError(w, http.StatusMethodNotAllowed, `method DELETE is expected`, nil)
 		return
 	}
-	id := mux.Vars(r)["id"]
-	tx, err := db.BeginTx()
-	if err != nil {
-		httpError(w, http.StatusInternalServerError, `database error`, err)
+	vars := mux.Vars(r)
+	if err := s.roleSvc.Unassign(vars["id"], vars["role"]); err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	var request model.DeleteUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+	httpJSON(w, map[string]string{"message": "success"})
+}
+
+// CreateRoleHandler is a HTTP handler, which creates a new role
+func (s *Server) CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("CreateRoleHandler")
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
 		return
 	}
-	var usrSvc service.UserService
-	u, err := usrSvc.Lookup(tx, request.ID)
-	if err != nil {
-		httpError(w, http.StatusUnauthorized, `authorization invalid`, nil)
+	var createRoleRequest model.CreateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&createRoleRequest); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid json request`, err)
 		return
 	}
-	if u.Password != utils.HashPassword(request.Password, u.ID+u.Name) {
-		httpError(w, http.StatusUnauthorized, `authorization invalid`, nil)
+	if createRoleRequest.Name == "" {
+		httpError(w, http.StatusBadRequest, `role name is required`, nil)
 		return
 	}
-	if request.ID != id && !u.IsAdmin {
-		httpError(w, http.StatusBadRequest, `no permission`, nil)
+	newRole := model.Role{
+		Name:        createRoleRequest.Name,
+		Description: createRoleRequest.Description,
+		Permissions: createRoleRequest.Permissions,
+	}
+	if err := s.roleSvc.Create(&newRole); err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	if err := usrSvc.Delete(tx, id); err != nil {
-		httpError(w, http.StatusInternalServerError, `deleting user`, err)
+	httpJSON(w, model.CreateRoleResponse{Message: "success"})
+}
+
+// LookupRoleHandler is a HTTP handler, which search a role by name
+func (s *Server) LookupRoleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("LookupRoleHandler")
+	method := r.Method
+	if method != `GET` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
 		return
 	}
-	if err := tx.Commit(); err != nil {
-		httpError(w, http.StatusInternalServerError, `deleting user(commit)`, err)
+	role, err := s.roleSvc.Lookup(mux.Vars(r)["name"])
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, `role not found`, err)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	httpJSON(w, map[string]string{"message": "success"})
+	httpJSON(w, model.LookupRoleResponse{Role: *role})
 }
 
-// ListupUserHandler is a HTTP handler, which returns all user list
-func ListupUserHandler(w http.ResponseWriter, r *http.Request) {
-	// NotImplemented
-	log.Printf("ListupUserHandler")
+// ListupRoleHandler is a HTTP handler, which returns all role list
+func (s *Server) ListupRoleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ListupRoleHandler")
 	method := r.Method
 	if method != `GET` {
 		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
 		return
 	}
-	tx, err := db.BeginTx()
+	roles, err := s.roleSvc.Listup()
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, `database error`, err)
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	var usrSvc service.UserService
-	users, err := usrSvc.Listup(tx)
-	if err != nil {
+	httpJSON(w, model.ListupRoleResponse{Roles: roles})
+}
+
+// DeleteRoleHandler is a HTTP handler, which deletes a role
+func (s *Server) DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteRoleHandler")
+	method := r.Method
+	if method != `DELETE` {
+		httpError(w, http.StatusMethodNotAllowed, `method DELETE is expected`, nil)
+		return
+	}
+	if err := s.roleSvc.Delete(mux.Vars(r)["name"]); err != nil {
+		httpError(w, http.StatusInternalServerError, `deleting role`, err)
+		return
+	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
+
+// GrantPermissionHandler is a HTTP handler, which grants a permission to a role
+func (s *Server) GrantPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("GrantPermissionHandler")
+	method := r.Method
+	if method != `PUT` {
+		httpError(w, http.StatusMethodNotAllowed, `method PUT is expected`, nil)
+		return
+	}
+	vars := mux.Vars(r)
+	if err := s.roleSvc.Grant(vars["name"], vars["permission"]); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, `role not found`, err)
+			return
+		}
 		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	for i := range users {
-		users[i].Password = ""
+	httpJSON(w, map[string]string{"message": "success"})
+}
+
+// RevokePermissionHandler is a HTTP handler, which revokes a permission from a role
+func (s *Server) RevokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("RevokePermissionHandler")
+	method := r.Method
+	if method != `DELETE` {
+		httpError(w, http.StatusMethodNotAllowed, `method DELETE is expected`, nil)
+		return
 	}
-	httpJSON(w, model.ListupUserResponse{Users: users})
+	vars := mux.Vars(r)
+	if err := s.roleSvc.Revoke(vars["name"], vars["permission"]); err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, map[string]string{"message": "success"})
 }
 
 // AuthHandler is a HTTP handler, which authes with username and password
-func AuthHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) AuthHandler(w http.ResponseWriter, r *http.Request) {
 	// NotImplemented
 	log.Printf("AuthHandler")
 
@@ -258,13 +405,126 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
 		return
 	}
-	tx, err := db.BeginTx()
+	audience, ok := s.requestedAudience(w, authRequest.Audience)
+	if !ok {
+		return
+	}
+	ip := clientIP(r)
+	if !s.checkLockout(w, authRequest.ID, ip) {
+		return
+	}
+	user, err := s.usrSvc.Authenticate(authRequest.ID, authRequest.Password)
+	if err != nil {
+		switch errors.Cause(err) {
+		case service.ErrAuthFailed:
+			s.authFailed(w, authRequest.ID, ip)
+			return
+		case service.ErrEmailNotVerified:
+			httpError(w, http.StatusForbidden, `email address is not verified`, nil)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+
+	enabled, err := s.mfaSvc.Enabled(user.ID)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	if enabled {
+		mfaToken, err := s.tokens.WithContext(r.Context()).GenerateMFAToken(user.ID)
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+			return
+		}
+		httpJSON(w, model.AuthResponse{
+			Message:  "mfa required",
+			MFAToken: mfaToken,
+			// MFA token must be exchanged before it expires
+			ExpiresIn: int64(s.tokens.MFATokenLifetime / time.Second),
+		})
+		return
+	}
+
+	res, err := s.authResponse(r.Context(), user, audience)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, res)
+}
+
+// requestedAudience returns the audience requested at `/auth` or `/auth/mfa`.
+// An error is responded for an unknown audience.
+func (s *Server) requestedAudience(w http.ResponseWriter, audience string) (string, bool) {
+	if audience == "" {
+		return s.tokens.Audience, true
+	}
+	if !s.tokens.KnownAudience(audience) {
+		httpError(w, http.StatusBadRequest, `unknown audience`, nil)
+		return "", false
+	}
+	return audience, true
+}
+
+// AuthMFAHandler is a HTTP handler, which exchanges a MFA token and a second factor code with tokens
+func (s *Server) AuthMFAHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AuthMFAHandler")
+
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	var authMFARequest model.AuthMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&authMFARequest); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+		return
+	}
+	audience, ok := s.requestedAudience(w, authMFARequest.Audience)
+	if !ok {
+		return
+	}
+	token, err := utils.ParseToken(authMFARequest.MFAToken)
+	if err != nil {
+		httpError(w, http.StatusUnauthorized, `mfa token invalid`, nil)
+		return
+	}
+	if err := s.tokens.ValidateMFAToken(token); err != nil {
+		httpError(w, http.StatusUnauthorized, `mfa token invalid`, nil)
+		return
+	}
+	claims := token.Claims()
+	revoked, err := s.tokenSvc.IsRevoked(claims)
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, `database errorr`, err)
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	if revoked {
+		httpError(w, http.StatusUnauthorized, `mfa token invalid`, nil)
+		return
+	}
+	userID, _ := claims.Subject()
+	ip := clientIP(r)
+	if !s.checkLockout(w, userID, ip) {
+		return
+	}
+	if err := s.mfaSvc.Verify(userID, authMFARequest.Code); err != nil {
+		switch errors.Cause(err) {
+		case service.ErrInvalidMFACode, service.ErrMFANotEnrolled:
+			s.authFailed(w, userID, ip)
+		default:
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		}
 		return
 	}
-	var usrSvc service.UserService
-	user, err := usrSvc.Lookup(tx, authRequest.ID)
+	// MFA token is used only once
+	if err := s.tokenSvc.Revoke(claims); err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	user, err := s.usrSvc.Lookup(userID)
 	if err != nil {
 		if errors.Cause(err) == sql.ErrNoRows {
 			httpError(w, http.StatusUnauthorized, `auth invalid`, nil)
@@ -273,36 +533,239 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	if user.Password != utils.HashPassword(authRequest.Password, authRequest.ID+user.Name) {
-		httpError(w, http.StatusUnauthorized, `auth invalid`, nil)
+
+	res, err := s.authResponse(r.Context(), user, audience)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, res)
+}
+
+// checkLockout responds an error and returns false
+// when the user or the client address is locked out
+func (s *Server) checkLockout(w http.ResponseWriter, userID, ip string) bool {
+	until, err := s.lockoutSvc.Check(userID, ip)
+	if err == nil {
+		return true
+	}
+	switch errors.Cause(err) {
+	case service.ErrAccountLocked:
+		setRetryAfter(w, until)
+		httpError(w, http.StatusLocked, `account is locked`, nil)
+	case service.ErrTooManyAttempts:
+		setRetryAfter(w, until)
+		httpError(w, http.StatusTooManyRequests, `too many failed attempts`, nil)
+	default:
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+	}
+	return false
+}
+
+// authFailed records the failure and responds 401 Unauthorized
+func (s *Server) authFailed(w http.ResponseWriter, userID, ip string) {
+	if err := s.lockoutSvc.Fail(userID, ip); err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
+	httpError(w, http.StatusUnauthorized, `auth invalid`, nil)
+}
 
-	token, err := utils.GenerateToken(user.Name, user.IsAdmin)
+// authResponse issues an access token for the audience and a refresh token for the authenticated user.
+// Failures of the user are forgotten as the authentication has completed.
+func (s *Server) authResponse(ctx context.Context, user *model.User, audience string) (*model.AuthResponse, error) {
+	if err := s.lockoutSvc.Succeed(user.ID); err != nil {
+		return nil, err
+	}
+	sub, err := s.roleSvc.TokenSubject(user)
 	if err != nil {
+		return nil, err
+	}
+	sub.Audience = audience
+	token, err := s.tokens.WithContext(ctx).GenerateToken(sub)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := s.tokenSvc.IssueRefreshToken(user.ID, audience)
+	if err != nil {
+		return nil, err
+	}
+	return &model.AuthResponse{
+		Message:      "auth valid",
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.tokens.AccessTokenLifetimeFor(audience) / time.Second),
+	}, nil
+}
+
+// UnlockUserHandler is a HTTP handler, which unlocks an user locked out by failed authentications
+func (s *Server) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UnlockUserHandler")
+	method := r.Method
+	if method != `DELETE` {
+		httpError(w, http.StatusMethodNotAllowed, `method DELETE is expected`, nil)
+		return
+	}
+	if err := s.lockoutSvc.Unlock(mux.Vars(r)["id"]); err != nil {
 		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
 
-	httpJSON(w, model.AuthResponse{
-		Message: "auth valid",
-		Token:   token,
+// EnrollTOTPHandler is a HTTP handler, which enrolls a TOTP authenticator of the user
+func (s *Server) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("EnrollTOTPHandler")
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	user, err := s.usrSvc.Lookup(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, `user not found`, err)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	secret, uri, recoveryCodes, err := s.mfaSvc.EnrollTOTP(user.ID, user.Name)
+	if err != nil {
+		if errors.Cause(err) == service.ErrMFAEnabled {
+			httpError(w, http.StatusConflict, `second factor is enabled already`, nil)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	// the secret and the recovery codes are shown only once
+	w.Header().Set("Cache-Control", "no-store")
+	httpJSON(w, model.EnrollTOTPResponse{
+		Secret:          secret,
+		ProvisioningURI: uri,
+		RecoveryCodes:   recoveryCodes,
 	})
 }
 
-// GetAlgorithmHandler is a HTTP handler, which returns system signature algorithm
-func GetAlgorithmHandler(w http.ResponseWriter, r *http.Request) {
+// ConfirmTOTPHandler is a HTTP handler, which enables the enrolled TOTP authenticator
+func (s *Server) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ConfirmTOTPHandler")
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	var confirmTOTPRequest model.ConfirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&confirmTOTPRequest); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+		return
+	}
+	if err := s.mfaSvc.ConfirmTOTP(mux.Vars(r)["id"], confirmTOTPRequest.Code); err != nil {
+		switch errors.Cause(err) {
+		case service.ErrMFANotEnrolled:
+			httpError(w, http.StatusNotFound, `second factor is not enrolled`, nil)
+		case service.ErrMFAEnabled:
+			httpError(w, http.StatusConflict, `second factor is enabled already`, nil)
+		case service.ErrInvalidMFACode:
+			httpError(w, http.StatusBadRequest, `code is not valid`, nil)
+		default:
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		}
+		return
+	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
+
+// ResetTOTPHandler is a HTTP handler, which removes the second factor of the user
+func (s *Server) ResetTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ResetTOTPHandler")
+	method := r.Method
+	if method != `DELETE` {
+		httpError(w, http.StatusMethodNotAllowed, `method DELETE is expected`, nil)
+		return
+	}
+	if err := s.mfaSvc.Reset(mux.Vars(r)["id"]); err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
+
+// RefreshTokenHandler is a HTTP handler, which exchanges a refresh token with new tokens
+func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("RefreshTokenHandler")
+
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	var refreshTokenRequest model.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&refreshTokenRequest); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+		return
+	}
+	refreshToken, rt, err := s.tokenSvc.Refresh(refreshTokenRequest.RefreshToken, "")
+	if err != nil {
+		switch errors.Cause(err) {
+		case service.ErrInvalidRefreshToken:
+			httpError(w, http.StatusUnauthorized, `refresh token invalid`, nil)
+		case service.ErrRefreshTokenReused:
+			httpError(w, http.StatusUnauthorized, `refresh token reused`, nil)
+		default:
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		}
+		return
+	}
+	user, err := s.usrSvc.Lookup(rt.UserID)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusUnauthorized, `refresh token invalid`, nil)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	sub, err := s.roleSvc.TokenSubject(user)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	// the audience requested at `/auth` is kept
+	sub.Audience = rt.Audience
+	token, err := s.tokens.WithContext(r.Context()).GenerateToken(sub)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+
+	httpJSON(w, model.RefreshTokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.tokens.AccessTokenLifetimeFor(s.tokens.TokenAudience(sub)) / time.Second),
+	})
+}
+
+// GetAlgorithmHandler is a HTTP handler, which returns signature algorithm of the signing key
+func (s *Server) GetAlgorithmHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("GetAlgorithmHandler")
 	method := r.Method
 	if method != `GET` {
 		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
 		return
 	}
-	httpJSON(w, model.GetAlgorithmResponse{Algorithm: "RS256"})
+	algorithm, err := s.tokens.Keys.Algorithm()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, model.GetAlgorithmResponse{Algorithm: algorithm})
 }
 
-// VerifyHandler is a HTTP handler, which verifies given token
-func VerifyHandler(w http.ResponseWriter, r *http.Request) {
+// VerifyHandler is a HTTP handler, which verifies given token.
+// The token must be issued for the audience given by `audience` query parameter.
+func (s *Server) VerifyHandler(w http.ResponseWriter, r *http.Request) {
 	// NotImplemented
 	log.Printf("VerifyHandler")
 	method := r.Method
@@ -310,42 +773,78 @@ func VerifyHandler(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
 		return
 	}
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		httpError(w, http.StatusBadRequest, `Authorization header is required`, nil)
-		return
-	}
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		httpError(w, http.StatusBadRequest, `Authorization: Bearer is required`, nil)
+	raw, err := bearerToken(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
-	token, err := jws.ParseJWT([]byte(strings.Split(authHeader, " ")[1]))
+	token, err := utils.ParseToken(raw)
 	if err != nil {
 		httpError(w, http.StatusBadRequest, `token is not valid`, nil)
 		return
 	}
-	publicKey, err := keymgr.PublicKey()
+	if err := s.tokens.ValidateToken(token); err != nil {
+		log.Printf("%s", err)
+		httpJSON(w, model.VerifyResponse{Status: false})
+		return
+	}
+	// tokens of other audiences are not accepted unless the verifier tells its audience
+	audience := r.URL.Query().Get("audience")
+	if audience == "" {
+		audience = s.tokens.Audience
+	}
+	if err := utils.ValidateAudience(token.Claims(), audience); err != nil {
+		log.Printf("%s", err)
+		httpJSON(w, model.VerifyResponse{Status: false})
+		return
+	}
+	revoked, err := s.tokenSvc.IsRevoked(token.Claims())
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, `internal server error`, err)
 		return
 	}
-	if err := token.Validate(publicKey, crypto.SigningMethodRS256); err != nil {
-		httpJSON(w, model.VerifyResponse{Status: false})
+	httpJSON(w, model.VerifyResponse{Status: !revoked})
+}
+
+// LogoutHandler is a HTTP handler, which revokes presented token.
+// The refresh token given in the request body is revoked as well.
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("LogoutHandler")
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
 		return
 	}
-	httpJSON(w, model.VerifyResponse{Status: true})
+	var logoutRequest model.LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&logoutRequest); err != nil {
+			httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+			return
+		}
+	}
+	if err := s.tokenSvc.Revoke(requestClaims(r)); err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	if logoutRequest.RefreshToken != "" {
+		if err := s.tokenSvc.RevokeRefreshToken(logoutRequest.RefreshToken); err != nil && errors.Cause(err) != service.ErrInvalidRefreshToken {
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+			return
+		}
+	}
+	httpJSON(w, model.LogoutResponse{Message: "success"})
 }
 
-// GetKeyHandler is a HTTP handler, which returns public key verifying token
-func GetKeyHandler(w http.ResponseWriter, r *http.Request) {
+// GetKeyHandler is a HTTP handler, which returns public key of the signing key verifying token
+func (s *Server) GetKeyHandler(w http.ResponseWriter, r *http.Request) {
 	// NotImplemented
 	log.Printf("GetKeyHandler")
-	publicKey, err := keymgr.PublicKey()
+	key, err := s.tokens.Keys.ActiveKey()
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, `internal server error`, nil)
 		return
 	}
-	pub, err := x509.MarshalPKIXPublicKey(publicKey)
+	pub, err := x509.MarshalPKIXPublicKey(key.PublicKey)
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, `internal server error`, nil)
 		return
@@ -356,7 +855,86 @@ func GetKeyHandler(w http.ResponseWriter, r *http.Request) {
 			Bytes: pub,
 		},
 	)
-	httpJSON(w, model.GetKeyResponse{PublicKey: string(encoded)})
+	httpJSON(w, model.GetKeyResponse{
+		PublicKey: string(encoded),
+		Algorithm: key.Algorithm,
+		KeyID:     key.ID,
+	})
+}
+
+// RotateKeyHandler is a HTTP handler, which stores a new signing key and promotes it.
+// The previous key is retired but still published until tokens signed with it expire.
+// Keys held by the remote signer are rotated by the signer, not by this API.
+func (s *Server) RotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("RotateKeyHandler")
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	if s.keySvc == nil {
+		httpError(w, http.StatusConflict, `signing key is held by the remote signer`, nil)
+		return
+	}
+	var request model.RotateKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+			return
+		}
+	}
+
+	var privateKey crypto.Signer
+	var err error
+	if request.PrivateKey != "" {
+		privateKey, err = keymgr.ParsePrivateKey([]byte(request.PrivateKey))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, `invalid private key`, err)
+			return
+		}
+	} else {
+		// the algorithm is kept unless another one is requested
+		algorithm := request.Algorithm
+		if algorithm == "" {
+			if algorithm, err = s.tokens.Keys.Algorithm(); err != nil {
+				httpError(w, http.StatusInternalServerError, `internal server error`, err)
+				return
+			}
+		}
+		switch algorithm {
+		case keymgr.AlgorithmRS256, keymgr.AlgorithmES256, keymgr.AlgorithmEdDSA:
+		default:
+			httpError(w, http.StatusBadRequest, `unsupported algorithm`, nil)
+			return
+		}
+		privateKey, err = keymgr.GenerateKey(algorithm)
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, `generating key`, err)
+			return
+		}
+	}
+	kid, err := s.keySvc.Rotate(privateKey)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, `rotating key`, err)
+		return
+	}
+	httpJSON(w, model.RotateKeyResponse{Message: "success", KeyID: kid})
+}
+
+// JWKSetHandler is a HTTP handler, which returns public keys verifying token as JWK Set
+func (s *Server) JWKSetHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("JWKSetHandler")
+	method := r.Method
+	if method != `GET` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
+		return
+	}
+	keys, err := s.tokens.Keys.JWKs()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, nil)
+		return
+	}
+	httpJSON(w, model.JWKSetResponse{Keys: keys})
 }
 
 // NotFoundHandler is a HTTP handler, which handles 404 Not Found
@@ -2,19 +2,31 @@ package authapi_test
 
 import (
 	"bytes"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
+	"io"
 	"io/ioutil"
+	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/SermoDigital/jose/crypto"
 	"github.com/SermoDigital/jose/jws"
 	authapi "github.com/charakoba-com/auth-api"
+	"github.com/charakoba-com/auth-api/config"
 	"github.com/charakoba-com/auth-api/db"
 	"github.com/charakoba-com/auth-api/keymgr"
+	"github.com/charakoba-com/auth-api/keymgr/keymgrtest"
+	"github.com/charakoba-com/auth-api/mail"
 	"github.com/charakoba-com/auth-api/model"
 	"github.com/charakoba-com/auth-api/service"
 	"github.com/charakoba-com/auth-api/utils"
@@ -22,18 +34,97 @@ import (
 
 var s *authapi.Server
 var ts *httptest.Server
+var usrSvc *service.UserService
+var tokenSvc *service.TokenService
+var mailSender *mail.MemorySender
 
 func TestMain(m *testing.M) {
-	s = authapi.New()
+	store := db.NewMemoryStore()
+	usrSvc = &service.UserService{Store: store}
+	tokenSvc = &service.TokenService{Store: store}
+	// same as sql/test_data.sql
+	exTime := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	usrSvc.Create(&db.User{ID: "lookupID", Name: "lookupuser", Password: "testpasswd", CreatedOn: exTime})
+	usrSvc.Create(&db.User{ID: "adminID", Name: "adminuser", Password: "testpasswd", IsAdmin: true, CreatedOn: exTime})
+	// legacy sha512 hashes
+	store.CreateUser(&db.User{ID: "updateID", Name: "updateuser", Password: "19b4b8ed555a76a5c635211c7aaeaea5f964925ebf5cdf8af236fb01ee3842525453eea927f61cfafbe66277551151e96938162599f87a05f84dab621fbc315d", CreatedOn: exTime})
+	store.CreateUser(&db.User{ID: "deleteID", Name: "deleteuser", Password: "0d7ff83a53038e7629c45809eaa62bcb5888d6fa5525eaa388a1725bd7942e8aaf58264ba6b7c488763babbd1e7c9d1d84d6092ff200f7198284460bd5a31eb9", CreatedOn: exTime})
+
+	cfg, err := config.Load("", map[string]interface{}{
+		"database.driver": db.DriverMemory,
+		"key.private_key": "./test/jwtRS256.key",
+		"key.public_key":  "./test/jwtRS256.key.pub",
+		"token.audience_lifetimes": []interface{}{
+			map[string]interface{}{"audience": "admin-ui", "lifetime": "5m"},
+		},
+	})
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("%s", err)
+	}
+	s, err = authapi.New(cfg, store)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	mailSender = &mail.MemorySender{}
+	s.SetMailSender(mailSender)
 	ts = httptest.NewServer(s)
-	defer ts.Close()
-	db.Init(nil)
 
 	exitCode := m.Run()
 
+	ts.Close()
 	os.Exit(exitCode)
 }
 
+// authorizedRequest returns a request with a bearer token for the user
+func authorizedRequest(t *testing.T, method, path string, body io.Reader, id string, isAdmin bool) *http.Request {
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: id, Username: id, IsAdmin: isAdmin})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// newServer returns another server on the store, configured by settings over the test keys
+func newServer(t *testing.T, store db.Store, settings map[string]interface{}) *authapi.Server {
+	values := map[string]interface{}{
+		"database.driver": db.DriverMemory,
+		"key.private_key": "./test/jwtRS256.key",
+		"key.public_key":  "./test/jwtRS256.key.pub",
+	}
+	for k, v := range settings {
+		values[k] = v
+	}
+	cfg, err := config.Load("", values)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	server, err := authapi.New(cfg, store)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return server
+}
+
+// captureLog returns what is logged while f runs
+func captureLog(f func()) string {
+	buf := bytes.Buffer{}
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	f()
+	return buf.String()
+}
+
 func TestHealthCheckHandlerOK(t *testing.T) {
 	res, err := http.Get(ts.URL)
 	if err != nil {
@@ -96,38 +187,31 @@ func TestCreateUserHandlerOK(t *testing.T) {
 		return
 	}
 
-	tx, err := db.BeginTx()
-	if err != nil {
-		t.Errorf("%s", err)
-		return
-	}
 	defer func() {
-		var usrSvc service.UserService
 		// reset
-		if err := usrSvc.Delete(tx, "createID"); err != nil {
-			t.Errorf("%s", err)
-			return
-		}
-		if err := tx.Commit(); err != nil {
+		if err := usrSvc.Delete("createID"); err != nil {
 			t.Errorf("%s", err)
 			return
 		}
 	}()
 
 	// data test
-	var usrSvc service.UserService
-	user, err := usrSvc.Lookup(tx, `createID`)
+	user, err := usrSvc.Lookup(`createID`)
 	if err != nil {
 		t.Errorf("%s", err)
 		return
 	}
 	expectedUser := model.User{
-		ID:       "createID",
-		Name:     "createdUser",
-		Password: "94bcc83bba984e580ba817a81f082de9a800cfd413018521f2304702166134f98f5ceac8cc32284a8d6ea62d43feb3f58c9453aefd5858f39bea6ad17098060a",
+		ID:   "createID",
+		Name: "createdUser",
+	}
+	if ok, _, err := utils.VerifyPassword(user.Password, "testpasswd", ""); err != nil || !ok {
+		t.Errorf("password is not hashed correctly: %s", user.Password)
+		return
 	}
+	user.Password = ""
 	if *user != expectedUser {
-		t.Errorf("%s != %s", user, expectedUser)
+		t.Errorf("%v != %v", user, expectedUser)
 		return
 	}
 }
@@ -135,7 +219,7 @@ func TestCreateUserHandlerOK(t *testing.T) {
 func TestLookupUserHandlerOK(t *testing.T) {
 	path := "/user/lookupID"
 	t.Logf("GET %s", path)
-	res, err := http.Get(ts.URL + path)
+	res, err := http.DefaultClient.Do(authorizedRequest(t, "GET", path, nil, "lookupID", false))
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -156,7 +240,7 @@ func TestLookupUserHandlerOK(t *testing.T) {
 		Name: "lookupuser",
 	}
 	if lures.User != expectedUser {
-		t.Errorf("%s != %s", lures.User, expectedUser)
+		t.Errorf("%v != %v", lures.User, expectedUser)
 		return
 	}
 }
@@ -164,7 +248,7 @@ func TestLookupUserHandlerOK(t *testing.T) {
 func TestLookupUserHandlerNotFound(t *testing.T) {
 	path := "/user/hoge"
 	t.Logf("GET %s", path)
-	res, err := http.Get(ts.URL + path)
+	res, err := http.DefaultClient.Do(authorizedRequest(t, "GET", path, nil, "adminID", true))
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -186,12 +270,10 @@ func TestLookupUserHandlerNotFound(t *testing.T) {
 }
 
 func TestUpdateUserHandlerOK(t *testing.T) {
-	path := "/user"
+	path := "/user/updateID"
 	t.Logf("PUT %s", path)
 	updateUserRequest := model.UpdateUserRequest{
-		ID:          "updateID",
 		Username:    "updateduser",
-		OldPassword: "testpasswd",
 		NewPassword: "testpasswd",
 	}
 	// I/O test
@@ -200,11 +282,7 @@ func TestUpdateUserHandlerOK(t *testing.T) {
 		t.Errorf("%s", err)
 		return
 	}
-	req, err := http.NewRequest("PUT", ts.URL+path, &requestBody)
-	if err != nil {
-		t.Errorf("%s", err)
-		return
-	}
+	req := authorizedRequest(t, "PUT", path, &requestBody, "updateID", false)
 	req.Header.Set("Content-Type", "application/json")
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -225,55 +303,124 @@ func TestUpdateUserHandlerOK(t *testing.T) {
 		return
 	}
 	// data test
-	tx, err := db.BeginTx()
-	if err != nil {
-		t.Errorf("%s", err)
-		return
-	}
-	var usrSvc service.UserService
-	user, err := usrSvc.Lookup(tx, `updateID`)
+	user, err := usrSvc.Lookup(`updateID`)
 	if err != nil {
 		t.Errorf("%s", err)
 		return
 	}
 	expectedUser := model.User{
-		ID:       "updateID",
-		Name:     "updateduser",
-		Password: "c9fb76e49a114d81796a810eaea344e6f21f1dd32d4940e1fa6f4b0286280259ca41ac455ba0da0d9fcc71e8f65645420491a4c6917f88e719f68bf68c869c34",
+		ID:   "updateID",
+		Name: "updateduser",
+	}
+	if ok, _, err := utils.VerifyPassword(user.Password, "testpasswd", ""); err != nil || !ok {
+		t.Errorf("password is not hashed correctly: %s", user.Password)
+		return
 	}
+	user.Password = ""
 	if *user != expectedUser {
-		t.Errorf("%s != %s", user, expectedUser)
+		t.Errorf("%v != %v", user, expectedUser)
 		return
 	}
 	// reset
-	if err := usrSvc.Update(tx, &db.User{ID: "updateID", Name: "updateuser", Password: "testpasswd"}); err != nil {
+	if _, err := usrSvc.Update(&db.User{ID: "updateID", Name: "updateuser", Password: "testpasswd"}); err != nil {
 		t.Errorf("%s", err)
 		return
 	}
-	if err := tx.Commit(); err != nil {
+}
+
+func TestUpdateUserHandlerRevocation(t *testing.T) {
+	if err := usrSvc.Create(&db.User{ID: "revokeID", Name: "revokeuser", Password: "testpasswd"}); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer usrSvc.Delete("revokeID")
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "revokeID", Username: "revokeuser"})
+	if err != nil {
 		t.Errorf("%s", err)
 		return
 	}
-}
 
-func TestDeleteUserHandlerOK(t *testing.T) {
-	path := "/user/deleteID"
-	t.Logf("DELETE %s", path)
-	deleteUserRequest := model.DeleteUserRequest{
-		ID:       "deleteID",
-		Password: "testpasswd",
+	// updateUser updates the user and returns the status code
+	updateUser := func(body string) int {
+		req := authorizedRequest(t, "PUT", "/user/revokeID", strings.NewReader(body), "revokeID", false)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
 	}
-	requestBody := bytes.Buffer{}
-	if err := json.NewEncoder(&requestBody).Encode(deleteUserRequest); err != nil {
+	// tokens survive updates keeping the password
+	if status := updateUser(`{"username": "renameduser", "new_password": "testpasswd"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d", status)
+		return
+	}
+	if status := updateUser(`{"username": "renameduser"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d", status)
+		return
+	}
+	if !verify(t, token) {
+		t.Errorf("token should not be revoked without password change")
+		return
+	}
+	if status := updateUser(`{"username": "renameduser", "new_password": "changedpasswd"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d", status)
+		return
+	}
+	if verify(t, token) {
+		t.Errorf("token should be revoked by password change")
+		return
+	}
+}
+
+func TestUpdateUserHandlerKeepPassword(t *testing.T) {
+	// legacy sha512 hash of "testpasswd" salted with "rehashIDrehashuser"
+	legacy := db.User{
+		ID:       "rehashID",
+		Name:     "rehashuser",
+		Password: "2a612956564f985fd49fdbbb1b889f6fcdfaedc27b1e00fb05fc23160152dc92c1213ae97cb3468ec71d6c3412dfcb8eadc5511e4cf396177ec1d2bf53e49231",
+	}
+	if err := usrSvc.Store.CreateUser(&legacy); err != nil {
 		t.Errorf("%s", err)
 		return
 	}
-	req, err := http.NewRequest("DELETE", ts.URL+path, &requestBody)
+	defer usrSvc.Delete("rehashID")
+
+	updateUser := func(body string) int {
+		req := authorizedRequest(t, "PUT", "/user/rehashID", strings.NewReader(body), "rehashID", false)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	// legacy hashes are salted with the username, which is not changed without the password
+	if status := updateUser(`{"username": "renameduser"}`); status != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %d", status)
+		return
+	}
+	if status := updateUser(`{"username": "rehashuser", "email": "rehash@example.com"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d", status)
+		return
+	}
+	user, err := usrSvc.Lookup("rehashID")
 	if err != nil {
 		t.Errorf("%s", err)
 		return
 	}
-	res, err := http.DefaultClient.Do(req)
+	if user.Password != legacy.Password || user.Email != "rehash@example.com" {
+		t.Errorf("unexpected user: %v", user)
+		return
+	}
+}
+
+func TestDeleteUserHandlerOK(t *testing.T) {
+	path := "/user/deleteID"
+	t.Logf("DELETE %s", path)
+	res, err := http.DefaultClient.Do(authorizedRequest(t, "DELETE", path, nil, "deleteID", false))
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -292,24 +439,14 @@ func TestDeleteUserHandlerOK(t *testing.T) {
 		t.Errorf("response message is invalid")
 		return
 	}
-	tx, err := db.BeginTx()
-	if err != nil {
-		t.Errorf("%s", err)
-		return
-	}
 	// data test
-	var usrSvc service.UserService
-	_, err = usrSvc.Lookup(tx, `deleteID`)
+	_, err = usrSvc.Lookup(`deleteID`)
 	if err == nil {
 		t.Errorf("sql.ErrNoRows should be occured, but there is no error")
 		return
 	}
 	// reset
-	if err := usrSvc.Create(tx, &db.User{ID: "deleteID", Name: "deleteuser", Password: "testpasswd"}); err != nil {
-		t.Errorf("%s", err)
-		return
-	}
-	if err := tx.Commit(); err != nil {
+	if err := usrSvc.Create(&db.User{ID: "deleteID", Name: "deleteuser", Password: "testpasswd"}); err != nil {
 		t.Errorf("%s", err)
 		return
 	}
@@ -318,7 +455,7 @@ func TestDeleteUserHandlerOK(t *testing.T) {
 func TestListupUserHandlerOK(t *testing.T) {
 	path := "/user/list"
 	t.Logf("GET %s", path)
-	res, err := http.Get(ts.URL + path)
+	res, err := http.DefaultClient.Do(authorizedRequest(t, "GET", path, nil, "adminID", true))
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -342,6 +479,11 @@ func TestListupUserHandlerOK(t *testing.T) {
 				ID:   "deleteID",
 				Name: "deleteuser",
 			},
+			model.User{
+				ID:      "adminID",
+				Name:    "adminuser",
+				IsAdmin: true,
+			},
 		},
 	}
 	var listupUserResponse model.ListupUserResponse
@@ -351,45 +493,79 @@ func TestListupUserHandlerOK(t *testing.T) {
 	}
 	sort.Sort(expected.Users)
 	sort.Sort(listupUserResponse.Users)
+	if len(listupUserResponse.Users) != len(expected.Users) {
+		t.Errorf("%d users are expected, but %d", len(expected.Users), len(listupUserResponse.Users))
+		return
+	}
 	for i, user := range listupUserResponse.Users {
 		if user != expected.Users[i] {
-			t.Errorf("%s != %s", user, expected.Users[i])
+			t.Errorf("%v != %v", user, expected.Users[i])
 			return
 		}
 	}
 }
 
-func TestAuthHandlerOK(t *testing.T) {
-	// testprepare
-	keymgr.Init("./test/jwtRS256.key", "./test/jwtRS256.key.pub")
-	// preparation
-	var usrSvc service.UserService
-	tx, err := db.BeginTx()
-	if err != nil {
-		t.Errorf("%s", err)
+func TestListupUserHandlerQuery(t *testing.T) {
+	// listUsers returns the status code and the response
+	listUsers := func(query string) (int, model.ListupUserResponse) {
+		res, err := http.DefaultClient.Do(authorizedRequest(t, "GET", "/user/list?"+query, nil, "adminID", true))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer res.Body.Close()
+		var listupUserResponse model.ListupUserResponse
+		if res.StatusCode == 200 {
+			if err := json.NewDecoder(res.Body).Decode(&listupUserResponse); err != nil {
+				t.Fatalf("%s", err)
+			}
+		}
+		return res.StatusCode, listupUserResponse
+	}
+
+	status, res := listUsers("username_prefix=lookup")
+	if status != 200 || res.Total != 1 || len(res.Users) != 1 || res.Users[0].ID != "lookupID" || res.NextCursor != "" {
+		t.Errorf("unexpected response: %d %v", status, res)
 		return
 	}
-	if err := usrSvc.Create(tx, &db.User{ID: "authID", Name: "authuser", Password: "testpasswd"}); err != nil {
-		t.Errorf("%s", err)
+	if status, _ := listUsers("limit=-1"); status != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %d", status)
 		return
 	}
-	if err := tx.Commit(); err != nil {
-		t.Errorf("%s", err)
+
+	// pages are followed by the cursor
+	status, res = listUsers("sort=-id&limit=1")
+	if status != 200 || len(res.Users) != 1 {
+		t.Errorf("unexpected response: %d %v", status, res)
 		return
 	}
-	defer func() {
-		tx, err := db.BeginTx()
-		if err != nil {
-			t.Errorf("%s", err)
+	total, ids := res.Total, []string{res.Users[0].ID}
+	for res.NextCursor != "" {
+		status, res = listUsers("sort=-id&limit=1&cursor=" + url.QueryEscape(res.NextCursor))
+		if status != 200 || len(res.Users) != 1 {
+			t.Errorf("unexpected response: %d %v", status, res)
 			return
 		}
-		var usrSvc service.UserService
-		// reset
-		if err := usrSvc.Delete(tx, "authID"); err != nil {
-			t.Errorf("%s", err)
+		if res.Users[0].ID >= ids[len(ids)-1] {
+			t.Errorf("%s is listed after %s", res.Users[0].ID, ids[len(ids)-1])
 			return
 		}
-		if err := tx.Commit(); err != nil {
+		ids = append(ids, res.Users[0].ID)
+	}
+	if len(ids) != total {
+		t.Errorf("%d users are expected, but %d", total, len(ids))
+		return
+	}
+}
+
+func TestAuthHandlerOK(t *testing.T) {
+	// preparation
+	if err := usrSvc.Create(&db.User{ID: "authID", Name: "authuser", Password: "testpasswd"}); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer func() {
+		// reset
+		if err := usrSvc.Delete("authID"); err != nil {
 			t.Errorf("%s", err)
 			return
 		}
@@ -428,7 +604,7 @@ func TestAuthHandlerOK(t *testing.T) {
 		t.Errorf("token parse error: %s", err)
 		return
 	}
-	publicKey, err := keymgr.PublicKey()
+	publicKey, err := s.TokenIssuer().Keys.PublicKey()
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -437,8 +613,12 @@ func TestAuthHandlerOK(t *testing.T) {
 		t.Errorf("token validation error: %s", err)
 		return
 	}
-	keymgr.Init("./test/jwtRS256.key", "./test/invalid.key.pub")
-	invalidPubKey, err := keymgr.PublicKey()
+	invalidKeys, err := keymgr.Load("./test/jwtRS256.key", "./test/invalid.key.pub")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	invalidPubKey, err := invalidKeys.PublicKey()
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -447,7 +627,45 @@ func TestAuthHandlerOK(t *testing.T) {
 		t.Errorf("token validation should fail")
 		return
 	}
-	keymgr.Init("./test/jwtRS256.key", "./test/jwtRS256.key.pub")
+}
+
+func TestAuthHandlerRehash(t *testing.T) {
+	// legacy sha512 hash of "testpasswd" salted with "rehashIDrehashuser"
+	legacy := db.User{
+		ID:       "rehashID",
+		Name:     "rehashuser",
+		Password: "2a612956564f985fd49fdbbb1b889f6fcdfaedc27b1e00fb05fc23160152dc92c1213ae97cb3468ec71d6c3412dfcb8eadc5511e4cf396177ec1d2bf53e49231",
+	}
+	if err := usrSvc.Store.CreateUser(&legacy); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer usrSvc.Delete("rehashID")
+
+	requestBody := bytes.Buffer{}
+	requestBody.WriteString(`{"id": "rehashID", "password": "testpasswd"}`)
+	res, err := http.Post(ts.URL+"/auth", "application/json", &requestBody)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	u, err := usrSvc.Store.LookupUser("rehashID")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !strings.HasPrefix(u.Password, "$"+utils.PasswordAlgorithm()+"$") {
+		t.Errorf("password should be rehashed with %s, but %s", utils.PasswordAlgorithm(), u.Password)
+		return
+	}
+	if ok, needsRehash, err := utils.VerifyPassword(u.Password, "testpasswd", ""); err != nil || !ok || needsRehash {
+		t.Errorf("rehashed password is not valid: %t %t %v", ok, needsRehash, err)
+		return
+	}
 }
 
 func TestAuthHandlerNotValid(t *testing.T) {
@@ -506,6 +724,110 @@ func TestGetAlgorithmHandlerOK(t *testing.T) {
 	}
 }
 
+func TestSigningAlgorithms(t *testing.T) {
+	tokens := s.TokenIssuer()
+	defer func(keys *keymgr.KeyManager) { tokens.Keys = keys }(tokens.Keys)
+	cases := []struct {
+		algorithm string
+		key       string
+		kty       string
+		crv       string
+		size      int // of signatures
+	}{
+		{"ES256", "./test/jwtES256.key", "EC", "P-256", 64},
+		{"EdDSA", "./test/jwtEdDSA.key", "OKP", "Ed25519", 64},
+	}
+	for _, c := range cases {
+		keys, err := keymgr.Load(c.key, c.key+".pub")
+		if err != nil {
+			t.Errorf("%s: %s", c.algorithm, err)
+			return
+		}
+		tokens.Keys = keys
+		res, err := http.Get(ts.URL + "/algorithm")
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		var gares model.GetAlgorithmResponse
+		if err := json.NewDecoder(res.Body).Decode(&gares); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if gares.Algorithm != c.algorithm {
+			t.Errorf("%s != %s", gares.Algorithm, c.algorithm)
+			return
+		}
+		res, err = http.Get(ts.URL + "/key")
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		var gkres model.GetKeyResponse
+		if err := json.NewDecoder(res.Body).Decode(&gkres); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		pubkeyBytes, err := ioutil.ReadFile(c.key + ".pub")
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if gkres.PublicKey != string(pubkeyBytes) || gkres.Algorithm != c.algorithm {
+			t.Errorf("%s: unexpected key: %v", c.algorithm, gkres)
+			return
+		}
+		jwks, err := tokens.Keys.JWKs()
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if jwk := jwks[0]; jwk.Kty != c.kty || jwk.Crv != c.crv || jwk.Alg != c.algorithm || jwk.Kid != gkres.KeyID {
+			t.Errorf("%s: unexpected JWK: %v", c.algorithm, jwk)
+			return
+		}
+
+		res, err = http.Post(ts.URL+"/auth", "application/json", strings.NewReader(`{"id": "lookupID", "password": "testpasswd"}`))
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		var authResponse model.AuthResponse
+		if err := json.NewDecoder(res.Body).Decode(&authResponse); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		parts := strings.Split(authResponse.Token, ".")
+		if len(parts) != 3 {
+			t.Errorf("%s: malformed token: %s", c.algorithm, authResponse.Token)
+			return
+		}
+		if sig, _ := base64.RawURLEncoding.DecodeString(parts[2]); len(sig) != c.size {
+			t.Errorf("%s: %d bytes signature is expected, but %d", c.algorithm, c.size, len(sig))
+			return
+		}
+		token, err := jws.ParseJWT([]byte(authResponse.Token))
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if alg := token.(jws.JWS).Protected().Get("alg"); alg != c.algorithm {
+			t.Errorf("%v != %s", alg, c.algorithm)
+			return
+		}
+		if !verify(t, authResponse.Token) {
+			t.Errorf("%s: token is rejected", c.algorithm)
+			return
+		}
+		// a token claiming another algorithm is not accepted with the key
+		forged := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"`+gkres.KeyID+`","typ":"JWT"}`)) + "." + parts[1] + "." + parts[2]
+		if verify(t, forged) {
+			t.Errorf("%s: token of another algorithm is accepted", c.algorithm)
+			return
+		}
+	}
+}
+
 func TestGetAlgorithmHandlerMethodNotAllowed(t *testing.T) {
 	requestBody := bytes.Buffer{}
 	path := "/algorithm"
@@ -526,7 +848,7 @@ func TestGetAlgorithmHandlerMethodNotAllowed(t *testing.T) {
 func TestVerifyHandlerOK(t *testing.T) {
 	path := "/verify"
 	t.Logf("GET %s", path)
-	token, err := utils.GenerateToken("testuser", false)
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "testID", Username: "testuser"})
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -552,7 +874,7 @@ func TestVerifyHandlerOK(t *testing.T) {
 		return
 	}
 	if !veres.Status {
-		t.Errorf("true is expected, but %s", veres.Status)
+		t.Errorf("true is expected, but %t", veres.Status)
 		return
 	}
 }
@@ -574,8 +896,15 @@ func TestGetKeyHandlerOK(t *testing.T) {
 		t.Errorf("%s", err)
 		return
 	}
+	kid, err := s.TokenIssuer().Keys.KeyID()
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
 	expected := model.GetKeyResponse{
 		PublicKey: string(pubkeyBytes),
+		Algorithm: "RS256",
+		KeyID:     kid,
 	}
 	if gkres != expected {
 		t.Errorf(`"%s" != "%s"`, gkres, expected)
@@ -583,3 +912,919 @@ func TestGetKeyHandlerOK(t *testing.T) {
 	}
 
 }
+
+func TestRefreshTokenHandler(t *testing.T) {
+	if err := usrSvc.Create(&db.User{ID: "refreshID", Name: "refreshuser", Password: "testpasswd"}); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer usrSvc.Delete("refreshID")
+
+	requestBody := bytes.Buffer{}
+	requestBody.WriteString(`{"id": "refreshID", "password": "testpasswd"}`)
+	var res *http.Response
+	var err error
+	logged := captureLog(func() {
+		res, err = http.Post(ts.URL+"/auth", "application/json", &requestBody)
+	})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var authResponse model.AuthResponse
+	if err := json.NewDecoder(res.Body).Decode(&authResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if authResponse.RefreshToken == "" {
+		t.Errorf("refresh token is expected")
+		return
+	}
+	// tokens in response bodies are not logged
+	if strings.Contains(logged, authResponse.RefreshToken) || strings.Contains(logged, authResponse.Token) {
+		t.Errorf("tokens are logged: %s", logged)
+		return
+	}
+
+	refresh := func(token string) (*http.Response, model.RefreshTokenResponse) {
+		var refreshTokenResponse model.RefreshTokenResponse
+		requestBody := bytes.Buffer{}
+		json.NewEncoder(&requestBody).Encode(model.RefreshTokenRequest{RefreshToken: token})
+		res, err := http.Post(ts.URL+"/token/refresh", "application/json", &requestBody)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&refreshTokenResponse)
+		return res, refreshTokenResponse
+	}
+
+	res, rotated := refresh(authResponse.RefreshToken)
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	if rotated.Token == "" || rotated.RefreshToken == "" || rotated.RefreshToken == authResponse.RefreshToken {
+		t.Errorf("rotated tokens are expected: %v", rotated)
+		return
+	}
+	// reuse of the rotated token revokes the whole family
+	res, _ = refresh(authResponse.RefreshToken)
+	if res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+	res, _ = refresh(rotated.RefreshToken)
+	if res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+	res, _ = refresh("unknown")
+	if res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+}
+
+func TestAuthHandlerAudience(t *testing.T) {
+	auth := func(body string) (*http.Response, model.AuthResponse) {
+		var authResponse model.AuthResponse
+		res, err := http.Post(ts.URL+"/auth", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&authResponse)
+		return res, authResponse
+	}
+
+	res, _ := auth(`{"id": "lookupID", "password": "testpasswd", "audience": "unknown"}`)
+	if res.StatusCode != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %s", res.Status)
+		return
+	}
+
+	res, authResponse := auth(`{"id": "lookupID", "password": "testpasswd", "audience": "admin-ui"}`)
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	if authResponse.ExpiresIn != int64((5 * time.Minute).Seconds()) {
+		t.Errorf("%d != 300", authResponse.ExpiresIn)
+		return
+	}
+	token, err := jws.ParseJWT([]byte(authResponse.Token))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	iss, _ := token.Claims().Issuer()
+	aud, _ := token.Claims().Audience()
+	if _, ok := token.Claims().NotBefore(); !ok || iss != s.TokenIssuer().Issuer || len(aud) != 1 || aud[0] != "admin-ui" {
+		t.Errorf("unexpected claims: %v", token.Claims())
+		return
+	}
+	if verify(t, authResponse.Token) {
+		t.Errorf("token for admin-ui is accepted by the default audience")
+		return
+	}
+	if !verifyAudience(t, authResponse.Token, "admin-ui") {
+		t.Errorf("token for admin-ui is rejected")
+		return
+	}
+
+	// the audience is kept through refresh
+	requestBody := bytes.Buffer{}
+	json.NewEncoder(&requestBody).Encode(model.RefreshTokenRequest{RefreshToken: authResponse.RefreshToken})
+	res, err = http.Post(ts.URL+"/token/refresh", "application/json", &requestBody)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var refreshTokenResponse model.RefreshTokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&refreshTokenResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if refreshTokenResponse.ExpiresIn != authResponse.ExpiresIn || !verifyAudience(t, refreshTokenResponse.Token, "admin-ui") {
+		t.Errorf("refreshed token is not for admin-ui: %v", refreshTokenResponse)
+		return
+	}
+}
+
+func TestAuthorizeAudience(t *testing.T) {
+	generate := func(sub *utils.TokenSubject) string {
+		token, err := s.TokenIssuer().GenerateToken(sub)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return token
+	}
+	clientToken, err := s.TokenIssuer().GenerateClientToken("someclient", "")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	for _, c := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"default audience", generate(&utils.TokenSubject{ID: "adminID", Username: "adminuser", IsAdmin: true}), 200},
+		{"other audience", generate(&utils.TokenSubject{ID: "adminID", Username: "adminuser", IsAdmin: true, Audience: "admin-ui"}), 401},
+		{"OAuth client", generate(&utils.TokenSubject{ID: "adminID", Username: "adminuser", ClientID: "someclient"}), 401},
+		{"client credentials", clientToken, 401},
+		{"OAuth client for default audience", generate(&utils.TokenSubject{ID: "adminID", Username: "adminuser", ClientID: "someclient", Audience: s.TokenIssuer().Audience}), 403},
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+"/user/list", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if res.StatusCode != c.status {
+			t.Errorf("%s: status %d is expected, but %s", c.name, c.status, res.Status)
+			return
+		}
+	}
+}
+
+func TestServersHaveOwnTokenIssuer(t *testing.T) {
+	other := newServer(t, db.NewMemoryStore(), map[string]interface{}{
+		"key.private_key": "./test/jwtES256.key",
+		"key.public_key":  "./test/jwtES256.key.pub",
+		"token.issuer":    "https://other.example.com",
+		"token.audience":  "other",
+	})
+	// creating another server does not change settings of the existing one
+	tokens := s.TokenIssuer()
+	if tokens.Issuer == "https://other.example.com" || tokens.Audience == "other" {
+		t.Errorf("settings of the other server are shared: %s %s", tokens.Issuer, tokens.Audience)
+		return
+	}
+	if algorithm, _ := tokens.Keys.Algorithm(); algorithm != "RS256" {
+		t.Errorf("keys of the other server are shared: %s", algorithm)
+		return
+	}
+	token, err := other.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "lookupID", Username: "lookupuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if verify(t, token) {
+		t.Errorf("token of the other server is accepted")
+		return
+	}
+}
+
+func TestVerifyHandlerClockSkew(t *testing.T) {
+	issuer := s.TokenIssuer().Issuer
+	defer func() { s.TokenIssuer().Issuer = issuer }()
+	s.TokenIssuer().Issuer = "https://other.example.com"
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "lookupID", Username: "lookupuser"})
+	s.TokenIssuer().Issuer = issuer
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if verify(t, token) {
+		t.Errorf("token of another issuer is accepted")
+		return
+	}
+
+	lifetime := s.TokenIssuer().AccessTokenLifetime
+	defer func() { s.TokenIssuer().AccessTokenLifetime = lifetime }()
+	s.TokenIssuer().AccessTokenLifetime = -10 * time.Second
+	expired, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "lookupID", Username: "lookupuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	// expired within the tolerated skew
+	if !verify(t, expired) {
+		t.Errorf("token expired within clock skew is rejected")
+		return
+	}
+	s.TokenIssuer().AccessTokenLifetime = -s.TokenIssuer().ClockSkew - time.Minute
+	expired, err = s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "lookupID", Username: "lookupuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if verify(t, expired) {
+		t.Errorf("expired token is accepted")
+		return
+	}
+}
+
+func TestLogoutHandlerOK(t *testing.T) {
+	requestBody := bytes.Buffer{}
+	requestBody.WriteString(`{"id": "lookupID", "password": "testpasswd"}`)
+	res, err := http.Post(ts.URL+"/auth", "application/json", &requestBody)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var authResponse model.AuthResponse
+	if err := json.NewDecoder(res.Body).Decode(&authResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	other, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "lookupID", Username: "lookupuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !verify(t, authResponse.Token) {
+		t.Errorf("token should be valid before logout")
+		return
+	}
+
+	requestBody.Reset()
+	json.NewEncoder(&requestBody).Encode(model.LogoutRequest{RefreshToken: authResponse.RefreshToken})
+	req, err := http.NewRequest("POST", ts.URL+"/logout", &requestBody)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	req.Header.Add("Authorization", "Bearer "+authResponse.Token)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	if verify(t, authResponse.Token) {
+		t.Errorf("token should be revoked after logout")
+		return
+	}
+	// other sessions are kept
+	if !verify(t, other) {
+		t.Errorf("other token should not be revoked")
+		return
+	}
+	requestBody.Reset()
+	json.NewEncoder(&requestBody).Encode(model.RefreshTokenRequest{RefreshToken: authResponse.RefreshToken})
+	res, err = http.Post(ts.URL+"/token/refresh", "application/json", &requestBody)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "revokeID", Username: "revokeuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !verify(t, token) {
+		t.Errorf("token should be valid")
+		return
+	}
+	if err := tokenSvc.RevokeUser("revokeID"); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if verify(t, token) {
+		t.Errorf("token issued before revocation should be invalid")
+		return
+	}
+	time.Sleep(2 * time.Millisecond)
+	token, err = s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "revokeID", Username: "revokeuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !verify(t, token) {
+		t.Errorf("token issued after revocation should be valid")
+		return
+	}
+}
+
+func TestJWKSetHandlerOK(t *testing.T) {
+	res, err := http.Get(ts.URL + "/.well-known/jwks.json")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	var jwks model.JWKSetResponse
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(jwks.Keys) != 1 {
+		t.Errorf("1 key is expected, but %d", len(jwks.Keys))
+		return
+	}
+	jwk := jwks.Keys[0]
+	if jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.Use != "sig" || jwk.Kid == "" {
+		t.Errorf("unexpected JWK: %v", jwk)
+		return
+	}
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	publicKey, err := s.TokenIssuer().Keys.PublicKey()
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if new(big.Int).SetBytes(n).Cmp(publicKey.(*rsa.PublicKey).N) != 0 {
+		t.Errorf("modulus does not match the public key")
+		return
+	}
+
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "testID", Username: "testuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	jwt, err := jws.ParseJWT([]byte(token))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if kid := jwt.(jws.JWS).Protected().Get("kid"); kid != jwk.Kid {
+		t.Errorf("%v != %s", kid, jwk.Kid)
+		return
+	}
+}
+
+func verify(t *testing.T, token string) bool {
+	return verifyAudience(t, token, "")
+}
+
+// verifyAudience verifies the token for the audience, or the default audience if empty
+func verifyAudience(t *testing.T, token, audience string) bool {
+	return verifyAt(t, ts.URL, token, audience)
+}
+
+// verifyAt verifies the token at the server of the URL
+func verifyAt(t *testing.T, serverURL, token, audience string) bool {
+	req, err := http.NewRequest("GET", serverURL+"/verify?audience="+url.QueryEscape(audience), nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer res.Body.Close()
+	var veres model.VerifyResponse
+	if err := json.NewDecoder(res.Body).Decode(&veres); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return veres.Status
+}
+
+func TestKeyRetention(t *testing.T) {
+	other := newServer(t, db.NewMemoryStore(), map[string]interface{}{
+		"token.clock_skew":                  "1m",
+		"email.verification_token_lifetime": "1h",
+		"token.audience_lifetimes": []interface{}{
+			map[string]interface{}{"audience": "batch", "lifetime": "72h"},
+		},
+	})
+	// the longest lifetime is of the audience
+	if retention := other.TokenIssuer().Keys.Retention; retention != 72*time.Hour+time.Minute {
+		t.Errorf("%s != 72h1m", retention)
+		return
+	}
+}
+
+func TestRotateKeyHandlerOK(t *testing.T) {
+	// rotated on another server not to affect other tests
+	store := db.NewMemoryStore()
+	other := newServer(t, store, nil)
+	ots := httptest.NewServer(other)
+	defer ots.Close()
+	// a replica running since before rotation
+	replica := newServer(t, store, nil)
+
+	oldKeyID, _ := other.TokenIssuer().Keys.KeyID()
+	oldToken, err := other.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "testID", Username: "testuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+
+	rotate := func(body string, id string, isAdmin bool) *http.Response {
+		req, err := http.NewRequest("POST", ots.URL+"/key/rotate", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		token, err := other.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: id, Username: id, IsAdmin: isAdmin})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return res
+	}
+	if res := rotate("", "lookupID", false); res.StatusCode != 403 {
+		t.Errorf("status 403 Forbidden is expected, but %s", res.Status)
+		return
+	}
+	if res := rotate(`{"algorithm": "HS256"}`, "adminID", true); res.StatusCode != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %s", res.Status)
+		return
+	}
+	// rotation switches the algorithm on request
+	res := rotate(`{"algorithm": "ES256"}`, "adminID", true)
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	var rotateKeyResponse model.RotateKeyResponse
+	if err := json.NewDecoder(res.Body).Decode(&rotateKeyResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if rotateKeyResponse.KeyID == "" || rotateKeyResponse.KeyID == oldKeyID {
+		t.Errorf("new key ID is expected, but %s", rotateKeyResponse.KeyID)
+		return
+	}
+	if algorithm, _ := other.TokenIssuer().Keys.Algorithm(); algorithm != "ES256" {
+		t.Errorf("ES256 key is expected, but %s", algorithm)
+		return
+	}
+
+	// both keys are published, and the new one signs tokens
+	jwks, err := other.TokenIssuer().Keys.JWKs()
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(jwks) != 2 || jwks[0].Kid != rotateKeyResponse.KeyID || jwks[1].Kid != oldKeyID {
+		t.Errorf("unexpected key set: %v", jwks)
+		return
+	}
+	newToken, err := other.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "testID", Username: "testuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !verifyAt(t, ots.URL, oldToken, "") {
+		t.Errorf("token signed with retired key should be valid")
+		return
+	}
+	if !verifyAt(t, ots.URL, newToken, "") {
+		t.Errorf("token signed with new key should be valid")
+		return
+	}
+
+	// the key ring is kept in the store, so restarted servers sign with the new key
+	restarted := newServer(t, store, nil)
+	if kid, _ := restarted.TokenIssuer().Keys.KeyID(); kid != rotateKeyResponse.KeyID {
+		t.Errorf("%s != %s", kid, rotateKeyResponse.KeyID)
+		return
+	}
+	// and replicas reload it seeing a token signed with the key
+	time.Sleep(time.Second)
+	for _, server := range []*authapi.Server{restarted, replica} {
+		for _, token := range []string{oldToken, newToken} {
+			parsed, err := utils.ParseToken(token)
+			if err != nil {
+				t.Errorf("%s", err)
+				return
+			}
+			if err := server.TokenIssuer().ValidateToken(parsed); err != nil {
+				t.Errorf("%s", err)
+				return
+			}
+		}
+	}
+	if kid, _ := replica.TokenIssuer().Keys.KeyID(); kid != rotateKeyResponse.KeyID {
+		t.Errorf("%s != %s", kid, rotateKeyResponse.KeyID)
+		return
+	}
+
+	// retired key is unpublished after retention
+	other.TokenIssuer().Keys.SetRetention(0)
+	if verifyAt(t, ots.URL, oldToken, "") {
+		t.Errorf("token signed with expired key should be invalid")
+		return
+	}
+}
+
+func TestRotateKeyHandlerRemoteSigner(t *testing.T) {
+	signer, err := keymgrtest.NewServer("./test/jwtRS256.key", nil, "")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer signer.Close()
+	other := newServer(t, db.NewMemoryStore(), map[string]interface{}{
+		"key.signer_url": signer.URL,
+	})
+	ots := httptest.NewServer(other)
+	defer ots.Close()
+
+	req, err := http.NewRequest("POST", ots.URL+"/key/rotate", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	token, err := other.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "adminID", Username: "adminuser", IsAdmin: true})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	// the key is rotated by the signer, which this API cannot change
+	if res.StatusCode != 409 {
+		t.Errorf("status 409 Conflict is expected, but %s", res.Status)
+		return
+	}
+}
+
+func TestAuthorizationMiddleware(t *testing.T) {
+	cases := []struct {
+		method  string
+		path    string
+		id      string
+		isAdmin bool
+		status  int
+	}{
+		{"GET", "/user/list", "", false, 401},
+		{"GET", "/user/list", "lookupID", false, 403},
+		{"GET", "/user/lookupID", "", false, 401},
+		{"GET", "/user/lookupID", "updateID", false, 403},
+		{"GET", "/user/lookupID", "adminID", true, 200},
+		{"PUT", "/user/lookupID", "updateID", false, 403},
+		{"DELETE", "/user/lookupID", "updateID", false, 403},
+		{"POST", "/logout", "", false, 401},
+	}
+	for _, c := range cases {
+		var req *http.Request
+		if c.id == "" {
+			req, _ = http.NewRequest(c.method, ts.URL+c.path, nil)
+		} else {
+			req = authorizedRequest(t, c.method, c.path, nil, c.id, c.isAdmin)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != c.status {
+			t.Errorf("%s %s by %s: status %d is expected, but %s", c.method, c.path, c.id, c.status, res.Status)
+			return
+		}
+	}
+
+	// malformed and revoked tokens are rejected
+	req, _ := http.NewRequest("GET", ts.URL+"/user/lookupID", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+	req = authorizedRequest(t, "GET", "/user/lookupID", nil, "lookupID", false)
+	token, _ := utils.ParseToken(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	if err := tokenSvc.Revoke(token.Claims()); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+}
+
+func TestRoleHandlers(t *testing.T) {
+	do := func(method, path, body string, id string, isAdmin bool) *http.Response {
+		req := authorizedRequest(t, method, path, strings.NewReader(body), id, isAdmin)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return res
+	}
+	if res := do("POST", "/role", `{"name": "billing", "permissions": ["billing:read"]}`, "lookupID", false); res.StatusCode != 403 {
+		t.Errorf("status 403 Forbidden is expected, but %s", res.Status)
+		return
+	}
+	for _, c := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/role", `{"name": "billing", "description": "billing staff", "permissions": ["billing:read"]}`},
+		{"PUT", "/role/billing/permission/billing:write", ``},
+		{"PUT", "/user/lookupID/role/billing", ``},
+	} {
+		if res := do(c.method, c.path, c.body, "adminID", true); res.StatusCode != 200 {
+			t.Errorf("%s %s: status 200 OK is expected, but %s", c.method, c.path, res.Status)
+			return
+		}
+	}
+	defer do("DELETE", "/role/billing", ``, "adminID", true)
+
+	if res := do("PUT", "/user/lookupID/role/unknown", ``, "adminID", true); res.StatusCode != 404 {
+		t.Errorf("status 404 Not Found is expected, but %s", res.Status)
+		return
+	}
+	res := do("GET", "/user/lookupID/role", ``, "lookupID", false)
+	var userRolesResponse model.UserRolesResponse
+	if err := json.NewDecoder(res.Body).Decode(&userRolesResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	expected := model.UserRolesResponse{Roles: []string{"billing"}, Permissions: []string{"billing:read", "billing:write"}}
+	if !reflect.DeepEqual(userRolesResponse, expected) {
+		t.Errorf("%v != %v", userRolesResponse, expected)
+		return
+	}
+
+	// roles and permissions are embedded in tokens
+	requestBody := bytes.Buffer{}
+	requestBody.WriteString(`{"id": "lookupID", "password": "testpasswd"}`)
+	res, err := http.Post(ts.URL+"/auth", "application/json", &requestBody)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var authResponse model.AuthResponse
+	if err := json.NewDecoder(res.Body).Decode(&authResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	token, err := utils.ParseToken(authResponse.Token)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	permissions, ok := token.Claims().Get("permissions").([]interface{})
+	if !ok || len(permissions) != 2 || permissions[0] != "billing:read" || permissions[1] != "billing:write" {
+		t.Errorf("unexpected permissions claim: %v", token.Claims().Get("permissions"))
+		return
+	}
+	roles, ok := token.Claims().Get("roles").([]interface{})
+	if !ok || len(roles) != 1 || roles[0] != "billing" {
+		t.Errorf("unexpected roles claim: %v", token.Claims().Get("roles"))
+		return
+	}
+}
+
+func TestTOTPHandlers(t *testing.T) {
+	if err := usrSvc.Create(&db.User{ID: "mfaID", Name: "mfauser", Password: "testpasswd"}); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer usrSvc.Delete("mfaID")
+	post := func(path, body string, id string) *http.Response {
+		var req *http.Request
+		if id != "" {
+			req = authorizedRequest(t, "POST", path, strings.NewReader(body), id, false)
+		} else {
+			req, _ = http.NewRequest("POST", ts.URL+path, strings.NewReader(body))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return res
+	}
+	auth := func() model.AuthResponse {
+		var authResponse model.AuthResponse
+		res := post("/auth", `{"id": "mfaID", "password": "testpasswd"}`, "")
+		json.NewDecoder(res.Body).Decode(&authResponse)
+		return authResponse
+	}
+
+	if res := post("/user/mfaID/mfa/totp", ``, "lookupID"); res.StatusCode != 403 {
+		t.Errorf("status 403 Forbidden is expected, but %s", res.Status)
+		return
+	}
+	var res *http.Response
+	logged := captureLog(func() {
+		res = post("/user/mfaID/mfa/totp", ``, "mfaID")
+	})
+	var enrollTOTPResponse model.EnrollTOTPResponse
+	if err := json.NewDecoder(res.Body).Decode(&enrollTOTPResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(enrollTOTPResponse.RecoveryCodes) != 10 || !strings.HasPrefix(enrollTOTPResponse.ProvisioningURI, "otpauth://totp/") {
+		t.Errorf("unexpected enrollment: %v", enrollTOTPResponse)
+		return
+	}
+	if res.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("enrollment should not be cached")
+		return
+	}
+	// the second factor is not logged
+	for _, secret := range append([]string{enrollTOTPResponse.Secret}, enrollTOTPResponse.RecoveryCodes...) {
+		if strings.Contains(logged, secret) {
+			t.Errorf("secret is logged: %s", logged)
+			return
+		}
+	}
+	// not required until confirmed
+	if authResponse := auth(); authResponse.Token == "" {
+		t.Errorf("token is expected before confirmation")
+		return
+	}
+	step := utils.TOTPStep(time.Now())
+	code, _ := utils.TOTPCode(enrollTOTPResponse.Secret, step-1)
+	if res := post("/user/mfaID/mfa/totp/confirm", `{"code": "`+code+`"}`, "mfaID"); res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+
+	authResponse := auth()
+	if authResponse.Token != "" || authResponse.MFAToken == "" {
+		t.Errorf("MFA token is expected: %v", authResponse)
+		return
+	}
+	if verify(t, authResponse.MFAToken) {
+		t.Errorf("MFA token should not be accepted as an access token")
+		return
+	}
+	// code of the step used at confirmation is rejected
+	if res := post("/auth/mfa", `{"mfa_token": "`+authResponse.MFAToken+`", "code": "`+code+`"}`, ""); res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+	code, _ = utils.TOTPCode(enrollTOTPResponse.Secret, step)
+	res = post("/auth/mfa", `{"mfa_token": "`+authResponse.MFAToken+`", "code": "`+code+`"}`, "")
+	if err := json.NewDecoder(res.Body).Decode(&authResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 200 || !verify(t, authResponse.Token) {
+		t.Errorf("valid token is expected, but %s", res.Status)
+		return
+	}
+
+	// recovery codes are used only once
+	recoveryCode := enrollTOTPResponse.RecoveryCodes[0]
+	mfaToken := auth().MFAToken
+	if res := post("/auth/mfa", `{"mfa_token": "`+mfaToken+`", "code": "`+strings.ToUpper(recoveryCode)+`"}`, ""); res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	// so is MFA token
+	if res := post("/auth/mfa", `{"mfa_token": "`+mfaToken+`", "code": "`+enrollTOTPResponse.RecoveryCodes[1]+`"}`, ""); res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+	mfaToken = auth().MFAToken
+	if res := post("/auth/mfa", `{"mfa_token": "`+mfaToken+`", "code": "`+recoveryCode+`"}`, ""); res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+
+	// admin resets second factor
+	res, err := http.DefaultClient.Do(authorizedRequest(t, "DELETE", "/user/mfaID/mfa/totp", nil, "adminID", true))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	if authResponse := auth(); authResponse.Token == "" {
+		t.Errorf("token is expected after reset")
+		return
+	}
+}
+
+func TestAuthHandlerLockout(t *testing.T) {
+	if err := usrSvc.Create(&db.User{ID: "lockID", Name: "lockuser", Password: "testpasswd"}); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer usrSvc.Delete("lockID")
+	defer s.SetLockoutPolicy(service.LockoutPolicy{})
+	auth := func(password string) *http.Response {
+		requestBody := bytes.Buffer{}
+		requestBody.WriteString(`{"id": "lockID", "password": "` + password + `"}`)
+		res, err := http.Post(ts.URL+"/auth", "application/json", &requestBody)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return res
+	}
+
+	s.SetLockoutPolicy(service.LockoutPolicy{MaxFailures: 3, IPMaxFailures: 1000, Duration: time.Hour})
+	for i := 0; i < 3; i++ {
+		if res := auth("hogepasswd"); res.StatusCode != 401 {
+			t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+			return
+		}
+	}
+	res := auth("testpasswd")
+	if res.StatusCode != 423 {
+		t.Errorf("status 423 Locked is expected, but %s", res.Status)
+		return
+	}
+	if res.Header.Get("Retry-After") != "3600" {
+		t.Errorf("Retry-After: 3600 is expected, but %s", res.Header.Get("Retry-After"))
+		return
+	}
+	res, err := http.DefaultClient.Do(authorizedRequest(t, "DELETE", "/user/lockID/lock", nil, "adminID", true))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+	if res := auth("testpasswd"); res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+
+	// client address is throttled regardless of user
+	s.SetLockoutPolicy(service.LockoutPolicy{MaxFailures: 1000, IPMaxFailures: 1, Duration: 100 * time.Millisecond, MaxDuration: 100 * time.Millisecond})
+	auth("hogepasswd")
+	if res := auth("testpasswd"); res.StatusCode != 429 {
+		t.Errorf("status 429 Too Many Requests is expected, but %s", res.Status)
+		return
+	}
+	time.Sleep(150 * time.Millisecond)
+	if res := auth("testpasswd"); res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+}
@@ -4,7 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 func httpJSONWithStatus(w http.ResponseWriter, status int, v interface{}) {
@@ -15,7 +21,8 @@ func httpJSONWithStatus(w http.ResponseWriter, status int, v interface{}) {
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
-	log.Printf("%d %s", status, buf.String())
+	// bodies are not logged, as they carry tokens, secrets and recovery codes
+	log.Printf("%d", status)
 	buf.WriteTo(w)
 }
 
@@ -35,5 +42,33 @@ func httpError(w http.ResponseWriter, status int, message string, err error) {
 	if err != nil {
 		v.Error = err.Error()
 	}
+	log.Printf("%s: %s", message, v.Error)
 	httpJSONWithStatus(w, status, v)
 }
+
+// bearerToken extracts token from `Authorization: Bearer <token>` header
+func bearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New(`Authorization header is required`)
+	}
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", errors.New(`Authorization: Bearer is required`)
+	}
+	return strings.TrimPrefix(authHeader, "Bearer "), nil
+}
+
+// clientIP returns the IP address of the client connecting to the server
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// setRetryAfter sets `Retry-After` header in seconds
+func setRetryAfter(w http.ResponseWriter, until time.Time) {
+	seconds := int64(time.Until(until)/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
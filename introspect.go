@@ -0,0 +1,90 @@
+package authapi
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+// IntrospectHandler is a HTTP handler, which tells the state and the claims of an
+// access token to confidential clients such as API gateways (RFC 7662).
+// The reason why a token is inactive is logged, but not told to the client.
+func (s *Server) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("IntrospectHandler")
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, oauthInvalidRequest, `invalid form request`)
+		return
+	}
+	client, ok := s.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if !client.Confidential {
+		oauthError(w, http.StatusUnauthorized, oauthInvalidClient, `only confidential clients can introspect tokens`)
+		return
+	}
+	raw := r.PostFormValue("token")
+	if raw == "" {
+		oauthError(w, http.StatusBadRequest, oauthInvalidRequest, `token is required`)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	inactive := func(reason string, args ...interface{}) {
+		log.Printf("introspection by %s: token is inactive: "+reason, append([]interface{}{client.ID}, args...)...)
+		httpJSON(w, model.IntrospectResponse{Active: false})
+	}
+	// refresh tokens are opaque and are not introspected
+	token, err := utils.ParseToken(raw)
+	if err != nil {
+		inactive("%s", err)
+		return
+	}
+	if err := s.tokens.ValidateToken(token); err != nil {
+		inactive("%s", err)
+		return
+	}
+	claims := token.Claims()
+	revoked, err := s.tokenSvc.IsRevoked(claims)
+	if err != nil {
+		log.Printf("%s", err)
+		oauthError(w, http.StatusInternalServerError, oauthServerError, ``)
+		return
+	}
+	if revoked {
+		inactive("revoked")
+		return
+	}
+
+	res := model.IntrospectResponse{
+		Active:    true,
+		TokenType: "Bearer",
+	}
+	res.Subject, _ = claims.Subject()
+	res.JWTID, _ = claims.JWTID()
+	if exp, ok := claims.Expiration(); ok {
+		res.Expires = exp.Unix()
+	}
+	if iat, ok := claims.Get("iat").(float64); ok {
+		res.IssuedAt = int64(iat)
+	}
+	if nbf, ok := claims.NotBefore(); ok {
+		res.NotBefore = nbf.Unix()
+	}
+	if aud, ok := claims.Audience(); ok && len(aud) > 0 {
+		res.Audience = aud[0]
+	}
+	res.Issuer, _ = claims.Issuer()
+	res.Scope, _ = claims.Get("scope").(string)
+	res.ClientID, _ = claims.Get("client_id").(string)
+	res.Username, _ = claims.Get("username").(string)
+	res.IsAdmin, _ = claims.Get("is_admin").(bool)
+	httpJSON(w, res)
+}
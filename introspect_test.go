@@ -0,0 +1,107 @@
+package authapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+func TestIntrospectHandler(t *testing.T) {
+	createClient := func(body string) model.CreateClientResponse {
+		req := authorizedRequest(t, "POST", "/client", strings.NewReader(body), "adminID", true)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		var createClientResponse model.CreateClientResponse
+		if err := json.NewDecoder(res.Body).Decode(&createClientResponse); err != nil {
+			t.Fatalf("%s", err)
+		}
+		return createClientResponse
+	}
+	gateway := createClient(`{"name": "Gateway", "confidential": true, "grant_types": ["client_credentials"]}`)
+	public := createClient(`{"name": "SPA", "redirect_uris": ["https://spa.example.com/cb"]}`)
+
+	introspect := func(id, secret, token string) (*http.Response, model.IntrospectResponse) {
+		req, _ := http.NewRequest("POST", ts.URL+"/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(id, secret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		var introspectResponse model.IntrospectResponse
+		json.NewDecoder(res.Body).Decode(&introspectResponse)
+		return res, introspectResponse
+	}
+
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "adminID", Username: "adminuser", IsAdmin: true})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res, _ := introspect(gateway.Client.ID, "wrongsecret", token); res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+	if res, _ := introspect(public.Client.ID, "", token); res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+
+	res, introspectResponse := introspect(gateway.Client.ID, gateway.ClientSecret, token)
+	if res.StatusCode != 200 || !introspectResponse.Active {
+		t.Errorf("active token is expected, but %s %v", res.Status, introspectResponse)
+		return
+	}
+	if introspectResponse.Subject != "adminID" || introspectResponse.Username != "adminuser" || !introspectResponse.IsAdmin || introspectResponse.TokenType != "Bearer" {
+		t.Errorf("unexpected response: %v", introspectResponse)
+		return
+	}
+	if exp := time.Unix(introspectResponse.Expires, 0); exp.Before(time.Now()) || introspectResponse.IssuedAt > introspectResponse.Expires {
+		t.Errorf("unexpected exp and iat: %v", introspectResponse)
+		return
+	}
+
+	clientToken, err := s.TokenIssuer().GenerateClientToken(gateway.Client.ID, "billing:read")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	_, introspectResponse = introspect(gateway.Client.ID, gateway.ClientSecret, clientToken)
+	if !introspectResponse.Active || introspectResponse.ClientID != gateway.Client.ID || introspectResponse.Scope != "billing:read" || introspectResponse.Username != "" {
+		t.Errorf("unexpected response: %v", introspectResponse)
+		return
+	}
+
+	// only active is told for inactive tokens
+	mfaToken, err := s.TokenIssuer().GenerateMFAToken("adminID")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	for _, inactive := range []string{"invalid", mfaToken, token[:len(token)-4] + "AAAA"} {
+		_, introspectResponse = introspect(gateway.Client.ID, gateway.ClientSecret, inactive)
+		if introspectResponse != (model.IntrospectResponse{}) {
+			t.Errorf("inactive token is expected, but %v", introspectResponse)
+			return
+		}
+	}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if _, introspectResponse = introspect(gateway.Client.ID, gateway.ClientSecret, token); introspectResponse.Active {
+		t.Errorf("revoked token is active")
+		return
+	}
+}
@@ -0,0 +1,117 @@
+package keymgr
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// JWK represents a public JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+	Crv string `json:"crv,omitempty"` // EC and OKP
+	X   string `json:"x,omitempty"`   // EC and OKP
+	Y   string `json:"y,omitempty"`   // EC
+}
+
+// NewJWK converts a public key into JWK for signature verification
+func NewJWK(pub crypto.PublicKey) (*JWK, error) {
+	algorithm, err := algorithmOf(pub)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := Thumbprint(pub)
+	if err != nil {
+		return nil, errors.Wrap(err, `computing key ID`)
+	}
+	jwk, err := publicJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Use = "sig"
+	jwk.Alg = algorithm
+	jwk.Kid = kid
+	return jwk, nil
+}
+
+// Thumbprint returns JWK Thumbprint (RFC 7638) of public key,
+// which is used as key ID
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := publicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+	// members must be ordered lexicographically and no whitespace is allowed
+	var required interface{}
+	switch jwk.Kty {
+	case "RSA":
+		required = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		required = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		required = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	encoded, err := json.Marshal(required)
+	if err != nil {
+		return "", errors.Wrap(err, `encoding required members`)
+	}
+	sum := sha256.Sum256(encoded)
+	return b64(sum[:]), nil
+}
+
+// publicJWK returns members of JWK representing the public key itself
+func publicJWK(pub crypto.PublicKey) (*JWK, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			N:   b64(pub.N.Bytes()),
+			E:   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return &JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   b64(pub.X.FillBytes(make([]byte, size))),
+			Y:   b64(pub.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64(pub),
+		}, nil
+	}
+	return nil, errors.Errorf(`unsupported public key %T`, pub)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,111 @@
+package keymgr
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// GenerateKeyFiles generates a key pair of the algorithm and writes it as PEM files,
+// the private key in PKCS#8, encrypted if passphrase is given, and the public key in PKIX form,
+// and returns its key ID.
+// The private key and its directory are accessible only by the owner.
+// Existing files are not overwritten unless overwrite is set.
+func GenerateKeyFiles(private, public, algorithm string, passphrase []byte, overwrite bool) (string, error) {
+	if !overwrite {
+		for _, path := range []string{private, public} {
+			if _, err := os.Stat(path); err == nil {
+				return "", errors.Errorf(`%s already exists`, path)
+			} else if !os.IsNotExist(err) {
+				return "", errors.Wrapf(err, `checking %s`, path)
+			}
+		}
+	}
+	key, err := GenerateKey(algorithm)
+	if err != nil {
+		return "", errors.Wrap(err, `generating key`)
+	}
+	privateType, privateDER, err := encodePrivateKey(key, passphrase)
+	if err != nil {
+		return "", err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", errors.Wrap(err, `encoding public key`)
+	}
+	if err := writePEM(private, privateType, privateDER, 0700, 0600, overwrite); err != nil {
+		return "", err
+	}
+	if err := writePEM(public, "PUBLIC KEY", publicDER, 0755, 0644, overwrite); err != nil {
+		return "", err
+	}
+	return Thumbprint(key.Public())
+}
+
+// MarshalPrivateKey encodes the private key as a PKCS#8 PEM block, encrypted if passphrase is given
+func MarshalPrivateKey(private crypto.Signer, passphrase []byte) ([]byte, error) {
+	blockType, der, err := encodePrivateKey(private, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), nil
+}
+
+// MarshalPublicKey encodes the public key as a PKIX PEM block
+func MarshalPublicKey(public crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, errors.Wrap(err, `encoding public key`)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// encodePrivateKey returns the PEM block type and DER of the private key in PKCS#8,
+// encrypted if passphrase is given
+func encodePrivateKey(private crypto.Signer, passphrase []byte) (string, []byte, error) {
+	var der []byte
+	var err error
+	blockType := "PRIVATE KEY"
+	if len(passphrase) != 0 {
+		blockType = "ENCRYPTED PRIVATE KEY"
+		der, err = EncryptPKCS8PrivateKey(private, passphrase)
+	} else {
+		der, err = x509.MarshalPKCS8PrivateKey(private)
+	}
+	if err != nil {
+		return "", nil, errors.Wrap(err, `encoding private key`)
+	}
+	return blockType, der, nil
+}
+
+// writePEM writes a PEM block into the file, creating its directory if missing
+func writePEM(path, blockType string, der []byte, dirPerm, perm os.FileMode, overwrite bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return errors.Wrap(err, `creating key directory`)
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if overwrite {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, perm)
+	if err != nil {
+		return errors.Wrapf(err, `creating %s`, path)
+	}
+	// permissions of an overwritten file are not changed by OpenFile
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return errors.Wrapf(err, `changing mode of %s`, path)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		return errors.Wrapf(err, `writing %s`, path)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, `writing %s`, path)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package keymgr_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/keymgr"
+)
+
+func TestGenerateKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authapi")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for _, algorithm := range []string{keymgr.AlgorithmRS256, keymgr.AlgorithmES256, keymgr.AlgorithmEdDSA} {
+		private := filepath.Join(dir, algorithm, "pki", "signing.key")
+		public := private + ".pub"
+		kid, err := keymgr.GenerateKeyFiles(private, public, algorithm, nil, false)
+		if err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+			return
+		}
+		for path, perm := range map[string]os.FileMode{private: 0600, public: 0644, filepath.Dir(private): 0700 | os.ModeDir} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Errorf("%s", err)
+				return
+			}
+			// umask may drop bits, but never add them
+			if info.Mode()&^perm != 0 {
+				t.Errorf("%s: %s is broader than %s", path, info.Mode(), perm)
+				return
+			}
+		}
+
+		keys, err := keymgr.Load(private, public)
+		if err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+			return
+		}
+		if loaded, _ := keys.Algorithm(); loaded != algorithm {
+			t.Errorf("%s != %s", loaded, algorithm)
+			return
+		}
+		if loaded, _ := keys.KeyID(); loaded != kid {
+			t.Errorf("%s != %s", loaded, kid)
+			return
+		}
+
+		// existing keys are kept unless overwritten explicitly
+		if _, err := keymgr.GenerateKeyFiles(private, public, algorithm, nil, false); err == nil {
+			t.Errorf("%s: existing key is overwritten", algorithm)
+			return
+		}
+		overwritten, err := keymgr.GenerateKeyFiles(private, public, algorithm, nil, true)
+		if err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+			return
+		}
+		if overwritten == kid {
+			t.Errorf("%s: key is not regenerated", algorithm)
+			return
+		}
+	}
+
+	if _, err := keymgr.GenerateKeyFiles(filepath.Join(dir, "hs256.key"), filepath.Join(dir, "hs256.key.pub"), "HS256", nil, false); err == nil {
+		t.Errorf("unsupported algorithm is accepted")
+		return
+	}
+}
@@ -1,58 +1,404 @@
 package keymgr
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"io/ioutil"
+	"sync"
+	"time"
 
-	"github.com/SermoDigital/jose/crypto"
 	"github.com/pkg/errors"
 )
 
-// RSAKeyManager manages rsa Private Key and Public Key
-type RSAKeyManager struct {
-	PrivateKey *rsa.PrivateKey
-	PublicKey  *rsa.PublicKey
+// supported signing algorithms, which are decided by the type of keys
+const (
+	AlgorithmRS256 = `RS256`
+	AlgorithmES256 = `ES256`
+	AlgorithmEdDSA = `EdDSA`
+)
+
+// Key is a key pair in the key ring.
+// Signer signs with the private key, which may be held by a remote signer,
+// so that the key material does not need to be handed out.
+type Key struct {
+	ID        string
+	Algorithm string
+	Signer    crypto.Signer
+	PublicKey crypto.PublicKey
+	CreatedOn time.Time
+	RetiredOn time.Time // zero unless the key has been replaced
+}
+
+// KeyManager manages Private Keys and Public Keys.
+// It holds an ordered key ring: the active signing key, keys introduced
+// but not promoted yet, and retired keys which are still published to
+// verify tokens until Retention elapses.
+type KeyManager struct {
+	mu        sync.RWMutex
+	active    *Key
+	keys      []*Key // newest first, including active key
+	Retention time.Duration
 }
 
-var _mgr *RSAKeyManager // Global Key Manager
+// DefaultRetention is the period retired keys are kept published unless SetRetention is called.
+// It must be longer than the lifetime of any token signed with them.
+const DefaultRetention = 168 * time.Hour
 
-// Init initialize global keymanager
-func Init(private string, public string) error {
-	bytes, err := ioutil.ReadFile(private)
+// Load returns a key manager of PEM encoded RSA, ECDSA P-256 or Ed25519 key pair files
+func Load(private string, public string) (*KeyManager, error) {
+	signer, err := LoadPrivateKey(private, nil)
 	if err != nil {
-		return errors.Wrap(err, `loading PrivateKey from file`)
+		return nil, err
 	}
-	rsaPrivate, err := crypto.ParseRSAPrivateKeyFromPEM(bytes)
+	publicKey, err := LoadPublicKey(public)
 	if err != nil {
-		return errors.Wrap(err, `loading PrivateKey`)
+		return nil, err
 	}
-	bytes, err = ioutil.ReadFile(public)
+	return NewKeyManager(signer, publicKey)
+}
+
+// NewKeyManager returns a key manager signing with the signer and its public key
+func NewKeyManager(signer crypto.Signer, public crypto.PublicKey) (*KeyManager, error) {
+	key, err := newKey(signer, public)
 	if err != nil {
-		return errors.Wrap(err, `loading PublicKey from file`)
+		return nil, err
 	}
-	rsaPublic, err := crypto.ParseRSAPublicKeyFromPEM(bytes)
+	return &KeyManager{
+		active:    key,
+		keys:      []*Key{key},
+		Retention: DefaultRetention,
+	}, nil
+}
+
+// SetRetention sets the period retired keys are kept published
+func (m *KeyManager) SetRetention(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Retention = d
+}
+
+// LoadPrivateKey loads a PEM encoded private key from the file.
+// passphrase decrypts an encrypted PKCS#8 key.
+func LoadPrivateKey(path string, passphrase []byte) (crypto.Signer, error) {
+	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		return errors.Wrap(err, `loading PublicKey`)
+		return nil, errors.Wrap(err, `loading PrivateKey from file`)
 	}
-	_mgr = &RSAKeyManager{
-		PrivateKey: rsaPrivate,
-		PublicKey:  rsaPublic,
+	signer, err := ParsePrivateKeyWithPassphrase(bytes, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, `loading PrivateKey`)
 	}
-	return nil
+	return signer, nil
 }
 
-// PrivateKey returns private key
-func PrivateKey() (*rsa.PrivateKey, error) {
-	if _mgr == nil {
-		return nil, errors.New(`keymanager has not been initialized`)
+// LoadPublicKey loads a PEM encoded public key from the file
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, `loading PublicKey from file`)
+	}
+	public, err := ParsePublicKey(bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, `loading PublicKey`)
 	}
-	return _mgr.PrivateKey, nil
+	return public, nil
+}
+
+// ParsePrivateKey parses a PEM encoded private key in PKCS#1, SEC 1 or PKCS#8 form
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	return ParsePrivateKeyWithPassphrase(data, nil)
+}
+
+// ParsePrivateKeyWithPassphrase parses a PEM encoded private key,
+// which may be PKCS#8 encrypted with the passphrase
+func ParsePrivateKeyWithPassphrase(data, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(`key must be PEM encoded`)
+	}
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		if len(passphrase) == 0 {
+			return nil, errors.New(`private key is encrypted, but no passphrase is given`)
+		}
+		private, err = DecryptPKCS8PrivateKey(block.Bytes, passphrase)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.Errorf(`unsupported PEM block %s`, block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, `parsing private key`)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf(`unsupported private key %T`, private)
+	}
+	if _, err := algorithmOf(signer.Public()); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// ParsePublicKey parses a PEM encoded public key in PKIX or PKCS#1 form, or a certificate
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(`key must be PEM encoded`)
+	}
+	var public interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			public = cert.PublicKey
+		}
+	default:
+		return nil, errors.Errorf(`unsupported PEM block %s`, block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, `parsing public key`)
+	}
+	if _, err := algorithmOf(public); err != nil {
+		return nil, err
+	}
+	return public, nil
+}
+
+// GenerateKey generates a new private key for the signing algorithm
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, errors.Errorf(`unsupported algorithm %s`, algorithm)
+}
+
+// algorithmOf returns the signing algorithm using the public key
+func algorithmOf(public crypto.PublicKey) (string, error) {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		return AlgorithmRS256, nil
+	case *ecdsa.PublicKey:
+		if public.Curve == elliptic.P256() {
+			return AlgorithmES256, nil
+		}
+		return "", errors.Errorf(`unsupported curve %s`, public.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return AlgorithmEdDSA, nil
+	}
+	return "", errors.Errorf(`unsupported public key %T`, public)
+}
+
+// NewKey returns a key of the key pair, which is identified by the thumbprint of the public key.
+// The signer may be nil for keys which only verify tokens.
+func NewKey(signer crypto.Signer, public crypto.PublicKey) (*Key, error) {
+	return newKey(signer, public)
+}
+
+func newKey(signer crypto.Signer, public crypto.PublicKey) (*Key, error) {
+	algorithm, err := algorithmOf(public)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := Thumbprint(public)
+	if err != nil {
+		return nil, errors.Wrap(err, `computing key ID`)
+	}
+	key := Key{
+		ID:        kid,
+		Algorithm: algorithm,
+		PublicKey: public,
+		CreatedOn: time.Now(),
+	}
+	if signer != nil {
+		key.Signer = opaqueSigner{signer: signer}
+	}
+	return &key, nil
 }
 
 // PublicKey returns public key
-func PublicKey() (*rsa.PublicKey, error) {
-	if _mgr == nil {
-		return nil, errors.New(`keymanager has not been initialized`)
+func (m *KeyManager) PublicKey() (crypto.PublicKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.active.PublicKey, nil
+}
+
+// KeyID returns ID of the signing key
+func (m *KeyManager) KeyID() (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.active.ID, nil
+}
+
+// Algorithm returns signing algorithm of the signing key
+func (m *KeyManager) Algorithm() (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.active.Algorithm, nil
+}
+
+// ActiveKey returns the signing key
+func (m *KeyManager) ActiveKey() (Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return *m.active, nil
+}
+
+// LookupKey returns published key by key ID
+func (m *KeyManager) LookupKey(kid string) (Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.published(time.Now()) {
+		if key.ID == kid {
+			return *key, nil
+		}
+	}
+	return Key{}, errors.Errorf(`key %s is not found`, kid)
+}
+
+// Keys returns all published keys, newest first
+func (m *KeyManager) Keys() ([]Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	published := m.published(time.Now())
+	keys := make([]Key, len(published))
+	for i, key := range published {
+		keys[i] = *key
+	}
+	return keys, nil
+}
+
+// JWKs returns public keys verifying tokens as JWK
+func (m *KeyManager) JWKs() ([]JWK, error) {
+	keys, err := m.Keys()
+	if err != nil {
+		return nil, err
+	}
+	jwks := make([]JWK, len(keys))
+	for i, key := range keys {
+		jwk, err := NewJWK(key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		jwks[i] = *jwk
+	}
+	return jwks, nil
+}
+
+// Introduce adds a new key into the key ring without using it for signing.
+// Publishing the key before promotion lets verifiers refresh their caches.
+func (m *KeyManager) Introduce(private crypto.Signer) (string, error) {
+	key, err := newKey(private, private.Public())
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keys {
+		if k.ID == key.ID {
+			return "", errors.Errorf(`key %s already exists`, key.ID)
+		}
+	}
+	m.keys = append([]*Key{key}, m.keys...)
+	return key.ID, nil
+}
+
+// Promote makes the introduced key the signing key, and retires current one
+func (m *KeyManager) Promote(kid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, key := range m.published(now) {
+		if key.ID != kid {
+			continue
+		}
+		if key == m.active {
+			return nil
+		}
+		if !key.RetiredOn.IsZero() {
+			return errors.Errorf(`key %s has been retired`, kid)
+		}
+		m.active.RetiredOn = now
+		m.active = key
+		m.keys = m.published(now)
+		return nil
+	}
+	return errors.Errorf(`key %s is not found`, kid)
+}
+
+// Replace replaces the key ring by the keys, the newest first.
+// Exactly one of them must not be retired, which becomes the signing key.
+func (m *KeyManager) Replace(keys []Key) error {
+	ring := make([]*Key, len(keys))
+	var active *Key
+	for i := range keys {
+		key := keys[i]
+		ring[i] = &key
+		if !key.RetiredOn.IsZero() {
+			continue
+		}
+		if active != nil {
+			return errors.Errorf(`keys %s and %s are both active`, active.ID, key.ID)
+		}
+		if key.Signer == nil {
+			return errors.Errorf(`key %s has no signer`, key.ID)
+		}
+		active = ring[i]
+	}
+	if active == nil {
+		return errors.New(`no active key`)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active = active
+	m.keys = ring
+	return nil
+}
+
+// Rotate introduces given key and promotes it immediately
+func (m *KeyManager) Rotate(private crypto.Signer) (string, error) {
+	kid, err := m.Introduce(private)
+	if err != nil {
+		return "", err
+	}
+	if err := m.Promote(kid); err != nil {
+		return "", err
+	}
+	return kid, nil
+}
+
+// published returns keys except retired ones which outlived retention.
+// The caller must hold the lock.
+func (m *KeyManager) published(now time.Time) []*Key {
+	keys := make([]*Key, 0, len(m.keys))
+	for _, key := range m.keys {
+		if !key.RetiredOn.IsZero() && now.After(key.RetiredOn.Add(m.Retention)) {
+			continue
+		}
+		keys = append(keys, key)
 	}
-	return _mgr.PublicKey, nil
+	return keys
 }
@@ -0,0 +1,96 @@
+// Package keymgrtest provides a fake of the remote signer for tests,
+// which signs with a local private key file.
+package keymgrtest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/charakoba-com/auth-api/keymgr"
+)
+
+// hashes are hash names of RemoteSignRequest, which are given by crypto.Hash.String
+var hashes = map[string]crypto.Hash{
+	"":        crypto.Hash(0),
+	"SHA-256": crypto.SHA256,
+}
+
+// Handler serves the remote signer protocol of keymgr.RemoteSigner with signer.
+// Requests without `Authorization: Bearer <token>` are rejected unless token is empty.
+func Handler(signer crypto.Signer, token string) (http.Handler, error) {
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	public := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	mux := http.NewServeMux()
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if !authorized(w, r) {
+			return
+		}
+		json.NewEncoder(w).Encode(keymgr.RemotePublicKeyResponse{PublicKey: public})
+	})
+	mux.HandleFunc("/sign", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(w, r) {
+			return
+		}
+		var req keymgr.RemoteSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json request", http.StatusBadRequest)
+			return
+		}
+		digest, err := base64.StdEncoding.DecodeString(req.Digest)
+		if err != nil {
+			http.Error(w, "invalid digest", http.StatusBadRequest)
+			return
+		}
+		hash, ok := hashes[req.Hash]
+		if !ok {
+			http.Error(w, "unsupported hash", http.StatusBadRequest)
+			return
+		}
+		sig, err := signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(keymgr.RemoteSignResponse{Signature: base64.StdEncoding.EncodeToString(sig)})
+	})
+	return mux, nil
+}
+
+// NewServer starts a fake remote signer signing with the PEM encoded private key file.
+// The caller should call Close when finished.
+func NewServer(privateKey string, passphrase []byte, token string) (*httptest.Server, error) {
+	signer, err := keymgr.LoadPrivateKey(privateKey, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	h, err := Handler(signer, token)
+	if err != nil {
+		return nil, err
+	}
+	return httptest.NewServer(h), nil
+}
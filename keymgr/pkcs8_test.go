@@ -0,0 +1,59 @@
+package keymgr_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/keymgr"
+	"github.com/pkg/errors"
+)
+
+func TestParseEncryptedPrivateKey(t *testing.T) {
+	// encrypted by `openssl pkcs8 -topk8 -v2 aes-256-cbc`
+	encrypted, err := ioutil.ReadFile("../test/jwtES256.enc.key")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	plain, err := keymgr.LoadPrivateKey("../test/jwtES256.key", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	signer, err := keymgr.ParsePrivateKeyWithPassphrase(encrypted, []byte("testpassphrase"))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !signer.(*ecdsa.PrivateKey).Equal(plain) {
+		t.Errorf("decrypted key does not match")
+		return
+	}
+	if _, err := keymgr.ParsePrivateKeyWithPassphrase(encrypted, []byte("wrongpassphrase")); errors.Cause(err) != keymgr.ErrPassphrase {
+		t.Errorf("wrong passphrase is not reported: %v", err)
+		return
+	}
+	if _, err := keymgr.ParsePrivateKey(encrypted); err == nil {
+		t.Errorf("encrypted key is parsed without passphrase")
+		return
+	}
+
+	// round trip
+	der, err := keymgr.EncryptPKCS8PrivateKey(plain, []byte("anotherpassphrase"))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	reencrypted := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der})
+	signer, err = keymgr.ParsePrivateKeyWithPassphrase(reencrypted, []byte("anotherpassphrase"))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !signer.(*ecdsa.PrivateKey).Equal(plain) {
+		t.Errorf("decrypted key does not match")
+		return
+	}
+}
@@ -0,0 +1,164 @@
+package keymgr
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSignerTimeout limits requests to the remote signer made by a client given as nil
+const DefaultSignerTimeout = 5 * time.Second
+
+// ContextSigner is a crypto.Signer whose signing can be canceled with a context,
+// such as RemoteSigner
+type ContextSigner interface {
+	crypto.Signer
+	SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// opaqueSigner hides the private key behind crypto.Signer,
+// so that holders of a Key cannot reach the key material by type assertion.
+// It signs with ctx if the signer is a ContextSigner.
+type opaqueSigner struct {
+	signer crypto.Signer
+	ctx    context.Context
+}
+
+func (s opaqueSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if signer, ok := s.signer.(ContextSigner); ok && s.ctx != nil {
+		return signer.SignContext(s.ctx, rand, digest, opts)
+	}
+	return s.signer.Sign(rand, digest, opts)
+}
+
+// SignerContext returns the signer of the key, which signs with ctx
+// so that a remote signer is not waited for after the request is canceled
+func (k Key) SignerContext(ctx context.Context) crypto.Signer {
+	if s, ok := k.Signer.(opaqueSigner); ok {
+		return opaqueSigner{signer: s.signer, ctx: ctx}
+	}
+	return k.Signer
+}
+
+// RemoteSignRequest is a request body POSTed to `<url>/sign` of a remote signer.
+// Digest is the message itself for Ed25519, whose Hash is empty.
+type RemoteSignRequest struct {
+	Digest string `json:"digest"` // base64
+	Hash   string `json:"hash,omitempty"`
+}
+
+// RemoteSignResponse is a response body of `<url>/sign`.
+// Signature is PKCS#1 v1.5 for RSA, ASN.1 DER for ECDSA and raw for Ed25519 as crypto.Signer returns.
+type RemoteSignResponse struct {
+	Signature string `json:"signature"` // base64
+}
+
+// RemotePublicKeyResponse is a response body of GET `<url>` of a remote signer
+type RemotePublicKeyResponse struct {
+	PublicKey string `json:"public_key"` // PEM encoded
+}
+
+// RemoteSigner is a crypto.Signer whose private key is held by a KMS-style signing service.
+// The service returns the public key at GET `<url>` and signs digests at POST `<url>/sign`,
+// authenticating requests with a bearer token.
+type RemoteSigner struct {
+	url    string
+	token  string
+	client *http.Client
+	public crypto.PublicKey
+}
+
+// NewRemoteSigner returns a signer of the service at url, loading its public key.
+// A client limited by DefaultSignerTimeout is used if client is nil.
+func NewRemoteSigner(url, token string, client *http.Client) (*RemoteSigner, error) {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultSignerTimeout}
+	}
+	s := &RemoteSigner{
+		url:    strings.TrimSuffix(url, "/"),
+		token:  token,
+		client: client,
+	}
+	var res RemotePublicKeyResponse
+	if err := s.do(context.Background(), "GET", s.url, nil, &res); err != nil {
+		return nil, errors.Wrap(err, `loading public key from remote signer`)
+	}
+	public, err := ParsePublicKey([]byte(res.PublicKey))
+	if err != nil {
+		return nil, errors.Wrap(err, `loading public key from remote signer`)
+	}
+	s.public = public
+	return s, nil
+}
+
+// Public returns the public key of the remote key
+func (s *RemoteSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs the digest with the remote key
+func (s *RemoteSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), rand, digest, opts)
+}
+
+// SignContext signs the digest with the remote key, giving up when ctx is done
+func (s *RemoteSigner) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := RemoteSignRequest{Digest: base64.StdEncoding.EncodeToString(digest)}
+	if h := opts.HashFunc(); h != 0 {
+		req.Hash = h.String()
+	}
+	var res RemoteSignResponse
+	if err := s.do(ctx, "POST", s.url+"/sign", req, &res); err != nil {
+		return nil, errors.Wrap(err, `signing with remote signer`)
+	}
+	sig, err := base64.StdEncoding.DecodeString(res.Signature)
+	if err != nil {
+		return nil, errors.Wrap(err, `decoding signature`)
+	}
+	return sig, nil
+}
+
+func (s *RemoteSigner) do(ctx context.Context, method, url string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, `encoding request`)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return errors.Wrap(err, `creating request`)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, `requesting remote signer`)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf(`remote signer responded %s`, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.Wrap(err, `decoding response`)
+	}
+	return nil
+}
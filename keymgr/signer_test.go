@@ -0,0 +1,164 @@
+package keymgr_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SermoDigital/jose/jws"
+	"github.com/charakoba-com/auth-api/keymgr"
+	"github.com/charakoba-com/auth-api/keymgr/keymgrtest"
+)
+
+func TestRemoteSigner(t *testing.T) {
+	cases := []struct {
+		algorithm string
+		key       string
+	}{
+		{keymgr.AlgorithmRS256, "../test/jwtRS256.key"},
+		{keymgr.AlgorithmES256, "../test/jwtES256.key"},
+		{keymgr.AlgorithmEdDSA, "../test/jwtEdDSA.key"},
+	}
+	for _, c := range cases {
+		server, err := keymgrtest.NewServer(c.key, nil, "signertoken")
+		if err != nil {
+			t.Errorf("%s: %s", c.algorithm, err)
+			return
+		}
+		defer server.Close()
+
+		if _, err := keymgr.NewRemoteSigner(server.URL, "wrongtoken", nil); err == nil {
+			t.Errorf("%s: wrong token is accepted", c.algorithm)
+			return
+		}
+		signer, err := keymgr.NewRemoteSigner(server.URL, "signertoken", nil)
+		if err != nil {
+			t.Errorf("%s: %s", c.algorithm, err)
+			return
+		}
+		public, err := keymgr.LoadPublicKey(c.key + ".pub")
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if !signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(public) {
+			t.Errorf("%s: public key does not match", c.algorithm)
+			return
+		}
+
+		keys, err := keymgr.NewKeyManager(signer, signer.Public())
+		if err != nil {
+			t.Errorf("%s: %s", c.algorithm, err)
+			return
+		}
+		key, err := keys.ActiveKey()
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if key.Algorithm != c.algorithm {
+			t.Errorf("%s != %s", key.Algorithm, c.algorithm)
+			return
+		}
+		claims := jws.Claims{}
+		claims.SetSubject("testID")
+		token, err := jws.NewJWT(claims, keymgr.SigningMethod(key.Algorithm)).Serialize(key.Signer)
+		if err != nil {
+			t.Errorf("%s: %s", c.algorithm, err)
+			return
+		}
+		parsed, err := jws.ParseJWT(token)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if err := parsed.Validate(public, keymgr.SigningMethod(c.algorithm)); err != nil {
+			t.Errorf("%s: %s", c.algorithm, err)
+			return
+		}
+	}
+}
+
+func TestKeySignerIsOpaque(t *testing.T) {
+	keys, err := keymgr.Load("../test/jwtRS256.key", "../test/jwtRS256.key.pub")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	key, err := keys.ActiveKey()
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	switch key.Signer.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		t.Errorf("key material is exposed: %T", key.Signer)
+		return
+	}
+}
+
+func TestRemoteSignerTimeout(t *testing.T) {
+	private, err := keymgr.LoadPrivateKey("../test/jwtRS256.key", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	handler, err := keymgrtest.Handler(private, "")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	// the signer hangs until the test ends
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sign" {
+			<-hang
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	signer, err := keymgr.NewRemoteSigner(server.URL, "", &http.Client{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	digest := sha256.Sum256([]byte("message"))
+	start := time.Now()
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+		t.Errorf("hanging signer does not time out")
+		return
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timeout is not applied: %s", elapsed)
+		return
+	}
+
+	// signing is given up with the context of the key
+	keys, err := keymgr.NewKeyManager(signer, signer.Public())
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	key, err := keys.ActiveKey()
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := key.SignerContext(ctx).Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+		t.Errorf("canceled context is not applied")
+		return
+	}
+}
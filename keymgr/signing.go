@@ -0,0 +1,119 @@
+package keymgr
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // Hasher of RS256 and ES256 must be linked
+	_ "crypto/sha512" // Hasher of EdDSA must be linked
+	"encoding/asn1"
+	"math/big"
+
+	josecrypto "github.com/SermoDigital/jose/crypto"
+	"github.com/SermoDigital/jose/jws"
+	"github.com/pkg/errors"
+)
+
+// Signing methods replacing or adding to ones of jose.
+// They sign with crypto.Signer, so that private keys can be held by remote signers.
+// ES256 of jose also encodes signatures in ASN.1 DER, while RFC 7518 requires R || S.
+var (
+	SigningMethodRS256 josecrypto.SigningMethod = &signingMethod{alg: AlgorithmRS256, hash: crypto.SHA256}
+	SigningMethodES256 josecrypto.SigningMethod = &signingMethod{alg: AlgorithmES256, hash: crypto.SHA256}
+	// Ed25519 hashes messages with SHA-512 internally.
+	// Messages are not hashed in advance, but jws requires an available hash.
+	SigningMethodEdDSA josecrypto.SigningMethod = &signingMethod{alg: AlgorithmEdDSA, hash: crypto.SHA512}
+)
+
+// ErrVerification is returned when a signature is not valid
+var ErrVerification = errors.New(`signature verification error`)
+
+// es256Size is the size of R and S in ES256 signatures
+const es256Size = 32
+
+func init() {
+	jws.RemoveSigningMethod(josecrypto.SigningMethodRS256)
+	jws.RemoveSigningMethod(josecrypto.SigningMethodES256)
+	jws.RegisterSigningMethod(SigningMethodRS256)
+	jws.RegisterSigningMethod(SigningMethodES256)
+	jws.RegisterSigningMethod(SigningMethodEdDSA)
+}
+
+// SigningMethod returns the signing method of the algorithm
+func SigningMethod(algorithm string) josecrypto.SigningMethod {
+	return jws.GetSigningMethod(algorithm)
+}
+
+// signingMethod implements RS256, ES256 (RFC 7518) and EdDSA using Ed25519 (RFC 8037)
+type signingMethod struct {
+	alg  string
+	hash crypto.Hash
+}
+
+func (m *signingMethod) Alg() string { return m.alg }
+
+func (m *signingMethod) Hasher() crypto.Hash { return m.hash }
+
+// Sign signs with key, which must be a crypto.Signer of the algorithm
+func (m *signingMethod) Sign(raw []byte, key interface{}) (josecrypto.Signature, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, josecrypto.ErrInvalidKey
+	}
+	if alg, err := algorithmOf(signer.Public()); err != nil || alg != m.alg {
+		return nil, josecrypto.ErrInvalidKey
+	}
+	if m.alg == AlgorithmEdDSA {
+		return signer.Sign(rand.Reader, raw, crypto.Hash(0))
+	}
+	sig, err := signer.Sign(rand.Reader, m.sum(raw), m.hash)
+	if err != nil {
+		return nil, err
+	}
+	if m.alg == AlgorithmES256 {
+		// crypto.Signer of ECDSA returns ASN.1 DER
+		var point struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &point); err != nil {
+			return nil, errors.Wrap(err, `parsing ECDSA signature`)
+		}
+		sig = make([]byte, 2*es256Size)
+		point.R.FillBytes(sig[:es256Size])
+		point.S.FillBytes(sig[es256Size:])
+	}
+	return josecrypto.Signature(sig), nil
+}
+
+// Verify verifies with key, which must be a public key of the algorithm
+func (m *signingMethod) Verify(raw []byte, sig josecrypto.Signature, key interface{}) error {
+	if alg, err := algorithmOf(key); err != nil || alg != m.alg {
+		return josecrypto.ErrInvalidKey
+	}
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, m.hash, m.sum(raw), sig); err != nil {
+			return ErrVerification
+		}
+	case *ecdsa.PublicKey:
+		if len(sig) != 2*es256Size {
+			return ErrVerification
+		}
+		r := new(big.Int).SetBytes(sig[:es256Size])
+		s := new(big.Int).SetBytes(sig[es256Size:])
+		if !ecdsa.Verify(pub, m.sum(raw), r, s) {
+			return ErrVerification
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, raw, sig) {
+			return ErrVerification
+		}
+	}
+	return nil
+}
+
+func (m *signingMethod) sum(raw []byte) []byte {
+	h := m.hash.New()
+	h.Write(raw)
+	return h.Sum(nil)
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileSender writes messages to .eml files in a directory instead of delivering
+// them, for development and tests
+type FileSender struct {
+	Dir string
+	seq uint64
+}
+
+// Send the message
+func (s *FileSender) Send(m *Message) error {
+	log.Printf("mail.FileSender.Send %s", m.To)
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return errors.Wrap(err, `creating mail directory`)
+	}
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102T150405.000000000"), atomic.AddUint64(&s.seq, 1))
+	f, err := os.OpenFile(filepath.Join(s.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, `creating mail file`)
+	}
+	if _, err := m.WriteTo(f); err != nil {
+		f.Close()
+		return errors.Wrap(err, `writing mail file`)
+	}
+	return f.Close()
+}
@@ -0,0 +1,56 @@
+// Package mail delivers messages, such as password reset links, to users
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is a plain text email message
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender is an interface which delivers messages
+type Sender interface {
+	Send(*Message) error
+}
+
+// validate rejects header values which would inject other headers
+func (m *Message) validate() error {
+	for name, value := range map[string]string{"From": m.From, "To": m.To, "Subject": m.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.Errorf(`%s header contains a line break`, name)
+		}
+	}
+	if m.To == "" {
+		return errors.New(`recipient is required`)
+	}
+	return nil
+}
+
+// WriteTo writes the message in RFC 5322 format
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	if err := m.validate(); err != nil {
+		return 0, err
+	}
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	return buf.WriteTo(w)
+}
@@ -0,0 +1,86 @@
+package mail_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/mail"
+)
+
+func TestMessageWriteTo(t *testing.T) {
+	m := mail.Message{From: "authapi@example.com", To: "user@example.com", Subject: "Reset your password", Body: "line1\nline2"}
+	buf := bytes.Buffer{}
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	s := buf.String()
+	for _, expected := range []string{"From: authapi@example.com\r\n", "To: user@example.com\r\n", "Subject: Reset your password\r\n", "\r\n\r\nline1\r\nline2"} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("%q is expected in %q", expected, s)
+			return
+		}
+	}
+
+	m.Subject = "injected\r\nBcc: victim@example.com"
+	if _, err := m.WriteTo(&buf); err == nil {
+		t.Errorf("line breaks in headers should be rejected")
+		return
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	sender := mail.FileSender{Dir: filepath.Join(dir, "outbox")}
+	for i := 0; i < 2; i++ {
+		if err := sender.Send(&mail.Message{To: "user@example.com", Subject: "hello", Body: "body"}); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "outbox", "*.eml"))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(files) != 2 {
+		t.Errorf("2 files are expected, but %v", files)
+		return
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !strings.Contains(string(b), "To: user@example.com\r\n") {
+		t.Errorf("unexpected message: %q", b)
+		return
+	}
+}
+
+func TestMemorySender(t *testing.T) {
+	var sender mail.MemorySender
+	if err := sender.Send(&mail.Message{To: "user@example.com", Subject: "hello", Body: "body"}); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if err := sender.Send(&mail.Message{Subject: "no recipient"}); err == nil {
+		t.Errorf("a message without recipient should be rejected")
+		return
+	}
+	messages := sender.Messages()
+	if len(messages) != 1 || messages[0].To != "user@example.com" || messages[0].Body != "body" {
+		t.Errorf("unexpected messages: %v", messages)
+		return
+	}
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"log"
+	"sync"
+)
+
+// MemorySender keeps messages in memory instead of delivering them, for tests
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send the message
+func (s *MemorySender) Send(m *Message) error {
+	log.Printf("mail.MemorySender.Send %s", m.To)
+
+	if err := m.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, *m)
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := make([]Message, len(s.messages))
+	copy(l, s.messages)
+	return l
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/smtp"
+
+	"github.com/pkg/errors"
+)
+
+// SMTPSender delivers messages through an SMTP server
+type SMTPSender struct {
+	Addr     string // host:port
+	Username string // no authentication if empty
+	Password string
+}
+
+// Send the message
+func (s *SMTPSender) Send(m *Message) error {
+	log.Printf("mail.SMTPSender.Send %s", m.To)
+
+	msg := bytes.Buffer{}
+	if _, err := m.WriteTo(&msg); err != nil {
+		return errors.Wrap(err, `formatting message`)
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return errors.Wrap(err, `parsing SMTP address`)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if err := smtp.SendMail(s.Addr, auth, m.From, []string{m.To}, msg.Bytes()); err != nil {
+		return errors.Wrap(err, `sending message`)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package authapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/gorilla/mux"
+)
+
+type contextKey int
+
+const claimsContextKey contextKey = iota
+
+// accessPolicy reports whether the bearer of claims may access the route
+type accessPolicy func(r *http.Request, claims jwt.Claims) bool
+
+// authenticated allows any valid token
+func authenticated(r *http.Request, claims jwt.Claims) bool {
+	return true
+}
+
+// adminOnly allows tokens of admin users
+func adminOnly(r *http.Request, claims jwt.Claims) bool {
+	isAdmin, _ := claims.Get("is_admin").(bool)
+	return isAdmin
+}
+
+// selfOnly allows tokens whose subject is the `{id}` of the route
+func selfOnly(r *http.Request, claims jwt.Claims) bool {
+	sub, ok := claims.Subject()
+	return ok && sub == mux.Vars(r)["id"]
+}
+
+// selfOrAdmin allows tokens whose subject is the `{id}` of the route, and admin users
+func selfOrAdmin(r *http.Request, claims jwt.Claims) bool {
+	return selfOnly(r, claims) || adminOnly(r, claims)
+}
+
+// authorize wraps h with a middleware, which validates the bearer token,
+// checks the access policy and puts the claims on the request context.
+// Tokens for audiences other than the audience of the server and tokens issued to OAuth clients are rejected.
+func (s *Server) authorize(policy accessPolicy, h http.HandlerFunc) http.Handler {
+	return s.authorizeToken(false, policy, h)
+}
+
+// authorizeClient is authorize accepting tokens issued to OAuth clients as well,
+// for handlers which check the granted scope by themselves
+func (s *Server) authorizeClient(policy accessPolicy, h http.HandlerFunc) http.Handler {
+	return s.authorizeToken(true, policy, h)
+}
+
+func (s *Server) authorizeToken(acceptClients bool, policy accessPolicy, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := bearerToken(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			httpError(w, http.StatusUnauthorized, err.Error(), nil)
+			return
+		}
+		token, err := utils.ParseToken(raw)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			httpError(w, http.StatusUnauthorized, `token is not valid`, nil)
+			return
+		}
+		if err := s.tokens.ValidateToken(token); err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			httpError(w, http.StatusUnauthorized, `token is not valid`, nil)
+			return
+		}
+		claims := token.Claims()
+		revoked, err := s.tokenSvc.IsRevoked(claims)
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+			return
+		}
+		if revoked {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			httpError(w, http.StatusUnauthorized, `token has been revoked`, nil)
+			return
+		}
+		// tokens for other audiences, such as OAuth clients, are not for this API
+		if !acceptClients {
+			if err := utils.ValidateAudience(claims, s.tokens.Audience); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				httpError(w, http.StatusUnauthorized, `token is not issued for this API`, nil)
+				return
+			}
+		}
+		if _, delegated := claims.Get("client_id").(string); delegated && !acceptClients {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			httpError(w, http.StatusForbidden, `token issued to OAuth client is not accepted`, nil)
+			return
+		}
+		if !policy(r, claims) {
+			sub, _ := claims.Subject()
+			log.Printf("access denied for %s", sub)
+			httpError(w, http.StatusForbidden, `no permission`, nil)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+	})
+}
+
+// requestClaims returns claims of the token authorized by the middleware
+func requestClaims(r *http.Request) jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(jwt.Claims)
+	return claims
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"log"
+	"strings"
+
+	"github.com/charakoba-com/auth-api/db"
+)
+
+// FromDB binds db.Client to model.Client
+func (c *Client) FromDB(dc *db.Client) error {
+	log.Printf("model.Client.FromDB")
+	c.ID = dc.ID
+	c.Name = dc.Name
+	c.Confidential = dc.SecretHash != ""
+	c.RedirectURIs = dc.RedirectURIs
+	c.Scopes = strings.Fields(dc.Scope)
+	c.GrantTypes = strings.Fields(dc.GrantTypes)
+	return nil
+}
+
+// ToDB binds model.Client to db.Client. The secret hash is not bound.
+func (c *Client) ToDB(dc *db.Client) error {
+	log.Printf("model.Client.ToDB")
+	dc.ID = c.ID
+	dc.Name = c.Name
+	dc.RedirectURIs = c.RedirectURIs
+	dc.Scope = strings.Join(c.Scopes, " ")
+	dc.GrantTypes = strings.Join(c.GrantTypes, " ")
+	return nil
+}
+
+// AllowsGrantType reports whether the client may use the grant type
+func (c *Client) AllowsGrantType(grantType string) bool {
+	for _, gt := range c.GrantTypes {
+		if gt == grantType {
+			return true
+		}
+	}
+	return false
+}
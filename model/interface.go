@@ -2,11 +2,38 @@ package model
 
 // User represents an user
 type User struct {
-	ID       string `json:"id"`
-	Name     string `json:"username"`
-	Password string `json:"password,omitempty"`
-	IsAdmin  bool   `json:"is_admin"`
+	ID            string `json:"id"`
+	Name          string `json:"username"`
+	Password      string `json:"password,omitempty"`
+	IsAdmin       bool   `json:"is_admin"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 // UserList type
 type UserList []User
+
+// Role represents a role, which is a named set of permissions
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoleList type
+type RoleList []Role
+
+// Client represents an OAuth 2.0 client.
+// Confidential clients authenticate with a secret, public clients such as
+// native and single page apps do not.
+type Client struct {
+	ID           string   `json:"client_id"`
+	Name         string   `json:"name"`
+	Confidential bool     `json:"confidential"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"` // any scope is allowed if empty
+	GrantTypes   []string `json:"grant_types"`
+}
+
+// ClientList type
+type ClientList []Client
@@ -5,6 +5,7 @@ type CreateUserRequest struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
 }
 
 // LookupUserRequest represents a request for read user
@@ -14,15 +15,19 @@ type LookupUserRequest struct {
 
 // UpdateUserRequest represents a request for update upser
 type UpdateUserRequest struct {
-	ID          string `json:"id"`
 	Username    string `json:"username"`
-	OldPassword string `json:"old_password"`
-	NewPassword string `json:"new_password"`
+	NewPassword string `json:"new_password,omitempty"` // unchanged if empty
+	Email       string `json:"email,omitempty"`        // unchanged if empty
 }
 
-// DeleteUserRequest represents a request for delete user
-type DeleteUserRequest struct {
-	ID       string `json:"id"`
+// ForgotPasswordRequest represents a request for mail a password reset token
+type ForgotPasswordRequest struct {
+	ID string `json:"id"`
+}
+
+// ResetPasswordRequest represents a request for reset password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
 	Password string `json:"password"`
 }
 
@@ -30,4 +35,60 @@ type DeleteUserRequest struct {
 type AuthRequest struct {
 	ID       string `json:"id"`
 	Password string `json:"password"`
+	Audience string `json:"audience,omitempty"` // default audience if empty
+}
+
+// AuthMFARequest represents a request for the second step of authentication
+type AuthMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`               // TOTP code or recovery code
+	Audience string `json:"audience,omitempty"` // default audience if empty
+}
+
+// ConfirmTOTPRequest represents a request for confirm TOTP authenticator
+type ConfirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// RefreshTokenRequest represents a request for refresh access token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RotateKeyRequest represents a request for rotate signing key.
+// A new key of Algorithm, or of the current algorithm if empty, is generated when PrivateKey is empty.
+type RotateKeyRequest struct {
+	PrivateKey string `json:"private_key,omitempty"`
+	Algorithm  string `json:"algorithm,omitempty"`
+}
+
+// LogoutRequest represents a request for logout
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// CreateRoleRequest represents a request for create role
+type CreateRoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// CreateClientRequest is a request type for CreateClientHandler.
+// GrantTypes defaults to authorization_code and refresh_token.
+type CreateClientRequest struct {
+	Name         string   `json:"name"`
+	Confidential bool     `json:"confidential"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+}
+
+// UpdateClientRequest is a request type for UpdateClientHandler.
+// Every attribute is replaced; the secret is kept.
+type UpdateClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
 }
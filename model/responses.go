@@ -1,11 +1,26 @@
 package model
 
+import "github.com/charakoba-com/auth-api/keymgr"
+
 // ErrorResponse is a response type returned when HTTP error is raised
 type ErrorResponse struct {
 	Message string `json:"message"`
 	Error   string `json:"error,omitempty"`
 }
 
+// PasswordPolicyErrorResponse is a response type returned when a password violates
+// the password policy. Every violated rule is listed to be shown in forms.
+type PasswordPolicyErrorResponse struct {
+	Message    string              `json:"message"`
+	Violations []PasswordViolation `json:"violations"`
+}
+
+// PasswordViolation is a rule of the password policy a password does not satisfy
+type PasswordViolation struct {
+	Rule    string `json:"rule"` // min_length, max_length, character_class, user_info, breached or history
+	Message string `json:"message"`
+}
+
 // HealthCheckResponse is a response type returned from HealthCheckHandler
 type HealthCheckResponse struct {
 	Message string `json:"message"`
@@ -33,13 +48,148 @@ type DeleteUserResponse struct {
 
 // ListupUserResponse is a response type returned from ListupUserHandler
 type ListupUserResponse struct {
-	Users UserList `json:"user"`
+	Users      UserList `json:"user"`
+	Total      int      `json:"total"`                 // users matching the filters in all pages
+	NextCursor string   `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// CreateRoleResponse is a response type returned from CreateRoleHandler
+type CreateRoleResponse struct {
+	Message string `json:"message"`
+}
+
+// LookupRoleResponse is a response type returned from LookupRoleHandler
+type LookupRoleResponse struct {
+	Role Role `json:"role"`
+}
+
+// ListupRoleResponse is a response type returned from ListupRoleHandler
+type ListupRoleResponse struct {
+	Roles RoleList `json:"roles"`
+}
+
+// UserRolesResponse is a response type returned from LookupUserRolesHandler
+type UserRolesResponse struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
 }
 
 // AuthResponse is a response type returned from AuthHandler
 type AuthResponse struct {
+	Message      string `json:"message"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	MFAToken     string `json:"mfa_token,omitempty"`
+}
+
+// EnrollTOTPResponse is a response type returned from EnrollTOTPHandler
+type EnrollTOTPResponse struct {
+	Secret          string   `json:"secret"`
+	ProvisioningURI string   `json:"provisioning_uri"`
+	RecoveryCodes   []string `json:"recovery_codes"`
+}
+
+// RefreshTokenResponse is a response type returned from RefreshTokenHandler
+type RefreshTokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// LogoutResponse is a response type returned from LogoutHandler
+type LogoutResponse struct {
 	Message string `json:"message"`
-	Token   string `json:"token"`
+}
+
+// CreateClientResponse is a response type returned from CreateClientHandler.
+// ClientSecret is shown only once.
+type CreateClientResponse struct {
+	Message      string `json:"message"`
+	Client       Client `json:"client"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// LookupClientResponse is a response type returned from LookupClientHandler
+type LookupClientResponse struct {
+	Client Client `json:"client"`
+}
+
+// UpdateClientResponse is a response type returned from UpdateClientHandler
+type UpdateClientResponse struct {
+	Message string `json:"message"`
+}
+
+// DeleteClientResponse is a response type returned from DeleteClientHandler
+type DeleteClientResponse struct {
+	Message string `json:"message"`
+}
+
+// ListupClientResponse is a response type returned from ListupClientHandler
+type ListupClientResponse struct {
+	Clients ClientList `json:"clients"`
+}
+
+// OAuthTokenResponse is a response type returned from OAuthTokenHandler (RFC 6749 section 5.1)
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// IntrospectResponse is a response type returned from IntrospectHandler (RFC 7662 section 2.2).
+// Only Active is set for inactive tokens. Username and IsAdmin are set for tokens of users.
+type IntrospectResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	IsAdmin   bool   `json:"is_admin,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Expires   int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	JWTID     string `json:"jti,omitempty"`
+}
+
+// OpenIDConfigurationResponse is the OpenID Connect discovery document
+// returned from OpenIDConfigurationHandler (OpenID Connect Discovery 1.0 section 3)
+type OpenIDConfigurationResponse struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// UserInfoResponse is a response type returned from UserInfoHandler.
+// Claims other than sub are released by scopes granted to the client.
+type UserInfoResponse struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// OAuthErrorResponse is an error response of OAuth endpoints (RFC 6749 section 5.2)
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
 }
 
 // GetAlgorithmResponse is a response type returned from GetAlgorithmHandler
@@ -50,6 +200,19 @@ type GetAlgorithmResponse struct {
 // GetKeyResponse is a response type returned from GetKeyHandler
 type GetKeyResponse struct {
 	PublicKey string `json:"publickey"`
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"kid"`
+}
+
+// RotateKeyResponse is a response type returned from RotateKeyHandler
+type RotateKeyResponse struct {
+	Message string `json:"message"`
+	KeyID   string `json:"kid"`
+}
+
+// JWKSetResponse is a response type returned from JWKSetHandler
+type JWKSetResponse struct {
+	Keys []keymgr.JWK `json:"keys"`
 }
 
 // VerifyResponse is a response type returned from VerifyHandler
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"log"
+
+	"github.com/charakoba-com/auth-api/db"
+)
+
+// FromDB binds db.Role to model.Role
+func (r *Role) FromDB(dr *db.Role) error {
+	log.Printf("model.Role.FromDB")
+	r.Name = dr.Name
+	r.Description = dr.Description
+	r.Permissions = dr.Permissions
+	return nil
+}
+
+// ToDB binds model.Role to db.Role
+func (r *Role) ToDB(dr *db.Role) error {
+	log.Printf("model.Role.ToDB")
+	dr.Name = r.Name
+	dr.Description = r.Description
+	dr.Permissions = r.Permissions
+	return nil
+}
@@ -1,23 +1,34 @@
 package model
 
 import (
-	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/charakoba-com/auth-api/db"
 	"github.com/pkg/errors"
 )
 
+// limits of users in a page of UserList.Load
+const (
+	DefaultUserListLimit = 100
+	MaxUserListLimit     = 1000
+)
+
 // Load with user ID
-func (u *User) Load(tx *sql.Tx, id string) (err error) {
+func (u *User) Load(s db.UserStore, id string) (err error) {
 	log.Printf("model.User.Load %s", id)
 
-	du := db.User{}
-	if err := du.Load(tx, id); err != nil {
+	du, err := s.LookupUser(id)
+	if err != nil {
 		return errors.Wrap(err, "loading db.User")
 	}
 
-	if err := u.FromDB(&du); err != nil {
+	if err := u.FromDB(du); err != nil {
 		return errors.Wrap(err, "scanning db.User")
 	}
 	return nil
@@ -30,6 +41,8 @@ func (u *User) FromDB(du *db.User) error {
 	u.Name = du.Name
 	u.Password = du.Password
 	u.IsAdmin = du.IsAdmin
+	u.Email = du.Email
+	u.EmailVerified = du.EmailVerified
 	return nil
 }
 
@@ -40,9 +53,137 @@ func (u *User) ToDB(du *db.User) error {
 	du.Name = u.Name
 	du.Password = u.Password
 	du.IsAdmin = u.IsAdmin
+	du.Email = u.Email
+	du.EmailVerified = u.EmailVerified
 	return nil
 }
 
+// Load a page of users matching the query, returning the number of users in all
+// pages, and the cursor of the next page, which is empty on the last page
+func (ul *UserList) Load(s db.UserStore, q *db.UserQuery) (total int, next string, err error) {
+	log.Printf("model.UserList.Load")
+
+	page, err := s.ListupUsers(q)
+	if err != nil {
+		return 0, "", errors.Wrap(err, `loading db.UserList`)
+	}
+	l := make(UserList, len(page.Users))
+	for i, user := range page.Users {
+		if err := l[i].FromDB(&user); err != nil {
+			return 0, "", errors.Wrap(err, `converting db.User to model.User`)
+		}
+	}
+	if page.More && len(page.Users) > 0 {
+		next, err = encodeUserCursor(q, &page.Users[len(page.Users)-1])
+		if err != nil {
+			return 0, "", errors.Wrap(err, `encoding cursor`)
+		}
+	}
+	*ul = l
+	return page.Total, next, nil
+}
+
+// userCursor is the last user of a page in the sort order, encoded as opaque cursor
+type userCursor struct {
+	SortBy     string     `json:"sort"`
+	Descending bool       `json:"desc,omitempty"`
+	ID         string     `json:"id"`
+	Name       string     `json:"username,omitempty"`
+	CreatedOn  *time.Time `json:"created_on,omitempty"`
+}
+
+func encodeUserCursor(q *db.UserQuery, du *db.User) (string, error) {
+	c := userCursor{SortBy: q.SortBy, Descending: q.Descending, ID: du.ID}
+	switch q.SortBy {
+	case db.UserSortName:
+		c.Name = du.Name
+	case db.UserSortCreatedOn:
+		c.CreatedOn = &du.CreatedOn
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeUserCursor(q *db.UserQuery, cursor string) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return errors.New(`cursor is invalid`)
+	}
+	var c userCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return errors.New(`cursor is invalid`)
+	}
+	if c.SortBy != q.SortBy || c.Descending != q.Descending {
+		return errors.New(`cursor is for another sort order`)
+	}
+	q.After = &db.User{ID: c.ID, Name: c.Name}
+	if c.CreatedOn != nil {
+		q.After.CreatedOn = *c.CreatedOn
+	}
+	return nil
+}
+
+// ParseUserQuery parses query parameters of user listing:
+// username_prefix, is_admin, created_after and created_before (RFC 3339) filter users,
+// sort is id, username or created_on, prefixed with - for descending order,
+// and limit and cursor page them
+func ParseUserQuery(v url.Values) (*db.UserQuery, error) {
+	q := db.UserQuery{
+		NamePrefix: v.Get("username_prefix"),
+		SortBy:     db.UserSortID,
+		Limit:      DefaultUserListLimit,
+	}
+	if s := v.Get("is_admin"); s != "" {
+		isAdmin, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.Errorf(`is_admin must be true or false: %q`, s)
+		}
+		q.IsAdmin = &isAdmin
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"created_after", &q.CreatedAfter},
+		{"created_before", &q.CreatedBefore},
+	} {
+		s := v.Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, errors.Errorf(`%s must be RFC 3339 time: %q`, p.name, s)
+		}
+		*p.t = t
+	}
+	if s := v.Get("sort"); s != "" {
+		q.Descending = strings.HasPrefix(s, "-")
+		q.SortBy = strings.TrimPrefix(s, "-")
+		switch q.SortBy {
+		case db.UserSortID, db.UserSortName, db.UserSortCreatedOn:
+		default:
+			return nil, errors.Errorf(`sort must be id, username or created_on: %q`, s)
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > MaxUserListLimit {
+			return nil, errors.Errorf(`limit must be 1 to %d: %q`, MaxUserListLimit, s)
+		}
+		q.Limit = limit
+	}
+	if s := v.Get("cursor"); s != "" {
+		if err := decodeUserCursor(&q, s); err != nil {
+			return nil, err
+		}
+	}
+	return &q, nil
+}
+
 // sort.Interface implementation
 
 // Len returns the number of elements
@@ -1,6 +1,7 @@
 package model_test
 
 import (
+	"net/url"
 	"testing"
 
 	"github.com/charakoba-com/auth-api/db"
@@ -8,15 +9,15 @@ import (
 )
 
 func TestLoad(t *testing.T) {
-	db.Init(nil)
-
 	testID := "lookupID"
 	testUsername := "lookupuser"
 	testPassword := "testpasswd"
 
+	store := db.NewMemoryStore()
+	store.CreateUser(&db.User{ID: testID, Name: testUsername, Password: testPassword})
+
 	u := model.User{}
-	tx, _ := db.BeginTx()
-	if err := u.Load(tx, testID); err != nil {
+	if err := u.Load(store, testID); err != nil {
 		t.Errorf("%s", err)
 		return
 	}
@@ -61,8 +62,8 @@ func TestFromDB(t *testing.T) {
 
 func TestToDB(t *testing.T) {
 	u := model.User{
-		ID: "testID",
-		Name: "testName",
+		ID:       "testID",
+		Name:     "testName",
 		Password: "testPasswd",
 	}
 	du := db.User{}
@@ -83,3 +84,88 @@ func TestToDB(t *testing.T) {
 		return
 	}
 }
+
+func TestParseUserQuery(t *testing.T) {
+	q, err := model.ParseUserQuery(url.Values{})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if q.SortBy != db.UserSortID || q.Limit != model.DefaultUserListLimit || q.IsAdmin != nil || q.After != nil {
+		t.Errorf("unexpected default query: %v", q)
+		return
+	}
+
+	q, err = model.ParseUserQuery(url.Values{
+		"username_prefix": {"adm"},
+		"is_admin":        {"true"},
+		"created_after":   {"2017-01-01T00:00:00Z"},
+		"sort":            {"-created_on"},
+		"limit":           {"10"},
+	})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if q.NamePrefix != "adm" || q.IsAdmin == nil || !*q.IsAdmin || q.CreatedAfter.Year() != 2017 || q.SortBy != db.UserSortCreatedOn || !q.Descending || q.Limit != 10 {
+		t.Errorf("unexpected query: %v", q)
+		return
+	}
+
+	for _, v := range []url.Values{
+		{"is_admin": {"yes"}},
+		{"created_before": {"2017-01-01"}},
+		{"sort": {"password"}},
+		{"limit": {"0"}},
+		{"limit": {"1001"}},
+		{"cursor": {"invalid"}},
+	} {
+		if _, err := model.ParseUserQuery(v); err == nil {
+			t.Errorf("%v should be rejected", v)
+			return
+		}
+	}
+}
+
+func TestUserListLoad(t *testing.T) {
+	store := db.NewMemoryStore()
+	for _, id := range []string{"user1", "user2", "user3"} {
+		store.CreateUser(&db.User{ID: id, Name: id, Password: "testpasswd"})
+	}
+
+	q, err := model.ParseUserQuery(url.Values{"sort": {"-username"}, "limit": {"2"}})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var l model.UserList
+	total, next, err := l.Load(store, q)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if total != 3 || len(l) != 2 || l[0].ID != "user3" || next == "" {
+		t.Errorf("unexpected first page: %d %v %q", total, l, next)
+		return
+	}
+
+	// cursors are bound to the sort order
+	if _, err := model.ParseUserQuery(url.Values{"sort": {"username"}, "cursor": {next}}); err == nil {
+		t.Errorf("cursor of another sort order should be rejected")
+		return
+	}
+	q, err = model.ParseUserQuery(url.Values{"sort": {"-username"}, "limit": {"2"}, "cursor": {next}})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	total, next, err = l.Load(store, q)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if total != 3 || len(l) != 1 || l[0].ID != "user1" || next != "" {
+		t.Errorf("unexpected last page: %d %v %q", total, l, next)
+		return
+	}
+}
@@ -0,0 +1,620 @@
+package authapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/service"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// OAuth 2.0 error codes (RFC 6749 sections 4.1.2.1 and 5.2)
+const (
+	oauthInvalidRequest          = `invalid_request`
+	oauthInvalidClient           = `invalid_client`
+	oauthInvalidGrant            = `invalid_grant`
+	oauthInvalidScope            = `invalid_scope`
+	oauthUnauthorizedClient      = `unauthorized_client`
+	oauthUnsupportedGrantType    = `unsupported_grant_type`
+	oauthUnsupportedResponseType = `unsupported_response_type`
+	oauthAccessDenied            = `access_denied`
+	oauthServerError             = `server_error`
+)
+
+// authorizeRequest holds parameters of an authorization request,
+// which are carried through the login form as hidden fields
+type authorizeRequest struct {
+	ClientID    string
+	ClientName  string
+	RedirectURI string
+	// RequestedRedirectURI is the redirect_uri parameter as given, which is empty if omitted
+	RequestedRedirectURI string
+	Scope                string
+	State                string
+	CodeChallenge        string
+	CodeChallengeMethod  string
+	Nonce                string
+
+	// fields of the login form
+	UserID    string
+	Error     string
+	FormToken string
+}
+
+// authorizeCookie is the cookie identifying the browser, which the anti-CSRF token
+// of the login form is bound to
+const authorizeCookie = `authapi_authorize`
+
+// formBinding returns the parameters the anti-CSRF token of the login form is bound to
+func (areq *authorizeRequest) formBinding() string {
+	return url.Values{
+		"client_id":             {areq.ClientID},
+		"redirect_uri":          {areq.RequestedRedirectURI},
+		"scope":                 {areq.Scope},
+		"state":                 {areq.State},
+		"code_challenge":        {areq.CodeChallenge},
+		"code_challenge_method": {areq.CodeChallengeMethod},
+		"nonce":                 {areq.Nonce},
+	}.Encode()
+}
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sign in to {{.ClientName}}</title>
+</head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+{{if .Scope}}<p>{{.ClientName}} requests access to: {{.Scope}}</p>{{end}}
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="POST" action="/oauth/authorize">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RequestedRedirectURI}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<input type="hidden" name="form_token" value="{{.FormToken}}">
+<p><label>ID <input type="text" name="id" value="{{.UserID}}" autocomplete="username" required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><label>Two-factor code (if enabled) <input type="text" name="code" autocomplete="one-time-code"></label></p>
+<p>
+<button type="submit" name="action" value="allow">Allow</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</p>
+</form>
+</body>
+</html>
+`))
+
+// AuthorizeHandler is a HTTP handler, which shows the login and consent page at GET,
+// and redirects the user agent back to the client with an authorization code at POST
+func (s *Server) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AuthorizeHandler")
+	method := r.Method
+	if method != `GET` && method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET or POST is expected`, nil)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid form request`, nil)
+		return
+	}
+	areq, ok := s.parseAuthorizeRequest(w, r)
+	if !ok {
+		return
+	}
+	if method == `GET` {
+		s.renderAuthorize(w, r, areq, http.StatusOK)
+		return
+	}
+	// the form must be the one rendered for this browser and this request
+	if err := s.verifyFormToken(r, areq); err != nil {
+		log.Printf("%s", err)
+		areq.Error = "The page has expired. Please try again."
+		s.renderAuthorize(w, r, areq, http.StatusForbidden)
+		return
+	}
+	if r.PostFormValue("action") != "allow" {
+		authorizeRedirect(w, r, areq, url.Values{"error": {oauthAccessDenied}})
+		return
+	}
+
+	areq.UserID = r.PostFormValue("id")
+	userID, message, err := s.authorizeUser(areq.UserID, r.PostFormValue("password"), r.PostFormValue("code"), clientIP(r))
+	if err != nil {
+		log.Printf("%s", err)
+		authorizeRedirect(w, r, areq, url.Values{"error": {oauthServerError}})
+		return
+	}
+	if message != "" {
+		areq.Error = message
+		s.renderAuthorize(w, r, areq, http.StatusOK)
+		return
+	}
+	code, err := s.oauthSvc.IssueAuthorizationCode(areq.ClientID, userID, areq.RequestedRedirectURI, areq.Scope, areq.CodeChallenge, areq.Nonce)
+	if err != nil {
+		log.Printf("%s", err)
+		authorizeRedirect(w, r, areq, url.Values{"error": {oauthServerError}})
+		return
+	}
+	authorizeRedirect(w, r, areq, url.Values{"code": {code}})
+}
+
+// parseAuthorizeRequest validates the authorization request. Errors about the client
+// and the redirect URI are shown to the user, as the redirect URI is not trustworthy.
+// Others are sent to the client by redirection.
+func (s *Server) parseAuthorizeRequest(w http.ResponseWriter, r *http.Request) (*authorizeRequest, bool) {
+	client, err := s.oauthSvc.LookupClient(r.FormValue("client_id"))
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusBadRequest, `client is not registered`, nil)
+			return nil, false
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return nil, false
+	}
+	redirectURI, err := s.oauthSvc.RedirectURI(client, r.FormValue("redirect_uri"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, `redirect_uri is not registered`, nil)
+		return nil, false
+	}
+	areq := authorizeRequest{
+		ClientID:             client.ID,
+		ClientName:           client.Name,
+		RedirectURI:          redirectURI,
+		RequestedRedirectURI: r.FormValue("redirect_uri"),
+		Scope:                r.FormValue("scope"),
+		State:                r.FormValue("state"),
+		CodeChallenge:        r.FormValue("code_challenge"),
+		CodeChallengeMethod:  r.FormValue("code_challenge_method"),
+		Nonce:                r.FormValue("nonce"),
+	}
+	if r.FormValue("response_type") != "code" {
+		authorizeRedirect(w, r, &areq, url.Values{"error": {oauthUnsupportedResponseType}})
+		return nil, false
+	}
+	if !client.AllowsGrantType(service.GrantAuthorizationCode) {
+		authorizeRedirect(w, r, &areq, url.Values{"error": {oauthUnauthorizedClient}})
+		return nil, false
+	}
+	if areq.Scope, err = s.oauthSvc.GrantScope(client, areq.Scope); err != nil {
+		authorizeRedirect(w, r, &areq, url.Values{"error": {oauthInvalidScope}})
+		return nil, false
+	}
+	if areq.CodeChallengeMethod != utils.PKCEMethodS256 || !utils.ValidPKCEChallenge(areq.CodeChallenge) {
+		authorizeRedirect(w, r, &areq, url.Values{
+			"error":             {oauthInvalidRequest},
+			"error_description": {"PKCE code_challenge with S256 method is required"},
+		})
+		return nil, false
+	}
+	return &areq, true
+}
+
+// authorizeUser authenticates the user at the login form with the same lockout policy as `/auth`.
+// A message is returned to be shown on the form when the user is not authenticated.
+func (s *Server) authorizeUser(userID, password, code, ip string) (string, string, error) {
+	if _, err := s.lockoutSvc.Check(userID, ip); err != nil {
+		switch errors.Cause(err) {
+		case service.ErrAccountLocked, service.ErrTooManyAttempts:
+			return "", "Too many failed attempts. Please retry later.", nil
+		}
+		return "", "", err
+	}
+	fail := func() (string, string, error) {
+		if err := s.lockoutSvc.Fail(userID, ip); err != nil {
+			return "", "", err
+		}
+		return "", "ID, password or two-factor code is incorrect.", nil
+	}
+
+	user, err := s.usrSvc.Authenticate(userID, password)
+	if err != nil {
+		switch errors.Cause(err) {
+		case service.ErrAuthFailed:
+			return fail()
+		case service.ErrEmailNotVerified:
+			return "", "Verify your email address before signing in.", nil
+		}
+		return "", "", err
+	}
+	enabled, err := s.mfaSvc.Enabled(user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		if code == "" {
+			return "", "Enter the code of your authenticator.", nil
+		}
+		if err := s.mfaSvc.Verify(user.ID, code); err != nil {
+			switch errors.Cause(err) {
+			case service.ErrInvalidMFACode, service.ErrMFANotEnrolled:
+				return fail()
+			}
+			return "", "", err
+		}
+	}
+	if err := s.lockoutSvc.Succeed(user.ID); err != nil {
+		return "", "", err
+	}
+	return user.ID, "", nil
+}
+
+// verifyFormToken checks the anti-CSRF token of the posted login form
+func (s *Server) verifyFormToken(r *http.Request, areq *authorizeRequest) error {
+	cookie, err := r.Cookie(authorizeCookie)
+	if err != nil {
+		return errors.Wrap(err, `reading authorize cookie`)
+	}
+	token, err := utils.ParseToken(r.PostFormValue("form_token"))
+	if err != nil {
+		return err
+	}
+	return s.tokens.ValidateAuthorizeFormToken(token, cookie.Value, areq.formBinding())
+}
+
+// renderAuthorize shows the login and consent page with a new anti-CSRF token,
+// setting the browser cookie unless it is set
+func (s *Server) renderAuthorize(w http.ResponseWriter, r *http.Request, areq *authorizeRequest, status int) {
+	browser := ""
+	if cookie, err := r.Cookie(authorizeCookie); err == nil {
+		browser = cookie.Value
+	}
+	if browser == "" {
+		var err error
+		if browser, err = utils.RandomToken(32); err != nil {
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     authorizeCookie,
+			Value:    browser,
+			Path:     "/oauth/authorize",
+			Secure:   r.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	token, err := s.tokens.WithContext(r.Context()).GenerateAuthorizeFormToken(browser, areq.formBinding())
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	areq.FormToken = token
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// the page must not be framed to prevent clickjacking
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := authorizeTemplate.Execute(w, areq); err != nil {
+		log.Printf("rendering authorize page: %s", err)
+	}
+}
+
+// authorizeRedirect redirects the user agent to the redirect URI with params and state
+func authorizeRedirect(w http.ResponseWriter, r *http.Request, areq *authorizeRequest, params url.Values) {
+	u, err := url.Parse(areq.RedirectURI)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	q := u.Query()
+	for key, values := range params {
+		q[key] = values
+	}
+	if areq.State != "" {
+		q.Set("state", areq.State)
+	}
+	u.RawQuery = q.Encode()
+	log.Printf("redirecting to %s://%s%s", u.Scheme, u.Host, u.Path)
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// oauthError responds an error of the token endpoint (RFC 6749 section 5.2)
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	httpJSONWithStatus(w, status, model.OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+// OAuthTokenHandler is a HTTP handler, which is the OAuth 2.0 token endpoint.
+// It supports authorization_code with PKCE, refresh_token and client_credentials grants.
+// An OpenID Connect ID token is issued with the code granted `openid` scope.
+func (s *Server) OAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("OAuthTokenHandler")
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, oauthInvalidRequest, `invalid form request`)
+		return
+	}
+	client, ok := s.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	grantType := r.PostFormValue("grant_type")
+	switch grantType {
+	case service.GrantAuthorizationCode, service.GrantRefreshToken, service.GrantClientCredentials:
+	default:
+		oauthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, ``)
+		return
+	}
+	if !client.AllowsGrantType(grantType) {
+		oauthError(w, http.StatusBadRequest, oauthUnauthorizedClient, `grant type is not allowed for the client`)
+		return
+	}
+	if grantType == service.GrantClientCredentials {
+		s.clientCredentialsGrant(w, r, client)
+		return
+	}
+
+	var userID, scope, refreshToken string
+	var code *db.AuthorizationCode
+	var err error
+	switch grantType {
+	case service.GrantAuthorizationCode:
+		code, err = s.oauthSvc.ExchangeAuthorizationCode(client.ID, r.PostFormValue("code"), r.PostFormValue("redirect_uri"), r.PostFormValue("code_verifier"))
+		if err == nil {
+			userID, scope = code.UserID, code.Scope
+			refreshToken, err = s.tokenSvc.IssueCodeRefreshToken(code)
+		}
+	case service.GrantRefreshToken:
+		var rt *db.RefreshToken
+		refreshToken, rt, err = s.tokenSvc.Refresh(r.PostFormValue("refresh_token"), client.ID)
+		if err == nil {
+			userID, scope = rt.UserID, rt.Scope
+		}
+	}
+	if err != nil {
+		switch errors.Cause(err) {
+		case service.ErrInvalidGrant, service.ErrInvalidRefreshToken, service.ErrRefreshTokenReused:
+			oauthError(w, http.StatusBadRequest, oauthInvalidGrant, err.Error())
+		default:
+			log.Printf("%s", err)
+			oauthError(w, http.StatusInternalServerError, oauthServerError, ``)
+		}
+		return
+	}
+
+	user, err := s.usrSvc.Lookup(userID)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			oauthError(w, http.StatusBadRequest, oauthInvalidGrant, `user not found`)
+			return
+		}
+		log.Printf("%s", err)
+		oauthError(w, http.StatusInternalServerError, oauthServerError, ``)
+		return
+	}
+	sub, err := s.roleSvc.ClientTokenSubject(user, client.ID, scope)
+	if err != nil {
+		log.Printf("%s", err)
+		oauthError(w, http.StatusInternalServerError, oauthServerError, ``)
+		return
+	}
+	tokens := s.tokens.WithContext(r.Context())
+	token, err := tokens.GenerateToken(sub)
+	if err != nil {
+		log.Printf("%s", err)
+		oauthError(w, http.StatusInternalServerError, oauthServerError, ``)
+		return
+	}
+	var idToken string
+	if code != nil && utils.HasScope(scope, scopeOpenID) {
+		// the code is issued right after the user is authenticated
+		idToken, err = tokens.GenerateIDToken(&utils.IDTokenSubject{
+			UserID:   userID,
+			ClientID: client.ID,
+			Nonce:    code.Nonce,
+			AuthTime: code.CreatedOn,
+		})
+		if err != nil {
+			log.Printf("%s", err)
+			oauthError(w, http.StatusInternalServerError, oauthServerError, ``)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	httpJSON(w, model.OAuthTokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.tokens.AccessTokenLifetimeFor(s.tokens.TokenAudience(sub)) / time.Second),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+		IDToken:      idToken,
+	})
+}
+
+// authenticateClient authenticates the client with HTTP Basic authentication or
+// client_id and client_secret form fields. An error is responded on failure.
+func (s *Server) authenticateClient(w http.ResponseWriter, r *http.Request) (*model.Client, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// client credentials are form encoded in basic authentication (RFC 6749 section 2.3.1)
+		var err1, err2 error
+		clientID, err1 = url.QueryUnescape(clientID)
+		secret, err2 = url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			oauthError(w, http.StatusBadRequest, oauthInvalidRequest, `invalid basic authentication`)
+			return nil, false
+		}
+	} else {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	client, err := s.oauthSvc.AuthenticateClient(clientID, secret)
+	if err != nil {
+		if errors.Cause(err) == service.ErrInvalidClient {
+			if basic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="authapi"`)
+			}
+			oauthError(w, http.StatusUnauthorized, oauthInvalidClient, `client authentication failed`)
+			return nil, false
+		}
+		log.Printf("%s", err)
+		oauthError(w, http.StatusInternalServerError, oauthServerError, ``)
+		return nil, false
+	}
+	return client, true
+}
+
+// clientCredentialsGrant issues an access token for the client itself (RFC 6749 section 4.4).
+// No refresh token is issued as the client can authenticate again.
+func (s *Server) clientCredentialsGrant(w http.ResponseWriter, r *http.Request, client *model.Client) {
+	scope, err := s.oauthSvc.GrantScope(client, r.PostFormValue("scope"))
+	if err != nil {
+		oauthError(w, http.StatusBadRequest, oauthInvalidScope, err.Error())
+		return
+	}
+	token, err := s.tokens.WithContext(r.Context()).GenerateClientToken(client.ID, scope)
+	if err != nil {
+		log.Printf("%s", err)
+		oauthError(w, http.StatusInternalServerError, oauthServerError, ``)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpJSON(w, model.OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.tokens.AccessTokenLifetimeFor(client.ID) / time.Second),
+		Scope:       scope,
+	})
+}
+
+// CreateClientHandler is a HTTP handler, which registers an OAuth client
+func (s *Server) CreateClientHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("CreateClientHandler")
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	var createClientRequest model.CreateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&createClientRequest); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+		return
+	}
+	client := model.Client{
+		Name:         createClientRequest.Name,
+		Confidential: createClientRequest.Confidential,
+		RedirectURIs: createClientRequest.RedirectURIs,
+		Scopes:       createClientRequest.Scopes,
+		GrantTypes:   createClientRequest.GrantTypes,
+	}
+	secret, err := s.oauthSvc.RegisterClient(&client)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, `registering client`, err)
+		return
+	}
+	// the secret is shown only once
+	w.Header().Set("Cache-Control", "no-store")
+	httpJSON(w, model.CreateClientResponse{
+		Message:      "success",
+		Client:       client,
+		ClientSecret: secret,
+	})
+}
+
+// LookupClientHandler is a HTTP handler, which returns an OAuth client
+func (s *Server) LookupClientHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("LookupClientHandler")
+	method := r.Method
+	if method != `GET` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
+		return
+	}
+	client, err := s.oauthSvc.LookupClient(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, `client not found`, nil)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, model.LookupClientResponse{Client: *client})
+}
+
+// UpdateClientHandler is a HTTP handler, which updates an OAuth client
+func (s *Server) UpdateClientHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateClientHandler")
+	method := r.Method
+	if method != `PUT` {
+		httpError(w, http.StatusMethodNotAllowed, `method PUT is expected`, nil)
+		return
+	}
+	var updateClientRequest model.UpdateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&updateClientRequest); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+		return
+	}
+	client := model.Client{
+		ID:           mux.Vars(r)["id"],
+		Name:         updateClientRequest.Name,
+		RedirectURIs: updateClientRequest.RedirectURIs,
+		Scopes:       updateClientRequest.Scopes,
+		GrantTypes:   updateClientRequest.GrantTypes,
+	}
+	if err := s.oauthSvc.UpdateClient(&client); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusNotFound, `client not found`, nil)
+			return
+		}
+		httpError(w, http.StatusBadRequest, `updating client`, err)
+		return
+	}
+	httpJSON(w, model.UpdateClientResponse{Message: "success"})
+}
+
+// DeleteClientHandler is a HTTP handler, which deletes an OAuth client
+func (s *Server) DeleteClientHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteClientHandler")
+	method := r.Method
+	if method != `DELETE` {
+		httpError(w, http.StatusMethodNotAllowed, `method DELETE is expected`, nil)
+		return
+	}
+	if err := s.oauthSvc.DeleteClient(mux.Vars(r)["id"]); err != nil {
+		httpError(w, http.StatusInternalServerError, `deleting client`, err)
+		return
+	}
+	httpJSON(w, model.DeleteClientResponse{Message: "success"})
+}
+
+// ListupClientHandler is a HTTP handler, which returns all OAuth clients
+func (s *Server) ListupClientHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ListupClientHandler")
+	method := r.Method
+	if method != `GET` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
+		return
+	}
+	clients, err := s.oauthSvc.ListupClients()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, model.ListupClientResponse{Clients: clients})
+}
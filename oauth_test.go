@@ -0,0 +1,653 @@
+package authapi_test
+
+import (
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/SermoDigital/jose/jws"
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+// noRedirectClient does not follow redirects to inspect them.
+// It keeps cookies as a browser to post the login form of `/oauth/authorize`.
+var noRedirectClient = &http.Client{
+	Jar: newCookieJar(),
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func newCookieJar() http.CookieJar {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(err)
+	}
+	return jar
+}
+
+var formTokenPattern = regexp.MustCompile(`name="form_token" value="([^"]*)"`)
+
+// postAuthorize gets the login form of the authorization request in form,
+// and posts form with the anti-CSRF token of the form
+func postAuthorize(t *testing.T, client *http.Client, form url.Values) *http.Response {
+	params := url.Values{}
+	for k := range form {
+		switch k {
+		case "id", "password", "code", "action":
+		default:
+			params.Set(k, form.Get(k))
+		}
+	}
+	res, err := client.Get(ts.URL + "/oauth/authorize?" + params.Encode())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	posted := url.Values{}
+	for k, v := range form {
+		posted[k] = v
+	}
+	if m := formTokenPattern.FindSubmatch(body); m != nil {
+		posted.Set("form_token", html.UnescapeString(string(m[1])))
+	}
+	res, err = client.PostForm(ts.URL+"/oauth/authorize", posted)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return res
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	req := authorizedRequest(t, "POST", "/client", strings.NewReader(`{"name": "Native App", "redirect_uris": ["http://127.0.0.1:9999/cb"], "scopes": ["profile"]}`), "adminID", true)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var createClientResponse model.CreateClientResponse
+	if err := json.NewDecoder(res.Body).Decode(&createClientResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	client := createClientResponse.Client
+	if client.ID == "" || client.Confidential || createClientResponse.ClientSecret != "" {
+		t.Errorf("unexpected client: %v", createClientResponse)
+		return
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {"http://127.0.0.1:9999/cb"},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {utils.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k := range params {
+			v.Set(k, params.Get(k))
+		}
+		v.Set(key, value)
+		return v
+	}
+	// authorize posts the login form and returns the redirected location
+	authorize := func(form url.Values) (*http.Response, url.Values) {
+		res := postAuthorize(t, noRedirectClient, form)
+		if res.StatusCode != http.StatusFound {
+			return res, nil
+		}
+		location, err := url.Parse(res.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return res, location.Query()
+	}
+	exchange := func(form url.Values) (*http.Response, model.OAuthTokenResponse) {
+		var tokenResponse model.OAuthTokenResponse
+		res, err := http.PostForm(ts.URL+"/oauth/token", form)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		json.NewDecoder(res.Body).Decode(&tokenResponse)
+		return res, tokenResponse
+	}
+
+	res, err = noRedirectClient.Get(ts.URL + "/oauth/authorize?" + params.Encode())
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 200 || !strings.Contains(string(body), "Native App") {
+		t.Errorf("login page is expected, but %s", res.Status)
+		return
+	}
+	// redirect URI is not trusted unless registered
+	res, err = noRedirectClient.Get(ts.URL + "/oauth/authorize?" + with("redirect_uri", "https://evil.example.com/cb").Encode())
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %s", res.Status)
+		return
+	}
+	// PKCE is required
+	if _, q := authorize(with("code_challenge_method", "plain")); q.Get("error") != "invalid_request" || q.Get("state") != "xyz" {
+		t.Errorf("invalid_request is expected, but %v", q)
+		return
+	}
+
+	form := with("id", "lookupID")
+	form.Set("password", "wrongpasswd")
+	form.Set("action", "allow")
+	// the form must be rendered for the browser and the authorization request
+	res, err = noRedirectClient.Get(ts.URL + "/oauth/authorize?" + params.Encode())
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	m := formTokenPattern.FindSubmatch(body)
+	if m == nil {
+		t.Errorf("form token is expected")
+		return
+	}
+	formToken := html.UnescapeString(string(m[1]))
+	forged := with("id", "lookupID")
+	forged.Set("password", "testpasswd")
+	forged.Set("action", "allow")
+	for name, c := range map[string]struct {
+		client *http.Client
+		form   url.Values
+		token  string
+	}{
+		"no token":      {noRedirectClient, forged, ""},
+		"other browser": {&http.Client{Jar: newCookieJar(), CheckRedirect: noRedirectClient.CheckRedirect}, forged, formToken},
+		"other request": {noRedirectClient, with("state", "abc"), formToken},
+	} {
+		c.form.Set("id", "lookupID")
+		c.form.Set("password", "testpasswd")
+		c.form.Set("action", "allow")
+		c.form.Set("form_token", c.token)
+		res, err := c.client.PostForm(ts.URL+"/oauth/authorize", c.form)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if res.StatusCode != 403 || res.Header.Get("Location") != "" {
+			t.Errorf("%s: status 403 Forbidden is expected, but %s", name, res.Status)
+			return
+		}
+	}
+	if res, q := authorize(form); res.StatusCode != 200 || q != nil {
+		t.Errorf("login page is expected, but %s", res.Status)
+		return
+	}
+	form.Set("password", "testpasswd")
+	form.Set("action", "deny")
+	if _, q := authorize(form); q.Get("error") != "access_denied" {
+		t.Errorf("access_denied is expected, but %v", q)
+		return
+	}
+	form.Set("action", "allow")
+	_, q := authorize(form)
+	if q.Get("code") == "" || q.Get("state") != "xyz" {
+		t.Errorf("code is expected, but %v", q)
+		return
+	}
+
+	// wrong verifier consumes the code
+	tokenForm := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {"http://127.0.0.1:9999/cb"},
+		"client_id":     {client.ID},
+		"code_verifier": {strings.Repeat("a", 43)},
+	}
+	if res, tokenResponse := exchange(tokenForm); res.StatusCode != 400 || tokenResponse.AccessToken != "" {
+		t.Errorf("status 400 Bad Request is expected, but %s", res.Status)
+		return
+	}
+	tokenForm.Set("code_verifier", verifier)
+	if res, _ := exchange(tokenForm); res.StatusCode != 400 {
+		t.Errorf("consumed code is accepted: %s", res.Status)
+		return
+	}
+
+	// a code presented by another client is not consumed
+	req = authorizedRequest(t, "POST", "/client", strings.NewReader(`{"name": "Other App", "redirect_uris": ["http://127.0.0.1:9999/cb"]}`), "adminID", true)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var otherClientResponse model.CreateClientResponse
+	if err := json.NewDecoder(res.Body).Decode(&otherClientResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	_, q = authorize(form)
+	tokenForm.Set("code", q.Get("code"))
+	tokenForm.Set("client_id", otherClientResponse.Client.ID)
+	if res, _ := exchange(tokenForm); res.StatusCode != 400 {
+		t.Errorf("code of another client is accepted: %s", res.Status)
+		return
+	}
+	tokenForm.Set("client_id", client.ID)
+	res, tokenResponse := exchange(tokenForm)
+	if res.StatusCode != 200 || tokenResponse.TokenType != "Bearer" || tokenResponse.Scope != "profile" {
+		t.Errorf("token is expected, but %s %v", res.Status, tokenResponse)
+		return
+	}
+	if !verifyAudience(t, tokenResponse.AccessToken, client.ID) {
+		t.Errorf("access token is not valid")
+		return
+	}
+	token, err := jws.ParseJWT([]byte(tokenResponse.AccessToken))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if token.Claims().Get("client_id") != client.ID || token.Claims().Get("scope") != "profile" {
+		t.Errorf("unexpected claims: %v", token.Claims())
+		return
+	}
+	// refresh tokens are bound to the client
+	res, err = http.Post(ts.URL+"/token/refresh", "application/json", strings.NewReader(`{"refresh_token": "`+tokenResponse.RefreshToken+`"}`))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+	res, refreshed := exchange(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokenResponse.RefreshToken},
+		"client_id":     {client.ID},
+	})
+	if res.StatusCode != 200 || refreshed.Scope != "profile" || refreshed.RefreshToken == tokenResponse.RefreshToken {
+		t.Errorf("refreshed token is expected, but %s %v", res.Status, refreshed)
+		return
+	}
+
+	// codes are used only once, and reuse revokes refresh tokens issued for the code
+	if res, _ := exchange(tokenForm); res.StatusCode != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %s", res.Status)
+		return
+	}
+	if res, _ := exchange(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshed.RefreshToken},
+		"client_id":     {client.ID},
+	}); res.StatusCode != 400 {
+		t.Errorf("refresh token of reused code is accepted: %s", res.Status)
+		return
+	}
+
+	// redirect_uri is required at the token endpoint only if it was in the authorization request
+	_, q = authorize(form)
+	tokenForm.Set("code", q.Get("code"))
+	tokenForm.Del("redirect_uri")
+	if res, _ := exchange(tokenForm); res.StatusCode != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %s", res.Status)
+		return
+	}
+	form.Del("redirect_uri")
+	_, q = authorize(form)
+	tokenForm.Set("code", q.Get("code"))
+	if res, _ := exchange(tokenForm); res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %s", res.Status)
+		return
+	}
+}
+
+func TestOAuthDelegatedToken(t *testing.T) {
+	do := func(method, path, body string) *http.Response {
+		res, err := http.DefaultClient.Do(authorizedRequest(t, method, path, strings.NewReader(body), "adminID", true))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return res
+	}
+	for _, c := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/role", `{"name": "delegated", "permissions": ["ledger:read", "ledger:write"]}`},
+		{"PUT", "/user/adminID/role/delegated", ``},
+	} {
+		if res := do(c.method, c.path, c.body); res.StatusCode != 200 {
+			t.Errorf("%s %s: status 200 OK is expected, but %s", c.method, c.path, res.Status)
+			return
+		}
+	}
+	defer do("DELETE", "/role/delegated", ``)
+	res := do("POST", "/client", `{"name": "Delegated App", "redirect_uris": ["http://127.0.0.1:9999/cb"], "scopes": ["openid", "ledger:read"]}`)
+	var createClientResponse model.CreateClientResponse
+	if err := json.NewDecoder(res.Body).Decode(&createClientResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	clientID := createClientResponse.Client.ID
+	defer do("DELETE", "/client/"+clientID, ``)
+
+	// an admin approves the client
+	verifier := strings.Repeat("v", 43)
+	res = postAuthorize(t, noRedirectClient, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"scope":                 {"openid ledger:read"},
+		"code_challenge":        {utils.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+		"id":                    {"adminID"},
+		"password":              {"testpasswd"},
+		"action":                {"allow"},
+	})
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	res, err = http.PostForm(ts.URL+"/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {"http://127.0.0.1:9999/cb"},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var tokenResponse model.OAuthTokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&tokenResponse); err != nil || tokenResponse.AccessToken == "" {
+		t.Errorf("token is expected, but %s %v", res.Status, tokenResponse)
+		return
+	}
+
+	// only permissions granted as scopes are delegated
+	token, err := jws.ParseJWT([]byte(tokenResponse.AccessToken))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	claims := token.Claims()
+	if claims.Has("is_admin") || claims.Has("roles") || !reflect.DeepEqual(claims.Get("permissions"), []interface{}{"ledger:read"}) {
+		t.Errorf("unexpected claims: %v", claims)
+		return
+	}
+	for _, path := range []string{"/user/list", "/role", "/client", "/user/adminID"} {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+tokenResponse.AccessToken)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if res.StatusCode != 401 {
+			t.Errorf("GET %s: status 401 Unauthorized is expected, but %s", path, res.Status)
+			return
+		}
+	}
+	req, _ := http.NewRequest("GET", ts.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenResponse.AccessToken)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %v %v", res, err)
+		return
+	}
+}
+
+func TestOAuthTokenHandlerClientAuthentication(t *testing.T) {
+	req := authorizedRequest(t, "POST", "/client", strings.NewReader(`{"name": "Web App", "confidential": true, "redirect_uris": ["https://example.com/cb"]}`), "adminID", true)
+	var res *http.Response
+	var err error
+	logged := captureLog(func() {
+		res, err = http.DefaultClient.Do(req)
+	})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var createClientResponse model.CreateClientResponse
+	if err := json.NewDecoder(res.Body).Decode(&createClientResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if createClientResponse.ClientSecret == "" {
+		t.Errorf("client secret is expected")
+		return
+	}
+	// the secret is hashed at rest, and not logged nor cached either
+	if strings.Contains(logged, createClientResponse.ClientSecret) || res.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("client secret is logged or cached: %s", logged)
+		return
+	}
+	clientID := createClientResponse.Client.ID
+
+	post := func(id, secret string) *http.Response {
+		req, _ := http.NewRequest("POST", ts.URL+"/oauth/token", strings.NewReader(`grant_type=authorization_code&code=unknown&redirect_uri=https%3A%2F%2Fexample.com%2Fcb`))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(id, secret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return res
+	}
+	if res := post(clientID, "wrongsecret"); res.StatusCode != 401 || res.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+	var oauthError model.OAuthErrorResponse
+	res = post(clientID, createClientResponse.ClientSecret)
+	if err := json.NewDecoder(res.Body).Decode(&oauthError); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 400 || oauthError.Error != "invalid_grant" {
+		t.Errorf("invalid_grant is expected, but %s %v", res.Status, oauthError)
+		return
+	}
+
+	// only admins register clients
+	req = authorizedRequest(t, "GET", "/client/"+clientID, nil, "lookupID", false)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != 403 {
+		t.Errorf("status 403 Forbidden is expected, but %v %v", res, err)
+		return
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	// public clients can not authenticate themselves
+	req := authorizedRequest(t, "POST", "/client", strings.NewReader(`{"name": "Public Batch", "grant_types": ["client_credentials"]}`), "adminID", true)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %s", res.Status)
+		return
+	}
+
+	req = authorizedRequest(t, "POST", "/client", strings.NewReader(`{"name": "Batch", "confidential": true, "scopes": ["billing:read", "billing:write"], "grant_types": ["client_credentials"]}`), "adminID", true)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var createClientResponse model.CreateClientResponse
+	if err := json.NewDecoder(res.Body).Decode(&createClientResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	clientID, secret := createClientResponse.Client.ID, createClientResponse.ClientSecret
+
+	grant := func(form url.Values) (*http.Response, model.OAuthTokenResponse, model.OAuthErrorResponse) {
+		req, _ := http.NewRequest("POST", ts.URL+"/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientID, secret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		var tokenResponse model.OAuthTokenResponse
+		var oauthError model.OAuthErrorResponse
+		json.Unmarshal(body, &tokenResponse)
+		json.Unmarshal(body, &oauthError)
+		return res, tokenResponse, oauthError
+	}
+
+	res, tokenResponse, _ := grant(url.Values{"grant_type": {"client_credentials"}, "scope": {"billing:read"}})
+	if res.StatusCode != 200 || tokenResponse.Scope != "billing:read" || tokenResponse.RefreshToken != "" {
+		t.Errorf("token is expected, but %s %v", res.Status, tokenResponse)
+		return
+	}
+	if !verifyAudience(t, tokenResponse.AccessToken, clientID) {
+		t.Errorf("access token is not valid")
+		return
+	}
+	token, err := jws.ParseJWT([]byte(tokenResponse.AccessToken))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if token.Claims().Get("client_id") != clientID || token.Claims().Has("username") || token.Claims().Has("is_admin") {
+		t.Errorf("unexpected claims: %v", token.Claims())
+		return
+	}
+	// all allowed scopes are granted by default
+	if _, tokenResponse, _ := grant(url.Values{"grant_type": {"client_credentials"}}); tokenResponse.Scope != "billing:read billing:write" {
+		t.Errorf("unexpected scope: %v", tokenResponse)
+		return
+	}
+	if _, _, oauthError := grant(url.Values{"grant_type": {"client_credentials"}, "scope": {"billing:read admin"}}); oauthError.Error != "invalid_scope" {
+		t.Errorf("invalid_scope is expected, but %v", oauthError)
+		return
+	}
+	if _, _, oauthError := grant(url.Values{"grant_type": {"authorization_code"}, "code": {"unknown"}}); oauthError.Error != "unauthorized_client" {
+		t.Errorf("unauthorized_client is expected, but %v", oauthError)
+		return
+	}
+
+	// admins manage clients
+	res, err = http.DefaultClient.Do(authorizedRequest(t, "GET", "/client", nil, "adminID", true))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var listupClientResponse model.ListupClientResponse
+	if err := json.NewDecoder(res.Body).Decode(&listupClientResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	found := false
+	for _, c := range listupClientResponse.Clients {
+		if c.ID == clientID {
+			found = c.Confidential && len(c.GrantTypes) == 1
+		}
+	}
+	if !found {
+		t.Errorf("client is not listed: %v", listupClientResponse)
+		return
+	}
+	req = authorizedRequest(t, "PUT", "/client/"+clientID, strings.NewReader(`{"name": "Batch", "scopes": ["billing:read"], "grant_types": ["client_credentials"]}`), "adminID", true)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %v %v", res, err)
+		return
+	}
+	if _, _, oauthError := grant(url.Values{"grant_type": {"client_credentials"}, "scope": {"billing:write"}}); oauthError.Error != "invalid_scope" {
+		t.Errorf("invalid_scope is expected, but %v", oauthError)
+		return
+	}
+	req = authorizedRequest(t, "PUT", "/client/unknown", strings.NewReader(`{"name": "Batch"}`), "adminID", true)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != 404 {
+		t.Errorf("status 404 Not Found is expected, but %v %v", res, err)
+		return
+	}
+	req = authorizedRequest(t, "DELETE", "/client/"+clientID, nil, "adminID", true)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != 200 {
+		t.Errorf("status 200 OK is expected, but %v %v", res, err)
+		return
+	}
+	if res, _, _ := grant(url.Values{"grant_type": {"client_credentials"}}); res.StatusCode != 401 {
+		t.Errorf("status 401 Unauthorized is expected, but %s", res.Status)
+		return
+	}
+}
+
+func TestOAuthUnscopedClient(t *testing.T) {
+	req := authorizedRequest(t, "POST", "/client", strings.NewReader(`{"name": "Unscoped Batch", "confidential": true, "grant_types": ["client_credentials"]}`), "adminID", true)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var createClientResponse model.CreateClientResponse
+	if err := json.NewDecoder(res.Body).Decode(&createClientResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	client := createClientResponse.Client
+	defer http.DefaultClient.Do(authorizedRequest(t, "DELETE", "/client/"+client.ID, nil, "adminID", true))
+
+	grant := func(scope string) (*http.Response, model.OAuthTokenResponse, model.OAuthErrorResponse) {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		if scope != "" {
+			form.Set("scope", scope)
+		}
+		req, _ := http.NewRequest("POST", ts.URL+"/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ID, createClientResponse.ClientSecret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		var tokenResponse model.OAuthTokenResponse
+		var oauthError model.OAuthErrorResponse
+		json.Unmarshal(body, &tokenResponse)
+		json.Unmarshal(body, &oauthError)
+		return res, tokenResponse, oauthError
+	}
+
+	// clients without allowed scopes are granted no scope
+	for _, scope := range []string{"billing:read", "openid profile"} {
+		if res, tokenResponse, oauthError := grant(scope); res.StatusCode != 400 || oauthError.Error != "invalid_scope" || tokenResponse.AccessToken != "" {
+			t.Errorf("%s: invalid_scope is expected, but %s %v", scope, res.Status, oauthError)
+			return
+		}
+	}
+	res, tokenResponse, _ := grant("")
+	if res.StatusCode != 200 || tokenResponse.Scope != "" {
+		t.Errorf("token without scope is expected, but %s %v", res.Status, tokenResponse)
+		return
+	}
+	token, err := jws.ParseJWT([]byte(tokenResponse.AccessToken))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if scope, _ := token.Claims().Get("scope").(string); scope != "" {
+		t.Errorf("unexpected scope: %s", scope)
+		return
+	}
+}
@@ -0,0 +1,89 @@
+package authapi
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/service"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// OpenID Connect scopes deciding which claims are released
+const (
+	scopeOpenID  = `openid`
+	scopeProfile = `profile`
+	scopeEmail   = `email`
+)
+
+// OpenIDConfigurationHandler is a HTTP handler, which returns the OpenID Connect discovery document
+func (s *Server) OpenIDConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("OpenIDConfigurationHandler")
+	method := r.Method
+	if method != `GET` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET is expected`, nil)
+		return
+	}
+	algorithm, err := s.tokens.Keys.Algorithm()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+	httpJSON(w, model.OpenIDConfigurationResponse{
+		Issuer:                            s.tokens.Issuer,
+		AuthorizationEndpoint:             s.tokens.Issuer + "/oauth/authorize",
+		TokenEndpoint:                     s.tokens.Issuer + "/oauth/token",
+		UserInfoEndpoint:                  s.tokens.Issuer + "/userinfo",
+		JWKSURI:                           s.tokens.Issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             s.tokens.Issuer + "/introspect",
+		ScopesSupported:                   []string{scopeOpenID, scopeProfile, scopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{service.GrantAuthorizationCode, service.GrantRefreshToken, service.GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{algorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{utils.PKCEMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "email", "email_verified"},
+	})
+}
+
+// UserInfoHandler is a HTTP handler, which returns claims about the user
+// released by the scopes of the access token. The token must be granted `openid` scope.
+func (s *Server) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UserInfoHandler")
+	method := r.Method
+	if method != `GET` && method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method GET or POST is expected`, nil)
+		return
+	}
+	claims := requestClaims(r)
+	scope, _ := claims.Get("scope").(string)
+	if !utils.HasScope(scope, scopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		httpError(w, http.StatusForbidden, `openid scope is required`, nil)
+		return
+	}
+	userID, _ := claims.Subject()
+	user, err := s.usrSvc.Lookup(userID)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			httpError(w, http.StatusUnauthorized, `user not found`, nil)
+			return
+		}
+		httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		return
+	}
+
+	res := model.UserInfoResponse{Subject: user.ID}
+	if utils.HasScope(scope, scopeProfile) {
+		res.PreferredUsername = user.Name
+	}
+	if utils.HasScope(scope, scopeEmail) && user.Email != "" {
+		res.Email = user.Email
+		res.EmailVerified = &user.EmailVerified
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpJSON(w, res)
+}
@@ -0,0 +1,136 @@
+package authapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SermoDigital/jose/jws"
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+func TestOpenIDConfigurationHandler(t *testing.T) {
+	res, err := http.Get(ts.URL + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var configuration model.OpenIDConfigurationResponse
+	if err := json.NewDecoder(res.Body).Decode(&configuration); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if configuration.Issuer != s.TokenIssuer().Issuer || configuration.JWKSURI != s.TokenIssuer().Issuer+"/.well-known/jwks.json" {
+		t.Errorf("unexpected configuration: %v", configuration)
+		return
+	}
+}
+
+func TestOpenIDConnectFlow(t *testing.T) {
+	req := authorizedRequest(t, "POST", "/client", strings.NewReader(`{"name": "OIDC App", "redirect_uris": ["http://localhost:9999/cb"], "scopes": ["openid", "profile"]}`), "adminID", true)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var createClientResponse model.CreateClientResponse
+	if err := json.NewDecoder(res.Body).Decode(&createClientResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	clientID := createClientResponse.Client.ID
+
+	verifier := strings.Repeat("v", 43)
+	res = postAuthorize(t, noRedirectClient, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"scope":                 {"openid profile"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {utils.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+		"id":                    {"lookupID"},
+		"password":              {"testpasswd"},
+		"action":                {"allow"},
+	})
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	res, err = http.PostForm(ts.URL+"/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {"http://localhost:9999/cb"},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var tokenResponse model.OAuthTokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&tokenResponse); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if tokenResponse.IDToken == "" {
+		t.Errorf("id_token is expected: %v", tokenResponse)
+		return
+	}
+
+	idToken, err := jws.ParseJWT([]byte(tokenResponse.IDToken))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	claims := idToken.Claims()
+	iss, _ := claims.Issuer()
+	sub, _ := claims.Subject()
+	aud, _ := claims.Audience()
+	authTime, _ := claims.Get("auth_time").(float64)
+	if iss != s.TokenIssuer().Issuer || sub != "lookupID" || len(aud) != 1 || aud[0] != clientID || claims.Get("nonce") != "n-0S6_WzA2Mj" {
+		t.Errorf("unexpected claims: %v", claims)
+		return
+	}
+	if d := time.Since(time.Unix(int64(authTime), 0)); d < 0 || d > time.Minute {
+		t.Errorf("unexpected auth_time: %v", claims.Get("auth_time"))
+		return
+	}
+	// ID tokens are not access tokens
+	if verifyAudience(t, tokenResponse.IDToken, clientID) {
+		t.Errorf("ID token is accepted as access token")
+		return
+	}
+
+	req, _ = http.NewRequest("GET", ts.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenResponse.AccessToken)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var userInfo model.UserInfoResponse
+	if err := json.NewDecoder(res.Body).Decode(&userInfo); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if userInfo.Subject != "lookupID" || userInfo.PreferredUsername != "lookupuser" {
+		t.Errorf("unexpected userinfo: %v", userInfo)
+		return
+	}
+
+	// tokens issued by /auth are not granted openid scope
+	res, err = http.DefaultClient.Do(authorizedRequest(t, "GET", "/userinfo", nil, "lookupID", false))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if res.StatusCode != 403 || !strings.Contains(res.Header.Get("WWW-Authenticate"), "insufficient_scope") {
+		t.Errorf("status 403 Forbidden is expected, but %s", res.Status)
+		return
+	}
+}
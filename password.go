@@ -0,0 +1,77 @@
+package authapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/service"
+	"github.com/pkg/errors"
+)
+
+// forgotPasswordMessage is returned whether the user exists or not
+const forgotPasswordMessage = `a password reset token is sent if the user has an email address`
+
+// ForgotPasswordHandler is a HTTP handler, which mails a password reset token to an user.
+// The response does not tell whether the user exists.
+func (s *Server) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ForgotPasswordHandler")
+
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	var forgotPasswordRequest model.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&forgotPasswordRequest); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+		return
+	}
+	if forgotPasswordRequest.ID == "" {
+		httpError(w, http.StatusBadRequest, `id is required`, nil)
+		return
+	}
+	if err := s.resetSvc.RequestReset(forgotPasswordRequest.ID); err != nil {
+		// failing to deliver would tell the user exists
+		log.Printf("requesting password reset of %s: %s", forgotPasswordRequest.ID, err)
+	}
+	httpJSON(w, map[string]string{"message": forgotPasswordMessage})
+}
+
+// ResetPasswordHandler is a HTTP handler, which resets the password of an user with
+// a token mailed by ForgotPasswordHandler. Tokens issued to the user are revoked.
+func (s *Server) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ResetPasswordHandler")
+
+	method := r.Method
+	if method != `POST` {
+		httpError(w, http.StatusMethodNotAllowed, `method POST is expected`, nil)
+		return
+	}
+	var resetPasswordRequest model.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&resetPasswordRequest); err != nil {
+		httpError(w, http.StatusBadRequest, `invalid json request`, nil)
+		return
+	}
+	if resetPasswordRequest.Token == "" || resetPasswordRequest.Password == "" {
+		httpError(w, http.StatusBadRequest, `token and password are required`, nil)
+		return
+	}
+	id, err := s.resetSvc.Reset(resetPasswordRequest.Token, resetPasswordRequest.Password)
+	if err != nil {
+		if errors.Cause(err) == service.ErrInvalidResetToken {
+			httpError(w, http.StatusBadRequest, `password reset token is invalid`, nil)
+			return
+		}
+		if !userError(w, err) {
+			httpError(w, http.StatusInternalServerError, `internal server error`, err)
+		}
+		return
+	}
+	if err := s.tokenSvc.RevokeUser(id); err != nil {
+		httpError(w, http.StatusInternalServerError, `revoking tokens`, err)
+		return
+	}
+	httpJSON(w, map[string]string{"message": "success"})
+}
@@ -0,0 +1,236 @@
+package authapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+var resetTokenPattern = regexp.MustCompile(`Your reset token is:\s+(\S+)`)
+
+// postJSON posts the JSON body and returns the status code and the message
+func postJSON(t *testing.T, path, body string) (int, string) {
+	res, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer res.Body.Close()
+	var v struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return res.StatusCode, v.Message
+}
+
+func TestPasswordResetHandlers(t *testing.T) {
+	if err := usrSvc.Create(&db.User{ID: "resetID", Name: "resetuser", Password: "testpasswd", Email: "reset@example.com"}); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer usrSvc.Delete("resetID")
+	token, err := s.TokenIssuer().GenerateToken(&utils.TokenSubject{ID: "resetID", Username: "resetuser"})
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+
+	// the response does not tell whether the user exists
+	sent := len(mailSender.Messages())
+	status, unknownMessage := postJSON(t, "/password/forgot", `{"id": "unknownID"}`)
+	if status != 200 || len(mailSender.Messages()) != sent {
+		t.Errorf("status 200 OK without mail is expected, but %d", status)
+		return
+	}
+	for i := 0; i < 2; i++ {
+		status, message := postJSON(t, "/password/forgot", `{"id": "resetID"}`)
+		if status != 200 || message != unknownMessage {
+			t.Errorf("unexpected response: %d %s", status, message)
+			return
+		}
+	}
+	messages := mailSender.Messages()
+	if len(messages) != sent+2 || messages[sent].To != "reset@example.com" {
+		t.Errorf("unexpected messages: %v", messages[sent:])
+		return
+	}
+	first := resetTokenPattern.FindStringSubmatch(messages[sent].Body)
+	second := resetTokenPattern.FindStringSubmatch(messages[sent+1].Body)
+	if first == nil || second == nil {
+		t.Errorf("reset token is not found: %v", messages[sent:])
+		return
+	}
+
+	// tokens mailed before are replaced
+	if status, _ := postJSON(t, "/password/reset", `{"token": "`+first[1]+`", "password": "newpasswd"}`); status != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %d", status)
+		return
+	}
+	if status, message := postJSON(t, "/password/reset", `{"token": "`+second[1]+`", "password": "newpasswd"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d: %s", status, message)
+		return
+	}
+	// tokens are single-use
+	if status, _ := postJSON(t, "/password/reset", `{"token": "`+second[1]+`", "password": "otherpasswd"}`); status != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %d", status)
+		return
+	}
+	if verify(t, token) {
+		t.Errorf("token issued before reset should be revoked")
+		return
+	}
+	if _, err := usrSvc.Authenticate("resetID", "newpasswd"); err != nil {
+		t.Errorf("new password is not accepted: %s", err)
+		return
+	}
+	if _, err := usrSvc.Authenticate("resetID", "testpasswd"); err == nil {
+		t.Errorf("old password is accepted")
+		return
+	}
+}
+
+func TestPasswordPolicyViolations(t *testing.T) {
+	s.SetPasswordPolicy(&utils.PasswordPolicy{
+		MinLength:       8,
+		MaxLength:       64,
+		RequiredClasses: []string{utils.CharacterDigit},
+		RejectUserInfo:  true,
+		Breached:        &utils.BreachedPasswordCorpus{Dir: "./test/breached"},
+	})
+	defer s.SetPasswordPolicy(&utils.PasswordPolicy{MinLength: 8, MaxLength: 128, RejectUserInfo: true})
+
+	for _, c := range []struct {
+		body  string
+		rules []string
+	}{
+		{`{"id": "policyID", "username": "policyuser", "password": ""}`, []string{utils.RuleMinLength, utils.RuleCharacter}},
+		{`{"id": "policyID", "username": "policyuser1", "password": "PolicyUser1"}`, []string{utils.RuleUserInfo}},
+		{`{"id": "policyID", "username": "policyuser", "password": "letmein12345"}`, []string{utils.RuleBreached}},
+	} {
+		res, err := http.Post(ts.URL+"/user", "application/json", strings.NewReader(c.body))
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		var policyError model.PasswordPolicyErrorResponse
+		if err := json.NewDecoder(res.Body).Decode(&policyError); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		res.Body.Close()
+		var rules []string
+		for _, v := range policyError.Violations {
+			rules = append(rules, v.Rule)
+		}
+		if res.StatusCode != 400 || !reflect.DeepEqual(rules, c.rules) {
+			t.Errorf("status 400 with %v is expected, but %s with %v", c.rules, res.Status, policyError)
+			return
+		}
+	}
+	if _, err := usrSvc.Lookup("policyID"); err == nil {
+		t.Errorf("user with invalid password is created")
+		return
+	}
+
+	// a reset token is not consumed by a password violating the policy
+	if err := usrSvc.Create(&db.User{ID: "policyID", Name: "policyuser", Password: "testpasswd", Email: "policy@example.com"}); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer usrSvc.Delete("policyID")
+	if status, _ := postJSON(t, "/password/forgot", `{"id": "policyID"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d", status)
+		return
+	}
+	messages := mailSender.Messages()
+	token := resetTokenPattern.FindStringSubmatch(messages[len(messages)-1].Body)
+	if token == nil {
+		t.Errorf("reset token is not found: %v", messages[len(messages)-1])
+		return
+	}
+	if status, _ := postJSON(t, "/password/reset", `{"token": "`+token[1]+`", "password": "password"}`); status != 400 {
+		t.Errorf("status 400 Bad Request is expected, but %d", status)
+		return
+	}
+	if status, message := postJSON(t, "/password/reset", `{"token": "`+token[1]+`", "password": "n3wpassword"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d: %s", status, message)
+		return
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	if status, message := postJSON(t, "/user", `{"id": "historyID", "username": "historyuser", "password": "firstpasswd", "email": "history@example.com"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d: %s", status, message)
+		return
+	}
+	defer usrSvc.Delete("historyID")
+
+	// updateUser returns the status code and the violated rules
+	updateUser := func(password string) (int, []string) {
+		req := authorizedRequest(t, "PUT", "/user/historyID", strings.NewReader(`{"username": "historyuser", "new_password": "`+password+`"}`), "historyID", false)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer res.Body.Close()
+		var policyError model.PasswordPolicyErrorResponse
+		if err := json.NewDecoder(res.Body).Decode(&policyError); err != nil {
+			t.Fatalf("%s", err)
+		}
+		var rules []string
+		for _, v := range policyError.Violations {
+			rules = append(rules, v.Rule)
+		}
+		return res.StatusCode, rules
+	}
+	if status, rules := updateUser("secondpasswd"); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d: %v", status, rules)
+		return
+	}
+	if status, rules := updateUser("firstpasswd"); status != 400 || !reflect.DeepEqual(rules, []string{utils.RuleHistory}) {
+		t.Errorf("status 400 with history is expected, but %d with %v", status, rules)
+		return
+	}
+	// other fields can be updated with the current password, or without a password
+	for _, password := range []string{"secondpasswd", ""} {
+		if status, rules := updateUser(password); status != 200 {
+			t.Errorf("status 200 OK is expected for %q, but %d: %v", password, status, rules)
+			return
+		}
+	}
+	if _, err := usrSvc.Authenticate("historyID", "secondpasswd"); err != nil {
+		t.Errorf("password is changed by update without a password: %s", err)
+		return
+	}
+
+	// a reset token is not consumed by a reused password
+	if status, _ := postJSON(t, "/password/forgot", `{"id": "historyID"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d", status)
+		return
+	}
+	messages := mailSender.Messages()
+	token := resetTokenPattern.FindStringSubmatch(messages[len(messages)-1].Body)
+	if token == nil {
+		t.Errorf("reset token is not found: %v", messages[len(messages)-1])
+		return
+	}
+	for _, password := range []string{"firstpasswd", "secondpasswd"} {
+		if status, _ := postJSON(t, "/password/reset", `{"token": "`+token[1]+`", "password": "`+password+`"}`); status != 400 {
+			t.Errorf("status 400 Bad Request is expected for %s, but %d", password, status)
+			return
+		}
+	}
+	if status, message := postJSON(t, "/password/reset", `{"token": "`+token[1]+`", "password": "thirdpasswd"}`); status != 200 {
+		t.Errorf("status 200 OK is expected, but %d: %s", status, message)
+		return
+	}
+}
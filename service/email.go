@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/charakoba-com/auth-api/mail"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// SendVerification mails a signed link verifying the email address of the user.
+// ErrNoEmail and ErrEmailVerified are returned if there is nothing to verify.
+func (v *EmailVerificationService) SendVerification(userID string) error {
+	log.Printf("service.EmailVerification.SendVerification %s", userID)
+
+	du, err := v.Store.LookupUser(userID)
+	if err != nil {
+		return errors.Wrap(err, `loading db.User`)
+	}
+	if du.Email == "" {
+		return ErrNoEmail
+	}
+	if du.EmailVerified {
+		return ErrEmailVerified
+	}
+
+	token, err := v.Tokens.GenerateEmailVerificationToken(du.ID, du.Email)
+	if err != nil {
+		return errors.Wrap(err, `generating email verification token`)
+	}
+	u, err := url.Parse(v.VerifyURL)
+	if err != nil {
+		return errors.Wrap(err, `parsing verify URL`)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+
+	body := bytes.Buffer{}
+	fmt.Fprintf(&body, "Hello %s,\n\n", du.Name)
+	body.WriteString("Open the following link to verify your email address:\n\n")
+	fmt.Fprintf(&body, "%s\n\n", u)
+	fmt.Fprintf(&body, "It expires in %s.\n", v.Tokens.EmailVerificationTokenLifetime)
+	body.WriteString("If you did not register this address, you can ignore this mail.\n")
+
+	if err := v.Sender.Send(&mail.Message{
+		From:    v.From,
+		To:      du.Email,
+		Subject: `Verify your email address`,
+		Body:    body.String(),
+	}); err != nil {
+		return errors.Wrap(err, `sending verification mail`)
+	}
+	return nil
+}
+
+// Verify marks the email address the token is issued for as verified, and returns
+// the user ID. ErrInvalidVerificationToken is returned if the token is invalid or
+// the address of the user has been changed since.
+func (v *EmailVerificationService) Verify(token string) (string, error) {
+	log.Printf("service.EmailVerification.Verify")
+
+	parsed, err := utils.ParseToken(token)
+	if err != nil {
+		return "", ErrInvalidVerificationToken
+	}
+	userID, email, err := v.Tokens.ValidateEmailVerificationToken(parsed)
+	if err != nil {
+		log.Printf("validating email verification token: %s", err)
+		return "", ErrInvalidVerificationToken
+	}
+	if err := v.Store.VerifyUserEmail(userID, email); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return "", ErrInvalidVerificationToken
+		}
+		return "", errors.Wrap(err, `verifying email address`)
+	}
+	return userID, nil
+}
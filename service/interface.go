@@ -1,7 +1,186 @@
 package service
 
+import (
+	"sync"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/keymgr"
+	"github.com/charakoba-com/auth-api/mail"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// errors returned by services
+var (
+	ErrAuthFailed               = errors.New(`authentication failed`)
+	ErrInvalidRefreshToken      = errors.New(`refresh token is invalid`)
+	ErrRefreshTokenReused       = errors.New(`refresh token has been reused`)
+	ErrMFAEnabled               = errors.New(`second factor has been enabled already`)
+	ErrMFANotEnrolled           = errors.New(`second factor is not enrolled`)
+	ErrInvalidMFACode           = errors.New(`second factor code is invalid`)
+	ErrAccountLocked            = errors.New(`account is locked`)
+	ErrTooManyAttempts          = errors.New(`too many failed attempts`)
+	ErrInvalidClient            = errors.New(`client authentication failed`)
+	ErrInvalidRedirectURI       = errors.New(`redirect URI is not registered`)
+	ErrInvalidGrant             = errors.New(`authorization grant is invalid`)
+	ErrInvalidScope             = errors.New(`scope is not allowed for the client`)
+	ErrInvalidResetToken        = errors.New(`password reset token is invalid`)
+	ErrInvalidEmail             = errors.New(`email address is invalid`)
+	ErrEmailNotVerified         = errors.New(`email address is not verified`)
+	ErrEmailVerified            = errors.New(`email address has been verified already`)
+	ErrNoEmail                  = errors.New(`user has no email address`)
+	ErrInvalidVerificationToken = errors.New(`email verification token is invalid`)
+	ErrPasswordRequired         = errors.New(`password is required to rename user with legacy hash`)
+)
+
+// OAuth 2.0 grant types clients are allowed to use
+const (
+	GrantAuthorizationCode = `authorization_code`
+	GrantRefreshToken      = `refresh_token`
+	GrantClientCredentials = `client_credentials`
+)
+
+// DefaultGrantTypes are allowed to clients registered without grant types
+var DefaultGrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+
+// DefaultRefreshTokenLifetime is used when TokenService.RefreshTokenLifetime is zero
+const DefaultRefreshTokenLifetime = 30 * 24 * time.Hour
+
+// refreshTokenBytes is the number of random bytes in a refresh token
+const refreshTokenBytes = 32
+
+// DefaultTOTPIssuer is used when MFAService.Issuer is empty
+const DefaultTOTPIssuer = `authapi`
+
+// recoveryCodeCount is the number of recovery codes generated at enrollment
+const recoveryCodeCount = 10
+
+// DefaultAuthorizationCodeLifetime is used when OAuthService.CodeLifetime is zero
+const DefaultAuthorizationCodeLifetime = time.Minute
+
+// clientSecretBytes is the number of random bytes in a client secret
+const clientSecretBytes = 32
+
+// DefaultPasswordResetTokenLifetime is used when PasswordResetService.TokenLifetime is zero
+const DefaultPasswordResetTokenLifetime = time.Hour
+
+// resetTokenBytes is the number of random bytes in a password reset token
+const resetTokenBytes = 32
+
+// DefaultKeyRefreshInterval is used when KeyService.RefreshInterval is zero
+const DefaultKeyRefreshInterval = time.Minute
+
+// keyReloadInterval limits reloading keys for tokens signed with unknown keys
+const keyReloadInterval = time.Second
+
 // Service interface
 type Service interface{}
 
+// maxEmailLength is the length of users.email column
+const maxEmailLength = 255
+
 // UserService is a service
-type UserService struct{}
+type UserService struct {
+	Store                db.UserStore
+	Policy               *utils.PasswordPolicy // passwords are not checked if nil
+	History              *PasswordHistory      // reuse is not checked if nil
+	RequireVerifiedEmail bool                  // reject authentication of users whose email address is not verified
+}
+
+// PasswordHistory rejects passwords which are same as the current one or
+// one of the Size passwords set before. Disabled if Size is zero.
+type PasswordHistory struct {
+	Store db.PasswordHistoryStore
+	Size  int
+}
+
+// EmailVerificationService is a service which mails signed links to users,
+// proving that they receive mails at their email addresses
+type EmailVerificationService struct {
+	Store     db.UserStore
+	Tokens    *utils.TokenIssuer
+	Sender    mail.Sender
+	From      string // sender address of verification mails
+	VerifyURL string // the token is appended as `token` query parameter
+}
+
+// RoleService is a service which manages roles and their assignments
+type RoleService struct {
+	Store db.RoleStore
+}
+
+// MFAService is a service which manages second factors of users
+type MFAService struct {
+	Store  db.MFAStore
+	Issuer string // shown in authenticator apps
+}
+
+// OAuthService is a service which manages OAuth 2.0 clients, and issues
+// and exchanges authorization codes
+type OAuthService struct {
+	Store interface {
+		db.OAuthStore
+		db.RefreshTokenStore
+	}
+	CodeLifetime time.Duration
+}
+
+// PasswordResetService is a service which mails single-use tokens to users
+// who forgot their passwords, and resets passwords with them
+type PasswordResetService struct {
+	Store interface {
+		db.UserStore
+		db.PasswordResetStore
+	}
+	Policy        *utils.PasswordPolicy // passwords are not checked if nil
+	History       *PasswordHistory      // reuse is not checked if nil
+	Sender        mail.Sender
+	From          string        // sender address of reset mails
+	ResetURL      string        // the token is appended as `token` query parameter
+	TokenLifetime time.Duration // DefaultPasswordResetTokenLifetime if zero
+}
+
+// LockoutPolicy configures LockoutService. Zero fields fall back to DefaultLockoutPolicy.
+type LockoutPolicy struct {
+	MaxFailures   int           // failures of an account before it is locked
+	IPMaxFailures int           // failures from a client IP address before it is throttled
+	Duration      time.Duration // first lockout, doubled at each further failure
+	MaxDuration   time.Duration // upper limit of the exponential backoff
+	FailureWindow time.Duration // failures older than this are forgotten
+}
+
+// DefaultLockoutPolicy is used for zero fields of LockoutService.Policy
+var DefaultLockoutPolicy = LockoutPolicy{
+	MaxFailures:   5,
+	IPMaxFailures: 50,
+	Duration:      time.Minute,
+	MaxDuration:   24 * time.Hour,
+	FailureWindow: 24 * time.Hour,
+}
+
+// LockoutService is a service which locks accounts and throttles client addresses
+// after repeated authentication failures
+type LockoutService struct {
+	Store  db.LockoutStore
+	Policy LockoutPolicy
+}
+
+// TokenService is a service which issues and rotates refresh tokens,
+// and revokes access tokens
+type TokenService struct {
+	Store                db.TokenStore
+	RefreshTokenLifetime time.Duration
+}
+
+// KeyService is a service which keeps the signing key ring in the store, so that
+// rotated keys survive restarts and replicas sign and verify with the same keys
+type KeyService struct {
+	Store           db.SigningKeyStore
+	Keys            *keymgr.KeyManager
+	Passphrase      []byte        // private keys are encrypted in the store if given
+	RefreshInterval time.Duration // DefaultKeyRefreshInterval if zero
+
+	mu       sync.Mutex
+	loadedOn time.Time
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto"
+	"log"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/keymgr"
+	"github.com/pkg/errors"
+)
+
+// Load replaces the key ring by the stored keys.
+// The key ring is kept if no key has been stored, as keys are stored at the first rotation.
+func (v *KeyService) Load() error {
+	log.Printf("service.Key.Load")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.load()
+}
+
+// Refresh reloads the key ring when it is older than RefreshInterval.
+// Given the key ID of a token signed with a key which is not found, it is reloaded sooner.
+func (v *KeyService) Refresh(kid string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	interval := v.RefreshInterval
+	if interval == 0 {
+		interval = DefaultKeyRefreshInterval
+	}
+	if kid != "" {
+		interval = keyReloadInterval
+	}
+	if time.Since(v.loadedOn) < interval {
+		return nil
+	}
+	return v.load()
+}
+
+// Rotate stores the private key as the signing key, retiring current one,
+// and returns its key ID
+func (v *KeyService) Rotate(private crypto.Signer) (string, error) {
+	log.Printf("service.Key.Rotate")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	current, err := v.Keys.ActiveKey()
+	if err != nil {
+		return "", errors.Wrap(err, `loading signing key`)
+	}
+	currentPublic, err := keymgr.MarshalPublicKey(current.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	next, err := keymgr.NewKey(private, private.Public())
+	if err != nil {
+		return "", err
+	}
+	nextPrivate, err := keymgr.MarshalPrivateKey(private, v.Passphrase)
+	if err != nil {
+		return "", err
+	}
+	nextPublic, err := keymgr.MarshalPublicKey(next.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	// the private key of current one is not stored, as retired keys only verify tokens
+	if err := v.Store.RotateSigningKey(&db.SigningKey{
+		ID:        current.ID,
+		PublicKey: string(currentPublic),
+		CreatedOn: current.CreatedOn,
+	}, &db.SigningKey{
+		ID:         next.ID,
+		PrivateKey: string(nextPrivate),
+		PublicKey:  string(nextPublic),
+		CreatedOn:  time.Now(),
+	}); err != nil {
+		return "", errors.Wrap(err, `storing signing key`)
+	}
+	if err := v.load(); err != nil {
+		return "", err
+	}
+	return next.ID, nil
+}
+
+// load replaces the key ring by the stored keys. The caller must hold the lock.
+func (v *KeyService) load() error {
+	stored, err := v.Store.ListupSigningKeys()
+	if err != nil {
+		return errors.Wrap(err, `loading signing keys`)
+	}
+	v.loadedOn = time.Now()
+	if len(stored) == 0 {
+		return nil
+	}
+
+	keys := make([]keymgr.Key, len(stored))
+	for i, sk := range stored {
+		public, err := keymgr.ParsePublicKey([]byte(sk.PublicKey))
+		if err != nil {
+			return errors.Wrapf(err, `parsing public key %s`, sk.ID)
+		}
+		// only the active key signs, whose private key is decrypted
+		var signer crypto.Signer
+		if !sk.RetiredOn.Valid {
+			if signer, err = keymgr.ParsePrivateKeyWithPassphrase([]byte(sk.PrivateKey), v.Passphrase); err != nil {
+				return errors.Wrapf(err, `parsing private key %s`, sk.ID)
+			}
+		}
+		key, err := keymgr.NewKey(signer, public)
+		if err != nil {
+			return err
+		}
+		key.CreatedOn = sk.CreatedOn
+		if sk.RetiredOn.Valid {
+			key.RetiredOn = sk.RetiredOn.Time
+		}
+		keys[i] = *key
+	}
+	return v.Keys.Replace(keys)
+}
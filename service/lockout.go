@@ -0,0 +1,103 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/pkg/errors"
+)
+
+// Check returns ErrAccountLocked or ErrTooManyAttempts with the time
+// until which the user or the client address is not allowed to authenticate
+func (v *LockoutService) Check(userID, ip string) (time.Time, error) {
+	now := time.Now()
+	for _, c := range []struct {
+		key string
+		err error
+	}{
+		{db.UserAttemptKey(userID), ErrAccountLocked},
+		{db.IPAttemptKey(ip), ErrTooManyAttempts},
+	} {
+		a, err := v.Store.LookupLoginAttempt(c.key)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, `loading db.LoginAttempt`)
+		}
+		if a.LockedUntil.After(now) {
+			return a.LockedUntil, c.err
+		}
+	}
+	return time.Time{}, nil
+}
+
+// Fail records a failed authentication of the user from the client address,
+// and locks them when failures reach the threshold
+func (v *LockoutService) Fail(userID, ip string) error {
+	log.Printf("service.Lockout.Fail %s %s", userID, ip)
+
+	policy := v.policy()
+	if err := v.fail(db.UserAttemptKey(userID), policy.MaxFailures, policy); err != nil {
+		return err
+	}
+	return v.fail(db.IPAttemptKey(ip), policy.IPMaxFailures, policy)
+}
+
+// Succeed forgets failures of the user after a complete authentication.
+// Failures of the client address are kept, so that valid credentials
+// cannot be used to reset throttling while guessing others.
+func (v *LockoutService) Succeed(userID string) error {
+	if err := v.Store.ResetLoginAttempts(db.UserAttemptKey(userID)); err != nil {
+		return errors.Wrap(err, `resetting login attempts`)
+	}
+	return nil
+}
+
+// Unlock the user
+func (v *LockoutService) Unlock(userID string) error {
+	log.Printf("service.Lockout.Unlock %s", userID)
+	return v.Succeed(userID)
+}
+
+func (v *LockoutService) fail(key string, threshold int, policy LockoutPolicy) error {
+	now := time.Now()
+	a, err := v.Store.AddLoginFailure(key, now, now.Add(-policy.FailureWindow))
+	if err != nil {
+		return errors.Wrap(err, `recording login failure`)
+	}
+	if a.Failures < threshold {
+		return nil
+	}
+	// exponential backoff: Duration, 2*Duration, 4*Duration, ...
+	d := policy.Duration
+	for i := threshold; i < a.Failures && d < policy.MaxDuration; i++ {
+		d *= 2
+	}
+	if d > policy.MaxDuration {
+		d = policy.MaxDuration
+	}
+	log.Printf("locking %s for %s after %d failures", key, d, a.Failures)
+	if err := v.Store.LockLogin(key, now.Add(d)); err != nil {
+		return errors.Wrap(err, `locking login`)
+	}
+	return nil
+}
+
+func (v *LockoutService) policy() LockoutPolicy {
+	p := v.Policy
+	if p.MaxFailures <= 0 {
+		p.MaxFailures = DefaultLockoutPolicy.MaxFailures
+	}
+	if p.IPMaxFailures <= 0 {
+		p.IPMaxFailures = DefaultLockoutPolicy.IPMaxFailures
+	}
+	if p.Duration <= 0 {
+		p.Duration = DefaultLockoutPolicy.Duration
+	}
+	if p.MaxDuration <= 0 {
+		p.MaxDuration = DefaultLockoutPolicy.MaxDuration
+	}
+	if p.FailureWindow <= 0 {
+		p.FailureWindow = DefaultLockoutPolicy.FailureWindow
+	}
+	return p
+}
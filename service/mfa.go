@@ -0,0 +1,145 @@
+package service
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// EnrollTOTP generates a TOTP secret and recovery codes for the user.
+// The authenticator is not required at authentication until ConfirmTOTP succeeds.
+// Enrolling again before confirmation replaces the pending one.
+func (v *MFAService) EnrollTOTP(userID, account string) (secret, uri string, recoveryCodes []string, err error) {
+	log.Printf("service.MFA.EnrollTOTP %s", userID)
+
+	enabled, err := v.Enabled(userID)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if enabled {
+		return "", "", nil, ErrMFAEnabled
+	}
+
+	secret, err = utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, `generating TOTP secret`)
+	}
+	hashes := make([]string, recoveryCodeCount)
+	recoveryCodes = make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		code, err := utils.RandomRecoveryCode()
+		if err != nil {
+			return "", "", nil, errors.Wrap(err, `generating recovery code`)
+		}
+		recoveryCodes[i] = code
+		hashes[i] = utils.HashToken(utils.NormalizeRecoveryCode(code))
+	}
+	if err := v.Store.CreateTOTP(&db.TOTP{UserID: userID, Secret: secret}, hashes); err != nil {
+		return "", "", nil, errors.Wrap(err, `creating db.TOTP`)
+	}
+
+	issuer := v.Issuer
+	if issuer == "" {
+		issuer = DefaultTOTPIssuer
+	}
+	return secret, utils.TOTPProvisioningURI(issuer, account, secret), recoveryCodes, nil
+}
+
+// ConfirmTOTP enables the enrolled authenticator with a code generated by it
+func (v *MFAService) ConfirmTOTP(userID, code string) error {
+	log.Printf("service.MFA.ConfirmTOTP %s", userID)
+
+	t, err := v.lookup(userID)
+	if err != nil {
+		return err
+	}
+	if t.Confirmed {
+		return ErrMFAEnabled
+	}
+	if err := v.verifyTOTP(t, code); err != nil {
+		return err
+	}
+	if err := v.Store.ConfirmTOTP(userID); err != nil {
+		return errors.Wrap(err, `confirming db.TOTP`)
+	}
+	return nil
+}
+
+// Enabled reports whether the user has to pass the second factor
+func (v *MFAService) Enabled(userID string) (bool, error) {
+	t, err := v.Store.LookupTOTP(userID)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, errors.Wrap(err, `loading db.TOTP`)
+	}
+	return t.Confirmed, nil
+}
+
+// Verify the second factor with a TOTP code or an unused recovery code
+func (v *MFAService) Verify(userID, code string) error {
+	log.Printf("service.MFA.Verify %s", userID)
+
+	t, err := v.lookup(userID)
+	if err != nil {
+		return err
+	}
+	if !t.Confirmed {
+		return ErrMFANotEnrolled
+	}
+	if len(code) == utils.TOTPDigits {
+		return v.verifyTOTP(t, code)
+	}
+	hash := utils.HashToken(utils.NormalizeRecoveryCode(code))
+	if err := v.Store.UseRecoveryCode(userID, hash); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return ErrInvalidMFACode
+		}
+		return errors.Wrap(err, `using recovery code`)
+	}
+	return nil
+}
+
+// Reset removes the second factor of the user
+func (v *MFAService) Reset(userID string) error {
+	log.Printf("service.MFA.Reset %s", userID)
+
+	if err := v.Store.DeleteTOTP(userID); err != nil {
+		return errors.Wrap(err, `deleting db.TOTP`)
+	}
+	return nil
+}
+
+func (v *MFAService) lookup(userID string) (*db.TOTP, error) {
+	t, err := v.Store.LookupTOTP(userID)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, errors.Wrap(err, `loading db.TOTP`)
+	}
+	return t, nil
+}
+
+// verifyTOTP accepts each time step only once to prevent replay of observed codes
+func (v *MFAService) verifyTOTP(t *db.TOTP, code string) error {
+	step, ok, err := utils.ValidateTOTP(t.Secret, code, time.Now())
+	if err != nil {
+		return errors.Wrap(err, `validating TOTP code`)
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	if err := v.Store.UseTOTPStep(t.UserID, step); err != nil {
+		if errors.Cause(err) == db.ErrTOTPStepUsed {
+			return ErrInvalidMFACode
+		}
+		return errors.Wrap(err, `recording TOTP step`)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package service
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// RegisterClient registers an OAuth client with a random client ID.
+// The secret of a confidential client is returned only here.
+func (v *OAuthService) RegisterClient(mc *model.Client) (string, error) {
+	log.Printf("service.OAuth.RegisterClient %s", mc.Name)
+
+	if len(mc.GrantTypes) == 0 {
+		mc.GrantTypes = DefaultGrantTypes
+	}
+	if err := validateClient(mc); err != nil {
+		return "", err
+	}
+	id, err := utils.RandomToken(16)
+	if err != nil {
+		return "", errors.Wrap(err, `generating client ID`)
+	}
+	mc.ID = id
+
+	var dc db.Client
+	if err := mc.ToDB(&dc); err != nil {
+		return "", errors.Wrap(err, `converting model.Client to db.Client`)
+	}
+	var secret string
+	if mc.Confidential {
+		secret, err = utils.RandomToken(clientSecretBytes)
+		if err != nil {
+			return "", errors.Wrap(err, `generating client secret`)
+		}
+		dc.SecretHash = utils.HashToken(secret)
+	}
+	if err := v.Store.CreateClient(&dc); err != nil {
+		return "", errors.Wrap(err, `creating db.Client`)
+	}
+	return secret, nil
+}
+
+// LookupClient loads an OAuth client
+func (v *OAuthService) LookupClient(id string) (*model.Client, error) {
+	log.Printf("service.OAuth.LookupClient %s", id)
+
+	dc, err := v.Store.LookupClient(id)
+	if err != nil {
+		return nil, errors.Wrap(err, `loading db.Client`)
+	}
+	var mc model.Client
+	if err := mc.FromDB(dc); err != nil {
+		return nil, errors.Wrap(err, `converting db.Client to model.Client`)
+	}
+	return &mc, nil
+}
+
+// UpdateClient replaces attributes of the client. Whether the client is confidential
+// is not changed.
+func (v *OAuthService) UpdateClient(mc *model.Client) error {
+	log.Printf("service.OAuth.UpdateClient %s", mc.ID)
+
+	stored, err := v.LookupClient(mc.ID)
+	if err != nil {
+		return err
+	}
+	mc.Confidential = stored.Confidential
+	if len(mc.GrantTypes) == 0 {
+		mc.GrantTypes = DefaultGrantTypes
+	}
+	if err := validateClient(mc); err != nil {
+		return err
+	}
+	var dc db.Client
+	if err := mc.ToDB(&dc); err != nil {
+		return errors.Wrap(err, `converting model.Client to db.Client`)
+	}
+	if err := v.Store.UpdateClient(&dc); err != nil {
+		return errors.Wrap(err, `updating db.Client`)
+	}
+	return nil
+}
+
+// DeleteClient deletes the client. Refresh tokens issued to it are revoked.
+func (v *OAuthService) DeleteClient(id string) error {
+	log.Printf("service.OAuth.DeleteClient %s", id)
+
+	if err := v.Store.DeleteClient(id); err != nil {
+		return errors.Wrap(err, `deleting db.Client`)
+	}
+	return nil
+}
+
+// ListupClients returns all clients
+func (v *OAuthService) ListupClients() (model.ClientList, error) {
+	log.Printf("service.OAuth.ListupClients")
+
+	dl, err := v.Store.ListupClients()
+	if err != nil {
+		return nil, errors.Wrap(err, `listing db.Client`)
+	}
+	l := make(model.ClientList, len(dl))
+	for i := range dl {
+		if err := l[i].FromDB(&dl[i]); err != nil {
+			return nil, errors.Wrap(err, `converting db.Client to model.Client`)
+		}
+	}
+	return l, nil
+}
+
+// GrantScope returns the scope granted to the client for the requested one.
+// Clients are granted all of their allowed scopes by default, and clients without
+// allowed scopes are granted none. ErrInvalidScope is returned for a scope not allowed.
+func (v *OAuthService) GrantScope(mc *model.Client, requested string) (string, error) {
+	scopes := utils.ParseScope(requested)
+	if len(scopes) == 0 {
+		return strings.Join(mc.Scopes, " "), nil
+	}
+	allowed := strings.Join(mc.Scopes, " ")
+	for _, s := range scopes {
+		if !utils.HasScope(allowed, s) {
+			return "", ErrInvalidScope
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// AuthenticateClient authenticates a client at the token endpoint.
+// Confidential clients must present their secret, and public clients must not present one.
+func (v *OAuthService) AuthenticateClient(id, secret string) (*model.Client, error) {
+	log.Printf("service.OAuth.AuthenticateClient %s", id)
+
+	dc, err := v.Store.LookupClient(id)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ErrInvalidClient
+		}
+		return nil, errors.Wrap(err, `loading db.Client`)
+	}
+	if dc.SecretHash == "" {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+	} else if subtle.ConstantTimeCompare([]byte(utils.HashToken(secret)), []byte(dc.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	var mc model.Client
+	if err := mc.FromDB(dc); err != nil {
+		return nil, errors.Wrap(err, `converting db.Client to model.Client`)
+	}
+	return &mc, nil
+}
+
+// RedirectURI returns the redirect URI of an authorization request, which must
+// exactly match a registered one. It can be omitted if only one is registered.
+func (v *OAuthService) RedirectURI(mc *model.Client, uri string) (string, error) {
+	if uri == "" && len(mc.RedirectURIs) == 1 {
+		return mc.RedirectURIs[0], nil
+	}
+	for _, registered := range mc.RedirectURIs {
+		if uri == registered {
+			return uri, nil
+		}
+	}
+	return "", ErrInvalidRedirectURI
+}
+
+// IssueAuthorizationCode issues a short-lived authorization code bound to the client,
+// the redirect URI and the PKCE code challenge. The redirect URI is the one given in the
+// authorization request, or empty if it was omitted. The nonce is given back in the ID token.
+func (v *OAuthService) IssueAuthorizationCode(clientID, userID, redirectURI, scope, challenge, nonce string) (string, error) {
+	log.Printf("service.OAuth.IssueAuthorizationCode %s %s", clientID, userID)
+
+	if !utils.ValidPKCEChallenge(challenge) {
+		return "", errors.New(`code challenge is not valid`)
+	}
+	code, err := utils.RandomToken(32)
+	if err != nil {
+		return "", errors.Wrap(err, `generating authorization code`)
+	}
+	familyID, err := utils.RandomToken(refreshTokenBytes)
+	if err != nil {
+		return "", errors.Wrap(err, `generating token family ID`)
+	}
+	lifetime := v.CodeLifetime
+	if lifetime == 0 {
+		lifetime = DefaultAuthorizationCodeLifetime
+	}
+	now := time.Now()
+	dc := db.AuthorizationCode{
+		Hash:          utils.HashToken(code),
+		ClientID:      clientID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scope:         scope,
+		CodeChallenge: challenge,
+		Nonce:         nonce,
+		FamilyID:      familyID,
+		CreatedOn:     now,
+		ExpiresOn:     now.Add(lifetime),
+	}
+	if err := v.Store.CreateAuthorizationCode(&dc); err != nil {
+		return "", errors.Wrap(err, `creating db.AuthorizationCode`)
+	}
+	return code, nil
+}
+
+// ExchangeAuthorizationCode consumes the code and returns it, which tells the user and
+// the scope it was issued for. ErrInvalidGrant is returned unless the code is unused, unexpired,
+// issued to the client for the redirect URI, and the code verifier matches the challenge.
+// The redirect URI is compared only if it was given in the authorization request (RFC 6749 section 4.1.3).
+// A code presented by another client is not consumed. When a used code is presented, the token family
+// issued for the code is revoked, as either the client or an attacker holds a stolen code (RFC 6749 section 4.1.2).
+func (v *OAuthService) ExchangeAuthorizationCode(clientID, code, redirectURI, verifier string) (*db.AuthorizationCode, error) {
+	log.Printf("service.OAuth.ExchangeAuthorizationCode %s", clientID)
+
+	hash := utils.HashToken(code)
+	dc, err := v.Store.LookupAuthorizationCode(hash)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ErrInvalidGrant
+		}
+		return nil, errors.Wrap(err, `loading db.AuthorizationCode`)
+	}
+	if dc.ClientID != clientID {
+		return nil, ErrInvalidGrant
+	}
+	if dc.Used {
+		return nil, v.revokeReused(dc)
+	}
+	consumed, err := v.Store.ConsumeAuthorizationCode(hash)
+	if err != nil {
+		switch errors.Cause(err) {
+		case sql.ErrNoRows:
+			return nil, ErrInvalidGrant
+		case db.ErrAuthorizationCodeUsed:
+			// another request consumed it concurrently
+			return nil, v.revokeReused(dc)
+		}
+		return nil, errors.Wrap(err, `consuming db.AuthorizationCode`)
+	}
+	dc = consumed
+	if time.Now().After(dc.ExpiresOn) || (dc.RedirectURI != "" && dc.RedirectURI != redirectURI) {
+		return nil, ErrInvalidGrant
+	}
+	if !utils.VerifyPKCE(verifier, dc.CodeChallenge) {
+		return nil, ErrInvalidGrant
+	}
+	return dc, nil
+}
+
+func (v *OAuthService) revokeReused(dc *db.AuthorizationCode) error {
+	log.Printf("authorization code reuse detected: revoking token family of client %s", dc.ClientID)
+	if dc.FamilyID == "" {
+		// issued before token families were recorded on codes
+		return ErrInvalidGrant
+	}
+	if err := v.Store.RevokeRefreshTokenFamily(dc.FamilyID); err != nil {
+		return errors.Wrap(err, `revoking token family`)
+	}
+	return ErrInvalidGrant
+}
+
+// validateClient checks attributes of a client being registered or updated
+func validateClient(mc *model.Client) error {
+	if mc.Name == "" {
+		return errors.New(`client name is required`)
+	}
+	for _, gt := range mc.GrantTypes {
+		switch gt {
+		case GrantAuthorizationCode, GrantRefreshToken:
+		case GrantClientCredentials:
+			if !mc.Confidential {
+				return errors.New(`client_credentials grant is allowed only to confidential clients`)
+			}
+		default:
+			return errors.Errorf(`unsupported grant type: %s`, gt)
+		}
+	}
+	if mc.AllowsGrantType(GrantAuthorizationCode) && len(mc.RedirectURIs) == 0 {
+		return errors.New(`at least one redirect URI is required`)
+	}
+	for _, uri := range mc.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return err
+		}
+	}
+	for _, s := range mc.Scopes {
+		// scope tokens are printable ASCII except space, `"` and `\` (RFC 6749 section 3.3)
+		if s == "" || strings.IndexFunc(s, func(r rune) bool { return r <= ' ' || r > '~' || r == '"' || r == '\\' }) >= 0 {
+			return errors.Errorf(`invalid scope: %q`, s)
+		}
+	}
+	return nil
+}
+
+// validateRedirectURI checks a redirect URI being registered (RFC 6749 section 3.1.2).
+// Plain http is allowed only for loopback addresses used by native apps (RFC 8252).
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return errors.Wrapf(err, `parsing redirect URI %s`, uri)
+	}
+	if !u.IsAbs() || u.Fragment != "" {
+		return errors.Errorf(`redirect URI must be absolute without fragment: %s`, uri)
+	}
+	if u.Scheme == "http" {
+		switch u.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+		default:
+			return errors.Errorf(`redirect URI must use https: %s`, uri)
+		}
+	}
+	return nil
+}
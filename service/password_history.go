@@ -0,0 +1,55 @@
+package service
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// Check returns *utils.PasswordPolicyError if the password is the current
+// password of the user or one of the passwords in the history
+func (h *PasswordHistory) Check(du *db.User, password string) error {
+	if h == nil || h.Size == 0 {
+		return nil
+	}
+	log.Printf("service.PasswordHistory.Check %s", du.ID)
+
+	hashes, err := h.Store.LookupPasswordHistory(du.ID)
+	if err != nil {
+		return errors.Wrap(err, `loading password history`)
+	}
+	if len(hashes) > h.Size {
+		hashes = hashes[:h.Size]
+	}
+	// hashes are compared one by one, since each of them has its own salt and algorithm
+	for _, hash := range append([]string{du.Password}, hashes...) {
+		// legacy hashes are salted with ID+Name
+		ok, _, err := utils.VerifyPassword(hash, password, du.ID+du.Name)
+		if err != nil {
+			return errors.Wrap(err, `verifying password`)
+		}
+		if ok {
+			return &utils.PasswordPolicyError{Violations: []utils.PasswordViolation{{
+				Rule:    utils.RuleHistory,
+				Message: fmt.Sprintf(`password must differ from the last %d passwords`, h.Size),
+			}}}
+		}
+	}
+	return nil
+}
+
+// Record adds the hashed password to the history, forgetting passwords older than Size
+func (h *PasswordHistory) Record(userID, hashed string) error {
+	if h == nil || h.Size == 0 {
+		return nil
+	}
+	log.Printf("service.PasswordHistory.Record %s", userID)
+
+	if err := h.Store.AddPasswordHistory(userID, hashed, h.Size); err != nil {
+		return errors.Wrap(err, `adding password history`)
+	}
+	return nil
+}
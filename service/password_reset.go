@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/mail"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// RequestReset mails a password reset token to the user, replacing tokens sent before.
+// Unknown users and users without email address are ignored silently, not to tell
+// which accounts exist.
+func (v *PasswordResetService) RequestReset(userID string) error {
+	log.Printf("service.PasswordReset.RequestReset %s", userID)
+
+	du, err := v.Store.LookupUser(userID)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil
+		}
+		return errors.Wrap(err, `loading db.User`)
+	}
+	if du.Email == "" {
+		log.Printf("user %s has no email address to send reset token", userID)
+		return nil
+	}
+
+	token, err := utils.RandomToken(resetTokenBytes)
+	if err != nil {
+		return errors.Wrap(err, `generating password reset token`)
+	}
+	lifetime := v.TokenLifetime
+	if lifetime == 0 {
+		lifetime = DefaultPasswordResetTokenLifetime
+	}
+	if err := v.Store.DeleteUserPasswordResetTokens(du.ID); err != nil {
+		return errors.Wrap(err, `deleting password reset tokens`)
+	}
+	if err := v.Store.CreatePasswordResetToken(&db.PasswordResetToken{
+		Hash:      utils.HashToken(token),
+		UserID:    du.ID,
+		ExpiresOn: time.Now().Add(lifetime),
+	}); err != nil {
+		return errors.Wrap(err, `creating password reset token`)
+	}
+
+	if err := v.Sender.Send(&mail.Message{
+		From:    v.From,
+		To:      du.Email,
+		Subject: `Reset your password`,
+		Body:    v.resetMailBody(du.Name, token, lifetime),
+	}); err != nil {
+		return errors.Wrap(err, `sending password reset mail`)
+	}
+	return nil
+}
+
+func (v *PasswordResetService) resetMailBody(name, token string, lifetime time.Duration) string {
+	body := bytes.Buffer{}
+	fmt.Fprintf(&body, "Hello %s,\n\n", name)
+	body.WriteString("A password reset was requested for your account.\n")
+	if v.ResetURL != "" {
+		u, err := url.Parse(v.ResetURL)
+		if err == nil {
+			q := u.Query()
+			q.Set("token", token)
+			u.RawQuery = q.Encode()
+			fmt.Fprintf(&body, "Open the following link to choose a new password:\n\n%s\n\n", u)
+		}
+	}
+	fmt.Fprintf(&body, "Your reset token is:\n\n%s\n\n", token)
+	fmt.Fprintf(&body, "It expires in %s and can be used only once.\n", lifetime)
+	body.WriteString("If you did not request it, you can ignore this mail.\n")
+	return body.String()
+}
+
+// Reset replaces the password of the user the token was issued to, and
+// returns the user ID. ErrInvalidResetToken is returned unless the token is
+// known, unused and unexpired.
+func (v *PasswordResetService) Reset(token, password string) (string, error) {
+	log.Printf("service.PasswordReset.Reset")
+
+	hash := utils.HashToken(token)
+	rt, err := v.Store.LookupPasswordResetToken(hash)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return "", ErrInvalidResetToken
+		}
+		return "", errors.Wrap(err, `loading password reset token`)
+	}
+	if rt.Used || time.Now().After(rt.ExpiresOn) {
+		return "", ErrInvalidResetToken
+	}
+
+	du, err := v.Store.LookupUser(rt.UserID)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return "", ErrInvalidResetToken
+		}
+		return "", errors.Wrap(err, `loading db.User`)
+	}
+	// checked before the token is consumed, so that the user can retry with another password
+	if v.Policy != nil {
+		if err := v.Policy.Check(password, du.ID, du.Name); err != nil {
+			return "", err
+		}
+	}
+	if err := v.History.Check(du, password); err != nil {
+		return "", err
+	}
+	if _, err := v.Store.ConsumePasswordResetToken(hash); err != nil {
+		if cause := errors.Cause(err); cause == sql.ErrNoRows || cause == db.ErrPasswordResetTokenUsed {
+			return "", ErrInvalidResetToken
+		}
+		return "", errors.Wrap(err, `consuming password reset token`)
+	}
+
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return "", errors.Wrap(err, `hashing password`)
+	}
+	du.Password = hashed
+	if err := v.Store.UpdateUser(du); err != nil {
+		return "", errors.Wrap(err, `updating db.User`)
+	}
+	if err := v.History.Record(du.ID, du.Password); err != nil {
+		return "", err
+	}
+	if err := v.Store.DeleteUserPasswordResetTokens(du.ID); err != nil {
+		return "", errors.Wrap(err, `deleting password reset tokens`)
+	}
+	return du.ID, nil
+}
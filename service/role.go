@@ -0,0 +1,172 @@
+package service
+
+import (
+	"log"
+	"sort"
+
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// Create Role
+func (v *RoleService) Create(mr *model.Role) error {
+	log.Printf("service.Role.Create %s", mr.Name)
+
+	if mr.Name == "" {
+		return errors.New(`role name is required`)
+	}
+	var dr db.Role
+	if err := mr.ToDB(&dr); err != nil {
+		return errors.Wrap(err, `converting model.Role to db.Role`)
+	}
+	if err := v.Store.CreateRole(&dr); err != nil {
+		return errors.Wrap(err, `creating db.Role`)
+	}
+	return nil
+}
+
+// Lookup Role
+func (v *RoleService) Lookup(name string) (*model.Role, error) {
+	log.Printf("service.Role.Lookup %s", name)
+
+	dr, err := v.Store.LookupRole(name)
+	if err != nil {
+		return nil, errors.Wrap(err, `loading db.Role`)
+	}
+	var mr model.Role
+	if err := mr.FromDB(dr); err != nil {
+		return nil, errors.Wrap(err, `converting db.Role to model.Role`)
+	}
+	return &mr, nil
+}
+
+// Listup Role
+func (v *RoleService) Listup() (model.RoleList, error) {
+	log.Printf("service.Role.Listup")
+
+	roleList, err := v.Store.ListupRoles()
+	if err != nil {
+		return nil, errors.Wrap(err, `loading role list`)
+	}
+	l := make(model.RoleList, len(roleList))
+	for i, role := range roleList {
+		if err := l[i].FromDB(&role); err != nil {
+			return nil, errors.Wrap(err, `converting db.Role to model.Role`)
+		}
+	}
+	return l, nil
+}
+
+// Delete Role
+func (v *RoleService) Delete(name string) error {
+	log.Printf("service.Role.Delete %s", name)
+
+	if err := v.Store.DeleteRole(name); err != nil {
+		return errors.Wrap(err, `deleting db.Role`)
+	}
+	return nil
+}
+
+// Grant a permission to the role
+func (v *RoleService) Grant(role, permission string) error {
+	log.Printf("service.Role.Grant %s %s", role, permission)
+
+	if err := v.Store.GrantPermission(role, permission); err != nil {
+		return errors.Wrap(err, `granting permission`)
+	}
+	return nil
+}
+
+// Revoke a permission from the role
+func (v *RoleService) Revoke(role, permission string) error {
+	log.Printf("service.Role.Revoke %s %s", role, permission)
+
+	if err := v.Store.RevokePermission(role, permission); err != nil {
+		return errors.Wrap(err, `revoking permission`)
+	}
+	return nil
+}
+
+// Assign the role to an user
+func (v *RoleService) Assign(userID, role string) error {
+	log.Printf("service.Role.Assign %s %s", userID, role)
+
+	if err := v.Store.AssignRole(userID, role); err != nil {
+		return errors.Wrap(err, `assigning role`)
+	}
+	return nil
+}
+
+// Unassign the role from an user
+func (v *RoleService) Unassign(userID, role string) error {
+	log.Printf("service.Role.Unassign %s %s", userID, role)
+
+	if err := v.Store.UnassignRole(userID, role); err != nil {
+		return errors.Wrap(err, `unassigning role`)
+	}
+	return nil
+}
+
+// UserRoles returns names of roles assigned to the user,
+// and the union of their permissions
+func (v *RoleService) UserRoles(userID string) (roles []string, permissions []string, err error) {
+	log.Printf("service.Role.UserRoles %s", userID)
+
+	roleList, err := v.Store.LookupUserRoles(userID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, `loading roles of user`)
+	}
+	roles = []string{}
+	permissions = []string{}
+	seen := map[string]bool{}
+	for _, role := range roleList {
+		roles = append(roles, role.Name)
+		for _, permission := range role.Permissions {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return roles, permissions, nil
+}
+
+// TokenSubject returns the claims source of the user's token
+func (v *RoleService) TokenSubject(u *model.User) (*utils.TokenSubject, error) {
+	roles, permissions, err := v.UserRoles(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &utils.TokenSubject{
+		ID:          u.ID,
+		Username:    u.Name,
+		IsAdmin:     u.IsAdmin,
+		Roles:       roles,
+		Permissions: permissions,
+	}, nil
+}
+
+// ClientTokenSubject returns the subject of an access token delegated to the OAuth client.
+// Admin privilege and roles are not delegated, and permissions are only those granted as scopes.
+func (v *RoleService) ClientTokenSubject(u *model.User, clientID, scope string) (*utils.TokenSubject, error) {
+	_, permissions, err := v.UserRoles(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	granted := []string{}
+	for _, permission := range permissions {
+		if utils.HasScope(scope, permission) {
+			granted = append(granted, permission)
+		}
+	}
+	return &utils.TokenSubject{
+		ID:          u.ID,
+		Username:    u.Name,
+		Permissions: granted,
+		ClientID:    clientID,
+		Scope:       scope,
+	}, nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/charakoba-com/auth-api/db"
+	"github.com/charakoba-com/auth-api/utils"
+	"github.com/pkg/errors"
+)
+
+// IssueRefreshToken issues a refresh token starting a new token family,
+// which keeps the audience of access tokens
+func (v *TokenService) IssueRefreshToken(userID, audience string) (string, error) {
+	return v.issueRefreshToken(&db.RefreshToken{
+		UserID:   userID,
+		Audience: audience,
+	})
+}
+
+// IssueCodeRefreshToken issues a refresh token starting the token family recorded on the
+// authorization code, which can be used only by the OAuth client and keeps the granted scope
+func (v *TokenService) IssueCodeRefreshToken(code *db.AuthorizationCode) (string, error) {
+	return v.issueRefreshToken(&db.RefreshToken{
+		FamilyID: code.FamilyID,
+		UserID:   code.UserID,
+		ClientID: code.ClientID,
+		Scope:    code.Scope,
+	})
+}
+
+// issueRefreshToken stores a refresh token in the family of base, or a new one if it has no family
+func (v *TokenService) issueRefreshToken(base *db.RefreshToken) (string, error) {
+	log.Printf("service.Token.IssueRefreshToken %s", base.UserID)
+
+	if base.FamilyID == "" {
+		familyID, err := utils.RandomToken(refreshTokenBytes)
+		if err != nil {
+			return "", errors.Wrap(err, `generating token family ID`)
+		}
+		base.FamilyID = familyID
+	}
+	token, rt, err := v.newRefreshToken(base)
+	if err != nil {
+		return "", err
+	}
+	if err := v.Store.CreateRefreshToken(rt); err != nil {
+		return "", errors.Wrap(err, `creating db.RefreshToken`)
+	}
+	return token, nil
+}
+
+// Refresh exchanges a refresh token of the client with the next one, and returns it with the
+// presented token, which tells the owner and the scope. Tokens issued by `/auth` have no client ID.
+// When an already rotated token is presented, the whole token family is revoked
+// because either the client or an attacker holds a stolen token.
+func (v *TokenService) Refresh(token, clientID string) (string, *db.RefreshToken, error) {
+	log.Printf("service.Token.Refresh")
+
+	rt, err := v.Store.LookupRefreshToken(utils.HashToken(token))
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return "", nil, ErrInvalidRefreshToken
+		}
+		return "", nil, errors.Wrap(err, `loading db.RefreshToken`)
+	}
+	if rt.Revoked || time.Now().After(rt.ExpiresOn) || rt.ClientID != clientID {
+		return "", nil, ErrInvalidRefreshToken
+	}
+	if rt.RotatedOn.Valid {
+		return "", nil, v.revokeReused(rt)
+	}
+
+	next, nrt, err := v.newRefreshToken(rt)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := v.Store.RotateRefreshToken(rt.Hash, nrt); err != nil {
+		if errors.Cause(err) == db.ErrRefreshTokenRotated {
+			// another request rotated it concurrently
+			return "", nil, v.revokeReused(rt)
+		}
+		return "", nil, errors.Wrap(err, `rotating db.RefreshToken`)
+	}
+	return next, rt, nil
+}
+
+// RevokeRefreshToken revokes the token family of given refresh token
+func (v *TokenService) RevokeRefreshToken(token string) error {
+	log.Printf("service.Token.RevokeRefreshToken")
+
+	rt, err := v.Store.LookupRefreshToken(utils.HashToken(token))
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return ErrInvalidRefreshToken
+		}
+		return errors.Wrap(err, `loading db.RefreshToken`)
+	}
+	if err := v.Store.RevokeRefreshTokenFamily(rt.FamilyID); err != nil {
+		return errors.Wrap(err, `revoking token family`)
+	}
+	return nil
+}
+
+func (v *TokenService) revokeReused(rt *db.RefreshToken) error {
+	log.Printf("refresh token reuse detected: revoking token family of %s", rt.UserID)
+	if err := v.Store.RevokeRefreshTokenFamily(rt.FamilyID); err != nil {
+		return errors.Wrap(err, `revoking token family`)
+	}
+	return ErrRefreshTokenReused
+}
+
+// newRefreshToken returns a token in the family of base, which has the same owner and scope
+func (v *TokenService) newRefreshToken(base *db.RefreshToken) (string, *db.RefreshToken, error) {
+	token, err := utils.RandomToken(refreshTokenBytes)
+	if err != nil {
+		return "", nil, errors.Wrap(err, `generating refresh token`)
+	}
+	now := time.Now()
+	lifetime := v.RefreshTokenLifetime
+	if lifetime == 0 {
+		lifetime = DefaultRefreshTokenLifetime
+	}
+	rt := db.RefreshToken{
+		Hash:      utils.HashToken(token),
+		FamilyID:  base.FamilyID,
+		UserID:    base.UserID,
+		ClientID:  base.ClientID,
+		Scope:     base.Scope,
+		Audience:  base.Audience,
+		CreatedOn: now,
+		ExpiresOn: now.Add(lifetime),
+	}
+	return token, &rt, nil
+}
+
+// Revoke adds the access token into the revocation list until it expires
+func (v *TokenService) Revoke(claims jwt.Claims) error {
+	jti, ok := claims.JWTID()
+	if !ok {
+		return errors.New(`token has no jti`)
+	}
+	log.Printf("service.Token.Revoke %s", jti)
+
+	exp, ok := claims.Expiration()
+	if !ok {
+		return errors.New(`token has no exp`)
+	}
+	now := time.Now()
+	if err := v.Store.PurgeRevokedTokens(now); err != nil {
+		return errors.Wrap(err, `purging expired revocations`)
+	}
+	if err := v.Store.RevokeToken(&db.RevokedToken{JTI: jti, ExpiresOn: exp}); err != nil {
+		return errors.Wrap(err, `revoking token`)
+	}
+	return nil
+}
+
+// RevokeUser revokes all access tokens and refresh tokens issued to the user until now
+func (v *TokenService) RevokeUser(userID string) error {
+	log.Printf("service.Token.RevokeUser %s", userID)
+
+	if err := v.Store.RevokeUserTokens(userID, time.Now()); err != nil {
+		return errors.Wrap(err, `revoking access tokens`)
+	}
+	if err := v.Store.RevokeUserRefreshTokens(userID); err != nil {
+		return errors.Wrap(err, `revoking refresh tokens`)
+	}
+	return nil
+}
+
+// IsRevoked reports whether the access token has been revoked
+// either by itself or by revoking all tokens of its subject
+func (v *TokenService) IsRevoked(claims jwt.Claims) (bool, error) {
+	if jti, ok := claims.JWTID(); ok {
+		revoked, err := v.Store.IsTokenRevoked(jti)
+		if err != nil {
+			return false, errors.Wrap(err, `looking up revocation list`)
+		}
+		if revoked {
+			return true, nil
+		}
+	}
+	sub, ok := claims.Subject()
+	if !ok {
+		return false, nil
+	}
+	revokedOn, err := v.Store.LookupUserTokensRevokedOn(sub)
+	if err != nil {
+		return false, errors.Wrap(err, `looking up user revocation`)
+	}
+	if revokedOn.IsZero() {
+		return false, nil
+	}
+	iat, ok := issuedAt(claims)
+	if !ok {
+		return true, nil
+	}
+	return iat.Before(revokedOn.Truncate(time.Millisecond)), nil
+}
+
+// issuedAt returns iat claim keeping its fraction, which GenerateToken sets in milliseconds
+func issuedAt(claims jwt.Claims) (time.Time, bool) {
+	if iat, ok := claims.Get("iat").(float64); ok {
+		return time.Unix(0, int64(iat*1000+0.5)*int64(time.Millisecond)), true
+	}
+	return claims.IssuedAt()
+}
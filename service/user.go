@@ -3,67 +3,211 @@ package service
 import (
 	"database/sql"
 	"log"
+	"net/mail"
+	"strings"
 
 	"github.com/charakoba-com/auth-api/db"
 	"github.com/charakoba-com/auth-api/model"
+	"github.com/charakoba-com/auth-api/utils"
 	"github.com/pkg/errors"
 )
 
 // Create User
-func (v *UserService) Create(tx *sql.Tx, du *db.User) error {
+func (v *UserService) Create(du *db.User) error {
 	log.Printf("service.User.Create %s", du.ID)
 
-	if err := du.Create(tx); err != nil {
+	email, err := normalizeEmail(du.Email)
+	if err != nil {
+		return err
+	}
+	if err := v.checkPassword(du); err != nil {
+		return err
+	}
+
+	// hash user's password
+	hashed := *du
+	password, err := utils.HashPassword(du.Password)
+	if err != nil {
+		return errors.Wrap(err, `hashing password`)
+	}
+	hashed.Password = password
+	hashed.Email = email
+
+	if err := v.Store.CreateUser(&hashed); err != nil {
 		return errors.Wrap(err, `creating db.User`)
 	}
+	if err := v.History.Record(hashed.ID, hashed.Password); err != nil {
+		return err
+	}
 	return nil
 }
 
 // Lookup User
-func (v *UserService) Lookup(tx *sql.Tx, id string) (*model.User, error) {
+func (v *UserService) Lookup(id string) (*model.User, error) {
 	log.Printf("service.User.Lookup %s", id)
 
 	var mu model.User
-	if err := mu.Load(tx, id); err != nil {
+	if err := mu.Load(v.Store, id); err != nil {
 		return nil, errors.Wrap(err, `loading model.User`)
 	}
 	return &mu, nil
 }
 
-// Update User
-func (v *UserService) Update(tx *sql.Tx, du *db.User) error {
+// Update User, reporting whether the password is changed. The password and the email
+// address are kept if empty, and the email address needs to be verified again if changed.
+// A changed password must not be in the password history.
+func (v *UserService) Update(du *db.User) (passwordChanged bool, err error) {
 	log.Printf("service.User.Update %s", du.ID)
-	if err := du.Update(tx); err != nil {
-		return errors.Wrap(err, `updating db.User`)
+
+	stored, err := v.Store.LookupUser(du.ID)
+	if err != nil {
+		return false, errors.Wrap(err, `loading db.User`)
 	}
-	return nil
+	email, err := normalizeEmail(du.Email)
+	if err != nil {
+		return false, err
+	}
+	if email == "" {
+		email = stored.Email
+	}
+	// an empty or unchanged password is not checked against the policy and the history,
+	// so that the username or the email address can be updated without a new password
+	unchanged, needsRehash := true, false
+	if du.Password == "" {
+		// legacy hashes are salted with the username, so they can not be kept
+		if du.Name != stored.Name && utils.IsLegacyPasswordHash(stored.Password) {
+			return false, ErrPasswordRequired
+		}
+	} else {
+		if err := v.checkPassword(du); err != nil {
+			return false, err
+		}
+		unchanged, needsRehash, err = utils.VerifyPassword(stored.Password, du.Password, stored.ID+stored.Name)
+		if err != nil {
+			return false, errors.Wrap(err, `verifying password`)
+		}
+		if !unchanged {
+			if err := v.History.Check(stored, du.Password); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	// hash user's password, keeping the hash of an unchanged one unless outdated
+	// since legacy hashes are salted with the username
+	hashed := *du
+	if unchanged && !needsRehash {
+		hashed.Password = stored.Password
+	} else {
+		password, err := utils.HashPassword(du.Password)
+		if err != nil {
+			return false, errors.Wrap(err, `hashing password`)
+		}
+		hashed.Password = password
+	}
+	hashed.Email = email
+	hashed.EmailVerified = stored.EmailVerified && stored.Email == email
+
+	if err := v.Store.UpdateUser(&hashed); err != nil {
+		return false, errors.Wrap(err, `updating db.User`)
+	}
+	if !unchanged {
+		if err := v.History.Record(hashed.ID, hashed.Password); err != nil {
+			return false, err
+		}
+	}
+	return !unchanged, nil
 }
 
 // Delete User
-func (v *UserService) Delete(tx *sql.Tx, id string) error {
+func (v *UserService) Delete(id string) error {
 	log.Printf("service.User.Delete %s", id)
 
-	du := db.User{ID: id}
-	if err := du.Delete(tx); err != nil {
+	if err := v.Store.DeleteUser(id); err != nil {
 		return errors.Wrap(err, `deleting db.User`)
 	}
 	return nil
 }
 
-// Listup User
-func (v *UserService) Listup(tx *sql.Tx) (model.UserList, error) {
+// Listup User matching the query
+func (v *UserService) Listup(q *db.UserQuery) (users model.UserList, total int, next string, err error) {
 	log.Printf("service.User.Listup")
 
-	var userList db.UserList
-	if err := userList.Listup(tx); err != nil {
-		return nil, errors.Wrap(err, `loading user list`)
+	total, next, err = users.Load(v.Store, q)
+	if err != nil {
+		return nil, 0, "", errors.Wrap(err, `loading user list`)
+	}
+	return users, total, next, nil
+}
+
+// Authenticate User with password.
+// The stored hash is upgraded to the configured algorithm when it is outdated.
+func (v *UserService) Authenticate(id, password string) (*model.User, error) {
+	log.Printf("service.User.Authenticate %s", id)
+
+	du, err := v.Store.LookupUser(id)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ErrAuthFailed
+		}
+		return nil, errors.Wrap(err, `loading db.User`)
+	}
+	// legacy hashes are salted with ID+Name
+	ok, needsRehash, err := utils.VerifyPassword(du.Password, password, du.ID+du.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, `verifying password`)
+	}
+	if !ok {
+		return nil, ErrAuthFailed
 	}
-	l := make(model.UserList, len(userList))
-	for i, user := range userList {
-		if err := l[i].FromDB(&user); err != nil {
-			return nil, errors.Wrap(err, `converting db.User to model.User`)
+	if needsRehash {
+		if err := v.rehash(du, password); err != nil {
+			// the password is correct anyway, retry at next login
+			log.Printf("rehashing password of %s: %s", id, err)
 		}
 	}
+	if v.RequireVerifiedEmail && !du.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	var mu model.User
+	if err := mu.FromDB(du); err != nil {
+		return nil, errors.Wrap(err, `converting db.User to model.User`)
+	}
+	return &mu, nil
+}
+
+// checkPassword returns *utils.PasswordPolicyError if the password of the user violates the policy
+func (v *UserService) checkPassword(du *db.User) error {
+	if v.Policy == nil {
+		return nil
+	}
+	return v.Policy.Check(du.Password, du.ID, du.Name)
+}
+
+func (v *UserService) rehash(du *db.User, password string) error {
+	log.Printf("service.User.rehash %s", du.ID)
+
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return errors.Wrap(err, `hashing password`)
+	}
+	du.Password = hashed
+	if err := v.Store.UpdateUser(du); err != nil {
+		return errors.Wrap(err, `updating db.User`)
+	}
+	return nil
+}
 
-	return l, nil
+// normalizeEmail validates a bare email address and lowercases it,
+// so that uniqueness is not bypassed by case. Empty means no address.
+func normalizeEmail(email string) (string, error) {
+	if email == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(addr.Address), nil
 }
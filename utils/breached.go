@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BreachedPasswords is an interface which tells whether a password is known to be breached
+type BreachedPasswords interface {
+	Contains(password string) (bool, error)
+}
+
+// sha1PrefixLength is the length of hash prefixes naming files of BreachedPasswordCorpus
+const sha1PrefixLength = 5
+
+// BreachedPasswordCorpus is a local copy of breached password hashes in the format of
+// the k-anonymity range API of Have I Been Pwned: the directory holds a file per
+// 5 hex digit SHA-1 prefix, named `<PREFIX>` or `<PREFIX>.txt`, listing the remaining
+// 35 hex digits of each hash as `<SUFFIX>:<COUNT>` lines. Missing files are empty ranges.
+type BreachedPasswordCorpus struct {
+	Dir string
+}
+
+// Contains reports whether the SHA-1 hash of the password is listed in the corpus
+func (c *BreachedPasswordCorpus) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:sha1PrefixLength], hash[sha1PrefixLength:]
+
+	f, err := c.open(prefix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, `opening range %s`, prefix)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, errors.Wrapf(err, `reading range %s`, prefix)
+	}
+	return false, nil
+}
+
+// open opens the range file of the prefix
+func (c *BreachedPasswordCorpus) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(c.Dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return os.Open(filepath.Join(c.Dir, prefix))
+	}
+	return f, err
+}
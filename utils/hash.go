@@ -1,14 +1,200 @@
 package utils
 
 import (
+	"crypto/rand"
 	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// password hashing algorithms
+const (
+	PasswordBcrypt   = `bcrypt`
+	PasswordScrypt   = `scrypt`
+	PasswordArgon2id = `argon2id`
+	passwordLegacy   = `sha512`
+)
+
+// parameters of password hashing algorithms
+const (
+	bcryptCost     = 12
+	scryptLogN     = 15
+	scryptR        = 8
+	scryptP        = 1
+	argon2Memory   = 64 * 1024
+	argon2Time     = 3
+	argon2Threads  = 2
+	saltLength     = 16
+	passwordKeyLen = 32
 )
 
-// HashPassword hashes given string with sha512
-func HashPassword(password, salt string) string {
-	log.Printf("hash password")
+// BcryptMaxLength is the length of the longest password bcrypt hashes, in bytes
+const BcryptMaxLength = 72
+
+var passwordAlgorithm = PasswordArgon2id
+
+// SetPasswordAlgorithm sets the algorithm used by HashPassword
+func SetPasswordAlgorithm(alg string) error {
+	switch alg {
+	case PasswordBcrypt, PasswordScrypt, PasswordArgon2id:
+		passwordAlgorithm = alg
+		return nil
+	}
+	return errors.Errorf(`unknown password hashing algorithm: %s`, alg)
+}
+
+// PasswordAlgorithm returns the algorithm used by HashPassword
+func PasswordAlgorithm() string {
+	return passwordAlgorithm
+}
+
+// HashPassword hashes given password with the configured algorithm
+// and a random salt. The result is encoded in PHC string format,
+// e.g. `$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>`.
+func HashPassword(password string) (string, error) {
+	log.Printf("hash password with %s", passwordAlgorithm)
+	if passwordAlgorithm == PasswordBcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+		if err != nil {
+			return "", errors.Wrap(err, `hashing password with bcrypt`)
+		}
+		return string(hashed), nil
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, `generating salt`)
+	}
+	switch passwordAlgorithm {
+	case PasswordScrypt:
+		key, err := scrypt.Key([]byte(password), salt, 1<<scryptLogN, scryptR, scryptP, passwordKeyLen)
+		if err != nil {
+			return "", errors.Wrap(err, `hashing password with scrypt`)
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", scryptLogN, scryptR, scryptP, b64(salt), b64(key)), nil
+	case PasswordArgon2id:
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, passwordKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads, b64(salt), b64(key)), nil
+	}
+	return "", errors.Errorf(`unknown password hashing algorithm: %s`, passwordAlgorithm)
+}
+
+// VerifyPassword compares given password with the encoded hash in constant time.
+// legacySalt is only used for legacy SHA-512 hashes, which are salted with ID+Name.
+// needsRehash reports that the hash should be replaced with HashPassword's one.
+func VerifyPassword(encoded, password, legacySalt string) (ok bool, needsRehash bool, err error) {
+	alg, err := passwordHashAlgorithm(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	switch alg {
+	case passwordLegacy:
+		ok = subtle.ConstantTimeCompare([]byte(encoded), []byte(legacyHashPassword(password, legacySalt))) == 1
+		return ok, ok, nil
+	case PasswordBcrypt:
+		if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				return false, false, nil
+			}
+			return false, false, errors.Wrap(err, `comparing bcrypt hash`)
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, false, errors.Wrap(err, `reading bcrypt cost`)
+		}
+		return true, alg != passwordAlgorithm || cost != bcryptCost, nil
+	case PasswordScrypt:
+		var ln, r, p int
+		salt, key, err := parsePHC(encoded, "$scrypt$ln=%d,r=%d,p=%d", &ln, &r, &p)
+		if err != nil {
+			return false, false, err
+		}
+		computed, err := scrypt.Key([]byte(password), salt, 1<<uint(ln), r, p, len(key))
+		if err != nil {
+			return false, false, errors.Wrap(err, `hashing password with scrypt`)
+		}
+		if subtle.ConstantTimeCompare(key, computed) != 1 {
+			return false, false, nil
+		}
+		return true, alg != passwordAlgorithm || ln != scryptLogN || r != scryptR || p != scryptP, nil
+	case PasswordArgon2id:
+		var v, m, t, p int
+		salt, key, err := parsePHC(encoded, "$argon2id$v=%d$m=%d,t=%d,p=%d", &v, &m, &t, &p)
+		if err != nil {
+			return false, false, err
+		}
+		if v != argon2.Version {
+			return false, false, errors.Errorf(`unsupported argon2 version: %d`, v)
+		}
+		computed := argon2.IDKey([]byte(password), salt, uint32(t), uint32(m), uint8(p), uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, computed) != 1 {
+			return false, false, nil
+		}
+		return true, alg != passwordAlgorithm || m != argon2Memory || t != argon2Time || p != argon2Threads, nil
+	}
+	return false, false, errors.Errorf(`unknown password hashing algorithm: %s`, alg)
+}
+
+// IsLegacyPasswordHash reports whether the encoded hash is a legacy SHA-512 one,
+// which is salted with ID+Name and not verified after the username changes
+func IsLegacyPasswordHash(encoded string) bool {
+	alg, err := passwordHashAlgorithm(encoded)
+	return err == nil && alg == passwordLegacy
+}
+
+// passwordHashAlgorithm detects the algorithm of encoded password hash
+func passwordHashAlgorithm(encoded string) (string, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return PasswordBcrypt, nil
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		return PasswordScrypt, nil
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return PasswordArgon2id, nil
+	case len(encoded) == sha512.Size*2 && !strings.HasPrefix(encoded, "$"):
+		return passwordLegacy, nil
+	}
+	return "", errors.New(`unknown password hash format`)
+}
+
+// parsePHC parses `<format>$<salt>$<hash>`, where format scans parameters
+func parsePHC(encoded, format string, params ...interface{}) (salt, key []byte, err error) {
+	i := strings.LastIndex(encoded, "$")
+	j := strings.LastIndex(encoded[:i], "$")
+	if j <= 0 {
+		return nil, nil, errors.New(`malformed password hash`)
+	}
+	if _, err := fmt.Sscanf(encoded[:j], format, params...); err != nil {
+		return nil, nil, errors.Wrap(err, `parsing password hash parameters`)
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(encoded[j+1 : i])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, `decoding salt`)
+	}
+	key, err = base64.RawStdEncoding.DecodeString(encoded[i+1:])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, `decoding hash`)
+	}
+	return salt, key, nil
+}
+
+func b64(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+// legacyHashPassword hashes given string with sha512.
+// This was the only password hashing scheme before PHC strings were introduced,
+// and is kept to verify passwords which have not been rehashed yet.
+func legacyHashPassword(password, salt string) string {
 	hash := sha512.New()
 	for i := 0; i < 29; i++ {
 		hash.Reset()
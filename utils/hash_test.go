@@ -0,0 +1,82 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+func TestHashPassword(t *testing.T) {
+	defer utils.SetPasswordAlgorithm(utils.PasswordAlgorithm())
+
+	for _, alg := range []string{utils.PasswordBcrypt, utils.PasswordScrypt, utils.PasswordArgon2id} {
+		if err := utils.SetPasswordAlgorithm(alg); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		hashed, err := utils.HashPassword("testpasswd")
+		if err != nil {
+			t.Errorf("%s: %s", alg, err)
+			return
+		}
+		another, err := utils.HashPassword("testpasswd")
+		if err != nil {
+			t.Errorf("%s: %s", alg, err)
+			return
+		}
+		if hashed == another {
+			t.Errorf("%s: hashes should be salted randomly", alg)
+			return
+		}
+		ok, needsRehash, err := utils.VerifyPassword(hashed, "testpasswd", "")
+		if err != nil || !ok || needsRehash {
+			t.Errorf("%s: %t %t %v", alg, ok, needsRehash, err)
+			return
+		}
+		ok, _, err = utils.VerifyPassword(hashed, "hogepasswd", "")
+		if err != nil || ok {
+			t.Errorf("%s: wrong password should not be verified: %v", alg, err)
+			return
+		}
+	}
+
+	// hashes of other algorithms are marked as outdated
+	utils.SetPasswordAlgorithm(utils.PasswordBcrypt)
+	bcrypted, _ := utils.HashPassword("testpasswd")
+	utils.SetPasswordAlgorithm(utils.PasswordArgon2id)
+	if ok, needsRehash, err := utils.VerifyPassword(bcrypted, "testpasswd", ""); err != nil || !ok || !needsRehash {
+		t.Errorf("%t %t %v", ok, needsRehash, err)
+		return
+	}
+}
+
+func TestVerifyPasswordLegacy(t *testing.T) {
+	legacy := "19b4b8ed555a76a5c635211c7aaeaea5f964925ebf5cdf8af236fb01ee3842525453eea927f61cfafbe66277551151e96938162599f87a05f84dab621fbc315d"
+	ok, needsRehash, err := utils.VerifyPassword(legacy, "testpasswd", "updateIDupdateuser")
+	if err != nil || !ok || !needsRehash {
+		t.Errorf("%t %t %v", ok, needsRehash, err)
+		return
+	}
+	if ok, _, _ := utils.VerifyPassword(legacy, "testpasswd", "updateIDrenamed"); ok {
+		t.Errorf("legacy hash should be salted with ID+Name")
+		return
+	}
+	if !utils.IsLegacyPasswordHash(legacy) {
+		t.Errorf("legacy hash is not detected")
+		return
+	}
+	hashed, err := utils.HashPassword("testpasswd")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if utils.IsLegacyPasswordHash(hashed) {
+		t.Errorf("%s is taken as legacy hash", hashed)
+		return
+	}
+	if _, _, err := utils.VerifyPassword("testpasswd", "testpasswd", ""); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("unknown hash format should be an error: %v", err)
+		return
+	}
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// character classes a password policy can require
+const (
+	CharacterLower  = `lower`
+	CharacterUpper  = `upper`
+	CharacterDigit  = `digit`
+	CharacterSymbol = `symbol`
+)
+
+// rules of PasswordPolicy reported in PasswordViolation
+const (
+	RuleMinLength = `min_length`
+	RuleMaxLength = `max_length`
+	RuleCharacter = `character_class`
+	RuleUserInfo  = `user_info`
+	RuleBreached  = `breached`
+	RuleHistory   = `history`
+)
+
+// PasswordPolicy decides which passwords users can choose
+type PasswordPolicy struct {
+	MinLength       int               // in characters
+	MaxLength       int               // in characters, unlimited if zero
+	RequiredClasses []string          // CharacterLower, CharacterUpper, CharacterDigit or CharacterSymbol
+	RejectUserInfo  bool              // reject passwords equal to the user ID or the username
+	Breached        BreachedPasswords // not checked if nil
+}
+
+// PasswordViolation is a rule of PasswordPolicy a password does not satisfy
+type PasswordViolation struct {
+	Rule    string
+	Message string
+}
+
+// PasswordPolicyError is returned for a password violating PasswordPolicy
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	msg := bytes.Buffer{}
+	msg.WriteString(`password does not satisfy the policy`)
+	for i, v := range e.Violations {
+		if i == 0 {
+			msg.WriteString(`: `)
+		} else {
+			msg.WriteString(`, `)
+		}
+		msg.WriteString(v.Message)
+	}
+	return msg.String()
+}
+
+// ValidCharacterClass reports whether the class can be required by PasswordPolicy
+func ValidCharacterClass(class string) bool {
+	switch class {
+	case CharacterLower, CharacterUpper, CharacterDigit, CharacterSymbol:
+		return true
+	}
+	return false
+}
+
+// characterClass returns the class of r
+func characterClass(r rune) string {
+	switch {
+	case unicode.IsLower(r):
+		return CharacterLower
+	case unicode.IsUpper(r):
+		return CharacterUpper
+	case unicode.IsDigit(r):
+		return CharacterDigit
+	}
+	return CharacterSymbol
+}
+
+// Check returns *PasswordPolicyError listing every rule the password violates.
+// userInfo is the user ID and the username of the user choosing the password.
+// Other errors are returned if the breached password corpus cannot be read.
+func (p *PasswordPolicy) Check(password string, userInfo ...string) error {
+	var violations []PasswordViolation
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(RuleMinLength, `password must be at least %d characters`, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(RuleMaxLength, `password must be at most %d characters`, p.MaxLength)
+	}
+	classes := map[string]bool{}
+	for _, r := range password {
+		classes[characterClass(r)] = true
+	}
+	for _, class := range p.RequiredClasses {
+		if !classes[class] {
+			add(RuleCharacter, `password must contain a %s character`, class)
+		}
+	}
+	if p.RejectUserInfo {
+		for _, info := range userInfo {
+			if info != "" && strings.EqualFold(password, info) {
+				add(RuleUserInfo, `password must not be the user ID or the username`)
+				break
+			}
+		}
+	}
+	// the corpus is not looked up for passwords rejected anyway
+	if p.Breached != nil && len(violations) == 0 {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return errors.Wrap(err, `looking up breached passwords`)
+		}
+		if breached {
+			add(RuleBreached, `password has appeared in a data breach`)
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package utils_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := utils.PasswordPolicy{
+		MinLength:       8,
+		MaxLength:       16,
+		RequiredClasses: []string{utils.CharacterUpper, utils.CharacterDigit},
+		RejectUserInfo:  true,
+		Breached:        &utils.BreachedPasswordCorpus{Dir: "../test/breached"},
+	}
+	for _, c := range []struct {
+		password string
+		rules    []string
+	}{
+		{"Correct1Horse", nil},
+		{"", []string{utils.RuleMinLength, utils.RuleCharacter, utils.RuleCharacter}},
+		{"ぱすわーど1Ａ", []string{utils.RuleMinLength}}, // length in characters
+		{"Correct1HorseBatteryStaple", []string{utils.RuleMaxLength}},
+		{"correcthorse", []string{utils.RuleCharacter, utils.RuleCharacter}},
+		{"ALICE12345", []string{utils.RuleUserInfo}},
+		{"P@ssw0rd2024!", []string{utils.RuleBreached}},
+	} {
+		err := policy.Check(c.password, "alice12345", "Alice")
+		if c.rules == nil {
+			if err != nil {
+				t.Errorf("%q: %s", c.password, err)
+				return
+			}
+			continue
+		}
+		policyErr, ok := err.(*utils.PasswordPolicyError)
+		if !ok {
+			t.Errorf("%q: PasswordPolicyError is expected, but %v", c.password, err)
+			return
+		}
+		var rules []string
+		for _, v := range policyErr.Violations {
+			rules = append(rules, v.Rule)
+		}
+		if !reflect.DeepEqual(rules, c.rules) {
+			t.Errorf("%q: %v != %v", c.password, rules, c.rules)
+			return
+		}
+	}
+}
+
+func TestBreachedPasswordCorpus(t *testing.T) {
+	corpus := utils.BreachedPasswordCorpus{Dir: "../test/breached"}
+	for password, expected := range map[string]bool{
+		"password":      true,
+		"letmein12345":  true,
+		"Password":      false, // in a missing range
+		"correct horse": false,
+	} {
+		breached, err := corpus.Contains(password)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if breached != expected {
+			t.Errorf("%q: %t != %t", password, breached, expected)
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// PKCEMethodS256 is the only code challenge method accepted (RFC 7636)
+const PKCEMethodS256 = `S256`
+
+// pkceFormat matches code verifiers and S256 challenges: 43 to 128 unreserved characters
+var pkceFormat = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// ValidPKCEChallenge reports whether the code challenge is well-formed
+func ValidPKCEChallenge(challenge string) bool {
+	return pkceFormat.MatchString(challenge)
+}
+
+// PKCEChallenge returns the S256 code challenge of the code verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE compares the S256 challenge of the code verifier with the code challenge in constant time
+func VerifyPKCE(verifier, challenge string) bool {
+	if !pkceFormat.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,39 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636 Appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if utils.PKCEChallenge(verifier) != challenge {
+		t.Errorf("%s != %s", utils.PKCEChallenge(verifier), challenge)
+		return
+	}
+	if !utils.ValidPKCEChallenge(challenge) {
+		t.Errorf("%s is not valid", challenge)
+		return
+	}
+	if !utils.VerifyPKCE(verifier, challenge) {
+		t.Errorf("verifier is rejected")
+		return
+	}
+	if utils.VerifyPKCE(verifier[:42]+"A", challenge) {
+		t.Errorf("wrong verifier is accepted")
+		return
+	}
+	// plain method is not supported
+	if utils.VerifyPKCE(challenge, challenge) {
+		t.Errorf("plain verifier is accepted")
+		return
+	}
+	if utils.VerifyPKCE("short", utils.PKCEChallenge("short")) {
+		t.Errorf("too short verifier is accepted")
+		return
+	}
+}
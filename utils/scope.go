@@ -0,0 +1,18 @@
+package utils
+
+import "strings"
+
+// ParseScope splits a space separated scope (RFC 6749 section 3.3)
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// HasScope reports whether the space separated scope includes s
+func HasScope(scope, s string) bool {
+	for _, granted := range ParseScope(scope) {
+		if granted == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,31 +1,389 @@
 package utils
 
 import (
+	"context"
+	"crypto/subtle"
+	"log"
 	"time"
 
-	"github.com/SermoDigital/jose/crypto"
 	"github.com/SermoDigital/jose/jws"
+	"github.com/SermoDigital/jose/jwt"
 	"github.com/charakoba-com/auth-api/keymgr"
 	"github.com/pkg/errors"
 )
 
-// GenerateToken generates a JSON Web Token
-func GenerateToken(username string, isAdmin bool) (string, error) {
+// defaults of TokenIssuer
+const (
+	DefaultAccessTokenLifetime            = 15 * time.Minute
+	DefaultAudience                       = "authapi"
+	DefaultClockSkew                      = 30 * time.Second
+	DefaultMFATokenLifetime               = 5 * time.Minute
+	DefaultEmailVerificationTokenLifetime = 24 * time.Hour
+	DefaultAuthorizeFormTokenLifetime     = 10 * time.Minute
+	DefaultIssuer                         = "http://localhost:8080"
+)
+
+// TokenIssuer issues tokens signed with the keys of its key manager, and validates them.
+// Each server has its own, so that servers in a process do not share settings.
+type TokenIssuer struct {
+	Keys *keymgr.KeyManager
+
+	// Issuer is the URL identifying this server, which is the `iss` claim of every token
+	Issuer string
+	// Audience is the `aud` claim of tokens issued by `/auth` unless another audience is requested
+	Audience string
+	// AccessTokenLifetime is the lifetime of access tokens and ID tokens.
+	// Clients keep their sessions with refresh tokens.
+	AccessTokenLifetime time.Duration
+	// AudienceLifetimes overrides AccessTokenLifetime for access tokens of the audience,
+	// which is an audience requested at `/auth` or an OAuth client ID
+	AudienceLifetimes map[string]time.Duration
+	// ClockSkew is tolerated in validating exp, nbf and iat claims
+	ClockSkew time.Duration
+	// MFATokenLifetime is the lifetime of tokens made by GenerateMFAToken
+	MFATokenLifetime time.Duration
+	// EmailVerificationTokenLifetime is the lifetime of tokens made by GenerateEmailVerificationToken
+	EmailVerificationTokenLifetime time.Duration
+	// AuthorizeFormTokenLifetime is the lifetime of tokens made by GenerateAuthorizeFormToken
+	AuthorizeFormTokenLifetime time.Duration
+	// Refresh reloads Keys if given. It is called before keys are used, and with the key ID
+	// of a token signed with a key which is not found, so that keys rotated by replicas are seen.
+	Refresh func(kid string) error
+
+	ctx context.Context // of the request tokens are signed for
+}
+
+// NewTokenIssuer returns an issuer signing with keys, configured with the defaults
+func NewTokenIssuer(keys *keymgr.KeyManager) *TokenIssuer {
+	return &TokenIssuer{
+		Keys:                           keys,
+		Issuer:                         DefaultIssuer,
+		Audience:                       DefaultAudience,
+		AccessTokenLifetime:            DefaultAccessTokenLifetime,
+		AudienceLifetimes:              map[string]time.Duration{},
+		ClockSkew:                      DefaultClockSkew,
+		MFATokenLifetime:               DefaultMFATokenLifetime,
+		EmailVerificationTokenLifetime: DefaultEmailVerificationTokenLifetime,
+		AuthorizeFormTokenLifetime:     DefaultAuthorizeFormTokenLifetime,
+	}
+}
+
+// WithContext returns a copy of the issuer signing tokens with ctx,
+// so that a remote signer is not waited for after the request is canceled
+func (ti *TokenIssuer) WithContext(ctx context.Context) *TokenIssuer {
+	issuer := *ti
+	issuer.ctx = ctx
+	return &issuer
+}
+
+// MaxLifetime returns the longest lifetime of tokens the issuer signs.
+// Retired keys must be published at least this long, with ClockSkew.
+func (ti *TokenIssuer) MaxLifetime() time.Duration {
+	max := ti.AccessTokenLifetime
+	for _, lifetime := range []time.Duration{ti.MFATokenLifetime, ti.EmailVerificationTokenLifetime, ti.AuthorizeFormTokenLifetime} {
+		if lifetime > max {
+			max = lifetime
+		}
+	}
+	for _, lifetime := range ti.AudienceLifetimes {
+		if lifetime > max {
+			max = lifetime
+		}
+	}
+	return max
+}
+
+// values of `token_use` claim
+const (
+	TokenUseAccess = `access`
+	TokenUseMFA    = `mfa`
+	TokenUseID     = `id`
+	TokenUseEmail  = `email_verification`
+	TokenUseForm   = `authorize_form`
+)
+
+// TokenSubject is the user a token is issued to
+type TokenSubject struct {
+	ID          string
+	Username    string
+	IsAdmin     bool
+	Roles       []string
+	Permissions []string
+	ClientID    string // OAuth client the token is issued to, if any
+	Scope       string // space separated scopes granted to the client
+	Audience    string // ClientID or the audience of the issuer if empty
+}
+
+// TokenAudience returns the `aud` claim of the access token for the subject
+func (ti *TokenIssuer) TokenAudience(sub *TokenSubject) string {
+	switch {
+	case sub.Audience != "":
+		return sub.Audience
+	case sub.ClientID != "":
+		return sub.ClientID
+	}
+	return ti.Audience
+}
+
+// KnownAudience reports whether tokens can be issued for the audience requested at `/auth`
+func (ti *TokenIssuer) KnownAudience(audience string) bool {
+	if audience == ti.Audience {
+		return true
+	}
+	_, ok := ti.AudienceLifetimes[audience]
+	return ok
+}
+
+// AccessTokenLifetimeFor returns the lifetime of access tokens of the audience
+func (ti *TokenIssuer) AccessTokenLifetimeFor(audience string) time.Duration {
+	if lifetime, ok := ti.AudienceLifetimes[audience]; ok {
+		return lifetime
+	}
+	return ti.AccessTokenLifetime
+}
+
+// GenerateToken generates a JSON Web Token for the user.
+// Roles and permissions are embedded to let services authorize requests by themselves.
+func (ti *TokenIssuer) GenerateToken(sub *TokenSubject) (string, error) {
+	audience := ti.TokenAudience(sub)
+	claims := jws.Claims{}
+	claims.SetSubject(sub.ID)
+	claims.SetAudience(audience)
+	claims.Set("username", sub.Username)
+	// admin privilege and roles are never delegated to OAuth clients
+	if sub.ClientID == "" {
+		claims.Set("is_admin", sub.IsAdmin)
+		claims.Set("roles", nonNil(sub.Roles))
+	} else {
+		claims.Set("client_id", sub.ClientID)
+		claims.Set("scope", sub.Scope)
+	}
+	claims.Set("permissions", nonNil(sub.Permissions))
+	claims.Set("token_use", TokenUseAccess)
+	return ti.signClaims(claims, ti.AccessTokenLifetimeFor(audience))
+}
+
+// GenerateClientToken generates an access token for the client itself,
+// issued at the client_credentials grant. It carries no user claims.
+func (ti *TokenIssuer) GenerateClientToken(clientID, scope string) (string, error) {
 	claims := jws.Claims{}
+	claims.SetSubject(clientID)
+	claims.SetAudience(clientID)
+	claims.Set("client_id", clientID)
+	claims.Set("scope", scope)
+	claims.Set("token_use", TokenUseAccess)
+	return ti.signClaims(claims, ti.AccessTokenLifetimeFor(clientID))
+}
+
+// IDTokenSubject is the authentication an OpenID Connect ID token tells the client about
+type IDTokenSubject struct {
+	UserID   string
+	ClientID string
+	Nonce    string
+	AuthTime time.Time
+}
+
+// GenerateIDToken generates an OpenID Connect ID token for the client.
+// Claims about the user are released by the userinfo endpoint.
+func (ti *TokenIssuer) GenerateIDToken(sub *IDTokenSubject) (string, error) {
+	claims := jws.Claims{}
+	claims.SetSubject(sub.UserID)
+	claims.SetAudience(sub.ClientID)
+	claims.Set("auth_time", sub.AuthTime.Unix())
+	if sub.Nonce != "" {
+		claims.Set("nonce", sub.Nonce)
+	}
+	claims.Set("token_use", TokenUseID)
+	return ti.signClaims(claims, ti.AccessTokenLifetime)
+}
+
+// GenerateMFAToken generates a short-lived token, which proves that the user
+// has passed the first factor and is exchanged with an access token at the second step
+func (ti *TokenIssuer) GenerateMFAToken(userID string) (string, error) {
+	claims := jws.Claims{}
+	claims.SetSubject(userID)
+	claims.SetAudience(ti.Audience)
+	claims.Set("token_use", TokenUseMFA)
+	return ti.signClaims(claims, ti.MFATokenLifetime)
+}
+
+// GenerateEmailVerificationToken generates a token mailed to the address of the user,
+// which proves that the user receives mails there
+func (ti *TokenIssuer) GenerateEmailVerificationToken(userID, email string) (string, error) {
+	claims := jws.Claims{}
+	claims.SetSubject(userID)
+	claims.SetAudience(ti.Audience)
+	claims.Set("email", email)
+	claims.Set("token_use", TokenUseEmail)
+	return ti.signClaims(claims, ti.EmailVerificationTokenLifetime)
+}
+
+// GenerateAuthorizeFormToken generates an anti-CSRF token embedded in the login form of
+// `/oauth/authorize`, which is bound to the browser and to the authorization request by their hashes
+func (ti *TokenIssuer) GenerateAuthorizeFormToken(browser, request string) (string, error) {
+	claims := jws.Claims{}
+	claims.SetAudience(ti.Audience)
+	claims.Set("browser", HashToken(browser))
+	claims.Set("request", HashToken(request))
+	claims.Set("token_use", TokenUseForm)
+	return ti.signClaims(claims, ti.AuthorizeFormTokenLifetime)
+}
+
+// signClaims sets iss, iat, nbf, exp and jti, and signs claims with the active key
+func (ti *TokenIssuer) signClaims(claims jws.Claims, lifetime time.Duration) (string, error) {
 	now := time.Now()
-	expiration := now.Add(time.Duration(168) * time.Hour)
-	claims.Set("username", username)
-	claims.Set("is_admin", isAdmin)
-	claims.SetExpiration(expiration)
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", errors.Wrap(err, `generating token ID`)
+	}
+	claims.SetIssuer(ti.Issuer)
+	// iat keeps milliseconds to be compared with revocation time precisely
+	claims.Set("iat", float64(now.UnixNano()/int64(time.Millisecond))/1000)
+	claims.SetNotBefore(now)
+	claims.SetExpiration(now.Add(lifetime))
+	claims.SetJWTID(jti)
 
-	jwt := jws.NewJWT(claims, crypto.SigningMethodRS256)
-	privateKey, err := keymgr.PrivateKey()
+	ti.refresh("")
+	key, err := ti.Keys.ActiveKey()
 	if err != nil {
-		return "", errors.Wrap(err, `loading private key`)
+		return "", errors.Wrap(err, `loading signing key`)
+	}
+	jwt := jws.NewJWT(claims, keymgr.SigningMethod(key.Algorithm))
+	jwt.(jws.JWS).Protected().Set("kid", key.ID)
+	ctx := ti.ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	token, err := jwt.Serialize(privateKey)
+	token, err := jwt.Serialize(key.SignerContext(ctx))
 	if err != nil {
 		return "", errors.Wrap(err, `serialize token`)
 	}
 	return string(token), nil
 }
+
+// ParseToken parses a serialized JSON Web Token without verifying it
+func ParseToken(token string) (jwt.JWT, error) {
+	parsed, err := jws.ParseJWT([]byte(token))
+	if err != nil {
+		return nil, errors.Wrap(err, `parsing token`)
+	}
+	return parsed, nil
+}
+
+// ValidateToken verifies signature, issuer and expiration of the access token
+func (ti *TokenIssuer) ValidateToken(token jwt.JWT) error {
+	if err := ti.validateToken(token); err != nil {
+		return err
+	}
+	// tokens issued before token_use was introduced are access tokens
+	if use, ok := token.Claims().Get("token_use").(string); ok && use != TokenUseAccess {
+		return errors.Errorf(`%s token is not an access token`, use)
+	}
+	return nil
+}
+
+// ValidateMFAToken verifies signature, issuer and expiration of the token made by GenerateMFAToken
+func (ti *TokenIssuer) ValidateMFAToken(token jwt.JWT) error {
+	if err := ti.validateToken(token); err != nil {
+		return err
+	}
+	if use, _ := token.Claims().Get("token_use").(string); use != TokenUseMFA {
+		return errors.New(`token is not a MFA token`)
+	}
+	return nil
+}
+
+// ValidateEmailVerificationToken verifies signature, issuer and expiration of the token
+// made by GenerateEmailVerificationToken, and returns the user ID and the email address
+func (ti *TokenIssuer) ValidateEmailVerificationToken(token jwt.JWT) (string, string, error) {
+	if err := ti.validateToken(token); err != nil {
+		return "", "", err
+	}
+	claims := token.Claims()
+	if use, _ := claims.Get("token_use").(string); use != TokenUseEmail {
+		return "", "", errors.New(`token is not an email verification token`)
+	}
+	userID, _ := claims.Subject()
+	email, _ := claims.Get("email").(string)
+	if userID == "" || email == "" {
+		return "", "", errors.New(`token has no user ID or email address`)
+	}
+	return userID, email, nil
+}
+
+// ValidateAuthorizeFormToken verifies signature, issuer and expiration of the token made by
+// GenerateAuthorizeFormToken, and that it is bound to the browser and the authorization request
+func (ti *TokenIssuer) ValidateAuthorizeFormToken(token jwt.JWT, browser, request string) error {
+	if err := ti.validateToken(token); err != nil {
+		return err
+	}
+	claims := token.Claims()
+	if use, _ := claims.Get("token_use").(string); use != TokenUseForm {
+		return errors.New(`token is not an authorize form token`)
+	}
+	b, _ := claims.Get("browser").(string)
+	r, _ := claims.Get("request").(string)
+	if browser == "" || subtle.ConstantTimeCompare([]byte(b), []byte(HashToken(browser))) != 1 || r != HashToken(request) {
+		return errors.New(`token is not issued for the form`)
+	}
+	return nil
+}
+
+// ValidateAudience verifies that the token is issued for the audience
+func ValidateAudience(claims jwt.Claims, audience string) error {
+	aud, _ := claims.Audience()
+	for _, a := range aud {
+		if a == audience {
+			return nil
+		}
+	}
+	return errors.Errorf(`token is not issued for %s`, audience)
+}
+
+// validateToken verifies the signature, the issuer, and exp, nbf and iat claims
+// tolerating ClockSkew between this server and the one which issued the token
+func (ti *TokenIssuer) validateToken(token jwt.JWT) error {
+	// tokens issued before key rotation was introduced have no key ID
+	var key keymgr.Key
+	var err error
+	ti.refresh("")
+	if kid, ok := token.(jws.JWS).Protected().Get("kid").(string); ok {
+		if key, err = ti.Keys.LookupKey(kid); err != nil && ti.Refresh != nil {
+			ti.refresh(kid)
+			key, err = ti.Keys.LookupKey(kid)
+		}
+	} else {
+		key, err = ti.Keys.ActiveKey()
+	}
+	if err != nil {
+		return errors.Wrap(err, `loading public key`)
+	}
+	validator := jws.NewValidator(jws.Claims{}, ti.ClockSkew, ti.ClockSkew, nil)
+	validator.SetIssuer(ti.Issuer)
+	// the algorithm is decided by the key, not by the alg header of the token
+	if err := token.Validate(key.PublicKey, keymgr.SigningMethod(key.Algorithm), validator); err != nil {
+		return errors.Wrap(err, `validating token`)
+	}
+	if iat, ok := token.Claims().Get("iat").(float64); ok && iat > float64(time.Now().Add(ti.ClockSkew).Unix()) {
+		return errors.New(`token is issued in the future`)
+	}
+	return nil
+}
+
+// refresh reloads keys, keeping loaded ones on failure
+func (ti *TokenIssuer) refresh(kid string) {
+	if ti.Refresh == nil {
+		return
+	}
+	if err := ti.Refresh(kid); err != nil {
+		log.Printf("refreshing keys: %s", err)
+	}
+}
+
+// nonNil returns an empty slice for nil, which is encoded as `[]` instead of `null`
+func nonNil(l []string) []string {
+	if l == nil {
+		return []string{}
+	}
+	return l
+}
@@ -0,0 +1,54 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charakoba-com/auth-api/utils"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 Appendix B (SHA1), truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if code != expected {
+			t.Errorf("%d: %s != %s", unix, code, expected)
+			return
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	now := time.Now()
+	previous, _ := utils.TOTPCode(secret, utils.TOTPStep(now)-1)
+	if step, ok, err := utils.ValidateTOTP(secret, previous, now); err != nil || !ok || step != utils.TOTPStep(now)-1 {
+		t.Errorf("code of previous step should be accepted: %t %v", ok, err)
+		return
+	}
+	stale, _ := utils.TOTPCode(secret, utils.TOTPStep(now)-3)
+	if _, ok, _ := utils.ValidateTOTP(secret, stale, now); ok {
+		t.Errorf("stale code should be rejected")
+		return
+	}
+	uri := utils.TOTPProvisioningURI("authapi", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/authapi:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected provisioning URI: %s", uri)
+		return
+	}
+}